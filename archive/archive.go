@@ -0,0 +1,538 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// An archive run writes one gzip compressed JSONL file per day, a chain
+// goes to the day its first transaction was created, and then a manifest
+// listing the files with their checksums:
+//
+//	2023/01/02/transactions-20230301T120000.000000000.jsonl.gz
+//	manifests/20230301T120000.000000000.json
+//
+// The manifest is written last, an archive without one is incomplete.
+// With a keyring the customer emails and phones are encrypted like in the
+// store, archives written without one are still readable with it.
+
+const (
+	// ManifestVersion is the version of the manifest and file format
+	ManifestVersion = 1
+
+	manifestsDir = "manifests"
+	runFormat    = "20060102T150405.000000000"
+)
+
+var (
+	ErrManifestNotFound = model.NewNotFoundError("archive_not_found", "archive manifest not found")
+	ErrCorrupt          = model.NewPreconditionFailedError("manifest", "archive_corrupt", "archive file does not match its manifest")
+	ErrNoKeyring        = errors.New("archived personal data is encrypted but no keyring is configured")
+)
+
+type Manifest struct {
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	Transactions int       `json:"transactions"`
+	Files        []File    `json:"files"`
+}
+
+type File struct {
+	// Path is relative to the archive directory
+	Path         string `json:"path"`
+	Date         string `json:"date"`
+	Transactions int    `json:"transactions"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+}
+
+// Record is a line of an archive file, the customer data is encrypted when
+// the archive has a keyring
+type Record struct {
+	Id            uuid.UUID `json:"uuid"`
+	ParentId      uuid.UUID `json:"parent_uuid"`
+	MerchantId    uuid.UUID `json:"merchant_uuid"`
+	Type          string    `json:"type"`
+	Amount        int64     `json:"amount"`
+	Status        string    `json:"status"`
+	CustomerEmail string    `json:"customer_email"`
+	CustomerPhone string    `json:"customer_phone"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Archive struct {
+	dir   string
+	clock model.Clock
+	// keyring is nil when personal data is archived in plain text
+	keyring *keyring.Keyring
+
+	// mu keeps Erase from missing a run being written or rewriting a file
+	// being read
+	mu sync.Mutex
+}
+
+// New returns the archive in dir encrypting personal data with k, a nil k
+// archives it in plain text and a nil clock means time.Now
+func New(dir string, k *keyring.Keyring, clock model.Clock) *Archive {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	return &Archive{
+		dir:     dir,
+		clock:   clock,
+		keyring: k,
+	}
+}
+
+// Write archives the chains, each a root transaction followed by the
+// transactions below it, and returns the manifest path relative to the
+// archive directory. Nothing is left behind when it fails.
+func (a *Archive) Write(chains [][]model.Transaction) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock().UTC()
+	run := now.Format(runFormat)
+
+	byDate := map[string][]model.Transaction{}
+	dates := []string{}
+	for _, chain := range chains {
+		date := chain[0].CreatedAt.UTC().Format("2006-01-02")
+		if _, ok := byDate[date]; !ok {
+			dates = append(dates, date)
+		}
+		byDate[date] = append(byDate[date], chain...)
+	}
+
+	manifest := Manifest{
+		Version:   ManifestVersion,
+		CreatedAt: now,
+		Files:     []File{},
+	}
+
+	written := []string{}
+	cleanup := func() {
+		for _, path := range written {
+			os.Remove(filepath.Join(a.dir, path))
+		}
+	}
+
+	for _, date := range dates {
+		day, _ := time.Parse("2006-01-02", date)
+		path := filepath.Join(day.Format("2006"), day.Format("01"), day.Format("02"),
+			"transactions-"+run+".jsonl.gz")
+
+		file, err := a.writeFile(path, byDate[date])
+		if err != nil {
+			cleanup()
+			return "", err
+		}
+		written = append(written, path)
+
+		file.Date = date
+		manifest.Files = append(manifest.Files, file)
+		manifest.Transactions += file.Transactions
+	}
+
+	path := filepath.Join(manifestsDir, run+".json")
+	if err := a.writeManifest(path, manifest); err != nil {
+		cleanup()
+		return "", err
+	}
+
+	return filepath.ToSlash(path), nil
+}
+
+func (a *Archive) writeManifest(path string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(filepath.Join(a.dir, path), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+func (a *Archive) writeFile(path string, transactions []model.Transaction) (File, error) {
+	file := File{
+		Path:         filepath.ToSlash(path),
+		Transactions: len(transactions),
+	}
+
+	hash := sha256.New()
+
+	err := writeAtomic(filepath.Join(a.dir, path), func(w io.Writer) error {
+		counter := &countingWriter{w: io.MultiWriter(w, hash)}
+		gz := gzip.NewWriter(counter)
+
+		encoder := json.NewEncoder(gz)
+		for _, t := range transactions {
+			r, err := a.toRecord(t)
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		file.Size = counter.n
+		return nil
+	})
+	if err != nil {
+		return File{}, err
+	}
+
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return file, nil
+}
+
+// writeAtomic writes a temporary file and renames it to path once it is
+// synced, so path is either complete or missing
+func writeAtomic(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Read verifies the files of the manifest at path, relative to the archive
+// directory, and returns their transactions, parents before children
+func (a *Archive) Read(path string) (Manifest, []model.Transaction, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	manifest, err := a.readManifest(path)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	transactions := []model.Transaction{}
+
+	for _, file := range manifest.Files {
+		result, err := a.readFile(file)
+		if err != nil {
+			return manifest, nil, err
+		}
+		transactions = append(transactions, result...)
+	}
+
+	return manifest, transactions, nil
+}
+
+func (a *Archive) readManifest(path string) (Manifest, error) {
+	manifest := Manifest{}
+
+	if !filepath.IsLocal(path) {
+		return manifest, ErrManifestNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(a.dir, path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, ErrManifestNotFound
+	}
+	if err != nil {
+		return manifest, err
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("reading manifest %s: %w", path, err)
+	}
+	if manifest.Version != ManifestVersion {
+		return manifest, fmt.Errorf("unsupported archive manifest version %d", manifest.Version)
+	}
+
+	return manifest, nil
+}
+
+// Erase pseudonymizes the archived transactions of the customer with email
+// and returns how many it erased. A file holding any is written again under
+// a new name, the manifest is replaced and only then the old file removed,
+// so a manifest always matches its files. Repeating it after a failure
+// erases what is left.
+func (a *Archive) Erase(p model.Pseudonymizer, email string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(a.dir, manifestsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	subject := p.Subject(email)
+	erased := 0
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		n, err := a.eraseManifest(filepath.Join(manifestsDir, entry.Name()), p, subject)
+		if err != nil {
+			return erased, err
+		}
+		erased += n
+	}
+
+	return erased, nil
+}
+
+// eraseManifest erases the transactions of subject in the files of the
+// manifest at path
+func (a *Archive) eraseManifest(path string, p model.Pseudonymizer, subject string) (int, error) {
+	manifest, err := a.readManifest(path)
+	if err != nil {
+		return 0, err
+	}
+
+	run := strings.TrimSuffix(filepath.Base(path), ".json")
+	erasedAt := a.clock().UTC().Format(runFormat)
+
+	erased := 0
+	replaced := []string{}
+	written := []string{}
+	cleanup := func() {
+		for _, path := range written {
+			os.Remove(filepath.Join(a.dir, path))
+		}
+	}
+
+	for i, file := range manifest.Files {
+		transactions, err := a.readFile(file)
+		if err != nil {
+			cleanup()
+			return 0, err
+		}
+
+		n := 0
+		for j, t := range transactions {
+			if p.Subject(t.CustomerEmail) == subject {
+				transactions[j] = p.Transaction(t)
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+
+		rewritten := filepath.Join(filepath.Dir(filepath.FromSlash(file.Path)),
+			"transactions-"+run+"-erased-"+erasedAt+".jsonl.gz")
+		f, err := a.writeFile(rewritten, transactions)
+		if err != nil {
+			cleanup()
+			return 0, err
+		}
+		written = append(written, rewritten)
+		replaced = append(replaced, filepath.FromSlash(file.Path))
+
+		f.Date = file.Date
+		manifest.Files[i] = f
+		erased += n
+	}
+
+	if erased == 0 {
+		return 0, nil
+	}
+
+	if err := a.writeManifest(path, manifest); err != nil {
+		cleanup()
+		return 0, err
+	}
+
+	for _, path := range replaced {
+		if err := os.Remove(filepath.Join(a.dir, path)); err != nil {
+			return erased, err
+		}
+	}
+
+	return erased, nil
+}
+
+// Restorer is the part of the controller or store an archive is restored to
+type Restorer interface {
+	RestoreTransactions(context.Context, []model.Transaction) (int, error)
+}
+
+// Restore verifies the archive of the manifest at path and restores its
+// transactions, it returns the manifest and how many were restored
+func (a *Archive) Restore(ctx context.Context, path string, store Restorer) (Manifest, int, error) {
+	manifest, transactions, err := a.Read(path)
+	if err != nil {
+		return manifest, 0, err
+	}
+
+	restored, err := store.RestoreTransactions(ctx, transactions)
+	return manifest, restored, err
+}
+
+func (a *Archive) readFile(file File) ([]model.Transaction, error) {
+	if !filepath.IsLocal(file.Path) {
+		return nil, ErrCorrupt
+	}
+
+	data, err := os.ReadFile(filepath.Join(a.dir, file.Path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCorrupt
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+		return nil, ErrCorrupt
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	transactions := []model.Transaction{}
+
+	decoder := json.NewDecoder(gz)
+	for {
+		r := Record{}
+		err := decoder.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file.Path, err)
+		}
+		t, err := a.toModel(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", file.Path, err)
+		}
+		transactions = append(transactions, t)
+	}
+
+	if len(transactions) != file.Transactions {
+		return nil, ErrCorrupt
+	}
+
+	return transactions, nil
+}
+
+func (a *Archive) toRecord(t model.Transaction) (Record, error) {
+	r := Record{
+		Id:         t.Id,
+		ParentId:   t.ParentId,
+		MerchantId: t.MerchantId,
+		Type:       t.Type,
+		Amount:     t.Amount,
+		Status:     t.Status,
+		CreatedAt:  t.CreatedAt,
+	}
+
+	var err error
+	if r.CustomerEmail, err = a.encrypt(t.CustomerEmail); err != nil {
+		return Record{}, err
+	}
+	if r.CustomerPhone, err = a.encrypt(t.CustomerPhone); err != nil {
+		return Record{}, err
+	}
+	return r, nil
+}
+
+func (a *Archive) toModel(r Record) (model.Transaction, error) {
+	t := model.Transaction{
+		Id:         r.Id,
+		ParentId:   r.ParentId,
+		MerchantId: r.MerchantId,
+		Type:       r.Type,
+		Amount:     r.Amount,
+		Status:     r.Status,
+		CreatedAt:  r.CreatedAt,
+	}
+
+	var err error
+	if t.CustomerEmail, err = a.decrypt(r.CustomerEmail); err != nil {
+		return model.Transaction{}, err
+	}
+	if t.CustomerPhone, err = a.decrypt(r.CustomerPhone); err != nil {
+		return model.Transaction{}, err
+	}
+	return t, nil
+}
+
+func (a *Archive) encrypt(value string) (string, error) {
+	if a.keyring == nil {
+		return value, nil
+	}
+	return a.keyring.Encrypt(value)
+}
+
+func (a *Archive) decrypt(value string) (string, error) {
+	if a.keyring == nil {
+		if keyring.Version(value) != 0 {
+			return "", ErrNoKeyring
+		}
+		return value, nil
+	}
+	return a.keyring.Decrypt(value)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
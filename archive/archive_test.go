@@ -0,0 +1,199 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func newChain(createdAt time.Time) []model.Transaction {
+	authorize := model.Transaction{
+		Id:            uuid.New(),
+		MerchantId:    uuid.New(),
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		Status:        model.TransactionStatusReversed,
+		CustomerEmail: "customer@example.com",
+		CreatedAt:     createdAt,
+	}
+	reversal := model.Transaction{
+		Id:            uuid.New(),
+		ParentId:      authorize.Id,
+		MerchantId:    authorize.MerchantId,
+		Type:          model.TransactionTypeReversal,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@example.com",
+		CreatedAt:     createdAt.Add(time.Hour),
+	}
+	return []model.Transaction{authorize, reversal}
+}
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := New(dir, nil, func() time.Time { return now })
+
+	first := newChain(time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+	second := newChain(time.Date(2023, 1, 2, 23, 30, 0, 0, time.UTC))
+	third := newChain(time.Date(2023, 1, 5, 8, 0, 0, 0, time.UTC))
+
+	path, err := a.Write([][]model.Transaction{first, third, second})
+	require.NoError(t, err)
+	assert.Equal(t, "manifests/20230301T120000.000000000.json", path)
+
+	manifest, transactions, err := a.Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, 6, manifest.Transactions)
+	require.Len(t, manifest.Files, 2)
+	assert.Equal(t, "2023/01/02/transactions-20230301T120000.000000000.jsonl.gz", manifest.Files[0].Path)
+	assert.Equal(t, "2023-01-02", manifest.Files[0].Date)
+	assert.Equal(t, 4, manifest.Files[0].Transactions)
+	assert.Equal(t, "2023-01-05", manifest.Files[1].Date)
+
+	// chains are kept together, parents first
+	expected := append(append(append([]model.Transaction{}, first...), second...), third...)
+	require.Len(t, transactions, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Id, transactions[i].Id)
+		assert.Equal(t, expected[i].ParentId, transactions[i].ParentId)
+		assert.Equal(t, expected[i].Status, transactions[i].Status)
+		assert.True(t, expected[i].CreatedAt.Equal(transactions[i].CreatedAt))
+	}
+
+	// no temporary files are left
+	entries, err := os.ReadDir(filepath.Join(dir, "2023", "01", "02"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteReadEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	k, err := keyring.New()
+	require.NoError(t, err)
+	a := New(dir, k, nil)
+
+	chain := newChain(time.Now())
+	chain[0].CustomerPhone = "+359888123456"
+
+	path, err := a.Write([][]model.Transaction{chain})
+	require.NoError(t, err)
+
+	manifest, transactions, err := a.Read(path)
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	assert.Equal(t, "customer@example.com", transactions[0].CustomerEmail)
+	assert.Equal(t, "+359888123456", transactions[0].CustomerPhone)
+
+	// the file holds no personal data in plain text
+	data, err := os.ReadFile(filepath.Join(dir, manifest.Files[0].Path))
+	require.NoError(t, err)
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "customer@example.com")
+	assert.NotContains(t, string(content), "+359888123456")
+	assert.Contains(t, string(content), keyring.Prefix(1))
+
+	_, _, err = New(dir, nil, nil).Read(path)
+	assert.ErrorIs(t, err, ErrNoKeyring)
+}
+
+func TestErase(t *testing.T) {
+	dir := t.TempDir()
+	k, err := keyring.New()
+	require.NoError(t, err)
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := New(dir, k, func() time.Time { return now })
+
+	erased := newChain(time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC))
+	erased[0].CustomerPhone = "+359888123456"
+	kept := newChain(time.Date(2023, 1, 5, 8, 0, 0, 0, time.UTC))
+	for i := range kept {
+		kept[i].CustomerEmail = "other@example.com"
+	}
+
+	path, err := a.Write([][]model.Transaction{erased, kept})
+	require.NoError(t, err)
+	before, _, err := a.Read(path)
+	require.NoError(t, err)
+
+	p := model.NewPseudonymizer("0123456789abcdef0123456789abcdef")
+
+	now = now.Add(time.Hour)
+	n, err := a.Erase(p, "Customer@Example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// only the file of the customer is replaced, the old one is gone
+	manifest, transactions, err := a.Read(path)
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 2)
+	assert.Equal(t, "2023/01/02/transactions-20230301T120000.000000000-erased-20230301T130000.000000000.jsonl.gz", manifest.Files[0].Path)
+	assert.Equal(t, "2023-01-02", manifest.Files[0].Date)
+	assert.Equal(t, before.Files[1], manifest.Files[1])
+	_, err = os.Stat(filepath.Join(dir, before.Files[0].Path))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.Len(t, transactions, 4)
+	assert.Equal(t, p.Transaction(erased[0]).CustomerEmail, transactions[0].CustomerEmail)
+	assert.Equal(t, p.Phone("+359888123456"), transactions[0].CustomerPhone)
+	assert.True(t, model.IsErasedEmail(transactions[1].CustomerEmail))
+	assert.Equal(t, erased[1].Id, transactions[1].Id)
+	assert.Equal(t, "other@example.com", transactions[2].CustomerEmail)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "2023", "01", "02"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// repeating it finds nothing left
+	n, err = a.Erase(p, "customer@example.com")
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestReadCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	a := New(dir, nil, nil)
+
+	path, err := a.Write([][]model.Transaction{newChain(time.Now())})
+	require.NoError(t, err)
+
+	manifest, _, err := a.Read(path)
+	require.NoError(t, err)
+
+	file := filepath.Join(dir, manifest.Files[0].Path)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	data[len(data)/2] ^= 0xff
+	require.NoError(t, os.WriteFile(file, data, 0o640))
+
+	_, _, err = a.Read(path)
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	require.NoError(t, os.Remove(file))
+
+	_, _, err = a.Read(path)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestReadManifestNotFound(t *testing.T) {
+	a := New(t.TempDir(), nil, nil)
+
+	for _, path := range []string{"manifests/missing.json", "../manifest.json", "/etc/passwd"} {
+		_, _, err := a.Read(path)
+		assert.ErrorIs(t, err, ErrManifestNotFound, path)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/model/controller"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+const auditUsage = "usage: payment-system audit [flags] verify"
+
+// runAudit runs the audit subcommand, verify walks the audit chain of the
+// store and reports every break
+func runAudit(settings model.ApplicationSettings, args []string, out io.Writer) error {
+	if len(args) != 1 || args[0] != "verify" {
+		return errors.New(auditUsage)
+	}
+
+	s, err := store.NewStore(settings.StoreSettings, nil)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	c, err := controller.NewController(settings, s)
+	if err != nil {
+		return err
+	}
+
+	v, err := c.VerifyAudit(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, b := range v.Breaks {
+		fmt.Fprintf(out, "entry %d: %s\n", b.Sequence, b.Reason)
+	}
+
+	if !v.Valid() {
+		return fmt.Errorf("the audit chain of %d entries is broken at %d entries", v.Entries, len(v.Breaks))
+	}
+
+	fmt.Fprintf(out, "the audit chain of %d entries is intact, head %s\n", v.Entries, v.Head)
+
+	return nil
+}
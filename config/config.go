@@ -0,0 +1,581 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+const (
+	EnvPrefix = "PAYMENT_"
+
+	redacted = "[REDACTED]"
+
+	minErasureKeyLength = 32
+)
+
+// Options control the application start and are not part of the settings
+type Options struct {
+	ConfigFile  string
+	PrintConfig bool
+	// Args are the arguments left after the flags
+	Args []string
+}
+
+// setting describes a single configuration value and the file key,
+// environment variable and flag it is read from
+type setting struct {
+	key    string
+	flag   string
+	usage  string
+	secret bool
+	value  func(*model.ApplicationSettings) any
+}
+
+var entries = []setting{
+	{
+		key:   "server.listen_address",
+		flag:  "listen",
+		usage: "address the server listens on",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.ListenAddress },
+	},
+	{
+		key:   "server.tls.cert_file",
+		flag:  "tls-cert",
+		usage: "TLS certificate file, enables HTTPS together with -tls-key",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.TLS.CertFile },
+	},
+	{
+		key:    "server.tls.key_file",
+		flag:   "tls-key",
+		usage:  "TLS private key file",
+		secret: true,
+		value:  func(s *model.ApplicationSettings) any { return &s.ServerSettings.TLS.KeyFile },
+	},
+	{
+		key:   "server.shutdown_timeout",
+		flag:  "shutdown-timeout",
+		usage: "time to drain requests and stop background jobs on shutdown",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.ShutdownTimeout },
+	},
+	{
+		key:   "server.read_header_timeout",
+		flag:  "read-header-timeout",
+		usage: "time to read the headers of a request, 0 disables it",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.ReadHeaderTimeout },
+	},
+	{
+		key:   "server.read_timeout",
+		flag:  "read-timeout",
+		usage: "time to read a whole request, 0 disables it",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.ReadTimeout },
+	},
+	{
+		key:   "server.write_timeout",
+		flag:  "write-timeout",
+		usage: "time from the end of the request headers to the end of the response, 0 disables it",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.WriteTimeout },
+	},
+	{
+		key:   "server.idle_timeout",
+		flag:  "idle-timeout",
+		usage: "time a keep-alive connection waits for the next request, 0 disables it",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.IdleTimeout },
+	},
+	{
+		key:   "server.max_body_size",
+		flag:  "max-body-size",
+		usage: "largest request body in bytes, 0 disables the limit",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.MaxBodySize },
+	},
+	{
+		key:   "server.max_import_size",
+		flag:  "max-import-size",
+		usage: "largest body of a bulk import of admins or merchants in bytes, 0 disables the limit",
+		value: func(s *model.ApplicationSettings) any { return &s.ServerSettings.MaxImportSize },
+	},
+	{
+		key:    "server.admin_token",
+		flag:   "admin-token",
+		usage:  "bearer token of the admin routes, they are disabled when empty",
+		secret: true,
+		value:  func(s *model.ApplicationSettings) any { return &s.ServerSettings.AdminToken },
+	},
+	{
+		key:   "store.backend",
+		flag:  "store",
+		usage: "store backend, sqlite, bolt or memory",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.Backend },
+	},
+	{
+		key:   "store.db_path",
+		flag:  "db-path",
+		usage: "path of the SQLite or bolt database file",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.DbPath },
+	},
+	{
+		key:   "store.show_sql_queries",
+		flag:  "show-sql",
+		usage: "log SQL statements",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.ShowSQLQueries },
+	},
+	{
+		key:   "store.dummy_db",
+		flag:  "dummy-db",
+		usage: "use an in-memory store",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.DummyDb },
+	},
+	{
+		key:   "store.keyring_file",
+		flag:  "keyring-file",
+		usage: "keyring file of the keys personal data is encrypted with, empty disables encryption",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.KeyringFile },
+	},
+	{
+		key:   "store.timeout",
+		flag:  "store-timeout",
+		usage: "deadline of every store operation, 0 disables it",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.Timeout },
+	},
+	{
+		key:   "store.operation_timeouts",
+		flag:  "store-operation-timeouts",
+		usage: "deadline per store operation, e.g. get_transactions=1m,delete_transactions=5m",
+		value: func(s *model.ApplicationSettings) any { return &s.StoreSettings.OperationTimeouts },
+	},
+	{
+		key:   "cleanup.frequency",
+		flag:  "cleanup-frequency",
+		usage: "interval between transaction cleanup runs",
+		value: func(s *model.ApplicationSettings) any { return &s.CleanupSettings.Frequency },
+	},
+	{
+		key:   "cleanup.retention",
+		flag:  "cleanup-retention",
+		usage: "how long transactions are kept, 0 keeps them forever",
+		value: func(s *model.ApplicationSettings) any { return &s.CleanupSettings.Retention },
+	},
+	{
+		key:   "cleanup.status_retention",
+		flag:  "cleanup-status-retention",
+		usage: "retention per transaction status, e.g. error=720h,reversed=8760h",
+		value: func(s *model.ApplicationSettings) any { return &s.CleanupSettings.StatusRetention },
+	},
+	{
+		key:   "cleanup.merchant_retention",
+		flag:  "cleanup-merchant-retention",
+		usage: "retention per merchant uuid, overrides the status retention",
+		value: func(s *model.ApplicationSettings) any { return &s.CleanupSettings.MerchantRetention },
+	},
+	{
+		key:   "cleanup.dry_run",
+		flag:  "cleanup-dry-run",
+		usage: "only report what the cleanup would purge",
+		value: func(s *model.ApplicationSettings) any { return &s.CleanupSettings.DryRun },
+	},
+	{
+		key:   "cleanup.archive_dir",
+		flag:  "cleanup-archive-dir",
+		usage: "directory of the archives of purged transactions, empty disables archiving",
+		value: func(s *model.ApplicationSettings) any { return &s.CleanupSettings.ArchiveDir },
+	},
+	{
+		key:    "privacy.erasure_key",
+		flag:   "erasure-key",
+		usage:  "secret of the tokens replacing erased customer data, empty disables erasure",
+		secret: true,
+		value:  func(s *model.ApplicationSettings) any { return &s.PrivacySettings.ErasureKey },
+	},
+	{
+		key:   "log.level",
+		flag:  "log-level",
+		usage: "log level, debug, info, warn or error",
+		value: func(s *model.ApplicationSettings) any { return &s.LogSettings.Level },
+	},
+	{
+		key:   "log.redact",
+		flag:  "log-redact",
+		usage: "redact customer emails, phones and card numbers in logs",
+		value: func(s *model.ApplicationSettings) any { return &s.LogSettings.Redact },
+	},
+	{
+		key:   "tracing.exporter",
+		flag:  "tracing-exporter",
+		usage: "exporter of the trace spans, none, stdout or file",
+		value: func(s *model.ApplicationSettings) any { return &s.TracingSettings.Exporter },
+	},
+	{
+		key:   "tracing.file",
+		flag:  "tracing-file",
+		usage: "file the file exporter appends the spans to",
+		value: func(s *model.ApplicationSettings) any { return &s.TracingSettings.File },
+	},
+}
+
+// env returns the environment variable of a setting,
+// e.g. PAYMENT_SERVER_LISTEN_ADDRESS for server.listen_address
+func (s setting) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func (s setting) set(settings *model.ApplicationSettings, str string) error {
+	switch v := s.value(settings).(type) {
+	case *string:
+		*v = str
+	case *bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", s.key, str)
+		}
+		*v = b
+	case *int64:
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.key, str)
+		}
+		*v = n
+	case *time.Duration:
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.key, str)
+		}
+		*v = d
+	case *map[string]time.Duration:
+		// key=duration pairs separated by commas
+		m := map[string]time.Duration{}
+		for _, pair := range strings.Split(str, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%s: invalid pair %q, expected key=duration", s.key, pair)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("%s: invalid duration %q", s.key, value)
+			}
+			m[strings.TrimSpace(key)] = d
+		}
+		*v = m
+	default:
+		return fmt.Errorf("%s: unsupported setting type %T", s.key, v)
+	}
+	return nil
+}
+
+func (s setting) get(settings *model.ApplicationSettings) any {
+	switch v := s.value(settings).(type) {
+	case *string:
+		return *v
+	case *bool:
+		return *v
+	case *int64:
+		return *v
+	case *time.Duration:
+		return v.String()
+	case *map[string]time.Duration:
+		if len(*v) == 0 {
+			return nil
+		}
+		m := map[string]string{}
+		for key, d := range *v {
+			m[key] = d.String()
+		}
+		return m
+	}
+	return nil
+}
+
+func Defaults() model.ApplicationSettings {
+	return model.ApplicationSettings{
+		ServerSettings: model.ServerSettings{
+			ListenAddress:     ":8080",
+			ShutdownTimeout:   30 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxBodySize:       1 << 20,
+			MaxImportSize:     10 << 20,
+		},
+		StoreSettings: model.StoreSettings{
+			Backend: model.StoreBackendSQLite,
+			DbPath:  "payment_system.db",
+			Timeout: 30 * time.Second,
+		},
+		CleanupSettings: model.CleanupSettings{
+			Frequency:  60 * time.Minute,
+			ArchiveDir: "payment_system_archive",
+		},
+		LogSettings: model.LogSettings{
+			Level:  "info",
+			Redact: true,
+		},
+		TracingSettings: model.TracingSettings{
+			Exporter: model.TracingExporterNone,
+		},
+	}
+}
+
+// flagValue records the flags given on the command line
+type flagValue struct {
+	setting setting
+	isBool  bool
+	values  map[string]string
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(str string) error {
+	f.values[f.setting.key] = str
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Load builds the application settings from the defaults, the config file,
+// PAYMENT_* environment variables and command line flags, each one
+// overriding the previous. The config file is given by -config or
+// PAYMENT_CONFIG.
+func Load(args []string, lookupEnv func(string) (string, bool)) (model.ApplicationSettings, Options, error) {
+	options := Options{}
+
+	fs := flag.NewFlagSet("payment-system", flag.ContinueOnError)
+	fs.StringVar(&options.ConfigFile, "config", "", "YAML configuration file (env "+EnvPrefix+"CONFIG)")
+	fs.BoolVar(&options.PrintConfig, "print-config", false, "print the effective configuration and exit")
+
+	defaults := Defaults()
+	flagValues := map[string]string{}
+
+	for _, s := range entries {
+		_, isBool := s.value(&defaults).(*bool)
+		fs.Var(&flagValue{setting: s, isBool: isBool, values: flagValues}, s.flag,
+			fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return model.ApplicationSettings{}, options, err
+	}
+	options.Args = fs.Args()
+
+	if options.ConfigFile == "" {
+		options.ConfigFile, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+
+	result := defaults
+
+	if options.ConfigFile != "" {
+		data, err := os.ReadFile(options.ConfigFile)
+		if err != nil {
+			return model.ApplicationSettings{}, options, fmt.Errorf("cannot read config file: %w", err)
+		}
+		if err := decodeFile(data, &result); err != nil {
+			return model.ApplicationSettings{}, options, fmt.Errorf("invalid config file %s: %w", options.ConfigFile, err)
+		}
+	}
+
+	for _, s := range entries {
+		if str, ok := lookupEnv(s.env()); ok {
+			if err := s.set(&result, str); err != nil {
+				return model.ApplicationSettings{}, options, fmt.Errorf("%s: %w", s.env(), err)
+			}
+		}
+	}
+
+	for _, s := range entries {
+		if str, ok := flagValues[s.key]; ok {
+			if err := s.set(&result, str); err != nil {
+				return model.ApplicationSettings{}, options, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	return result, options, Validate(result)
+}
+
+func decodeFile(data []byte, settings *model.ApplicationSettings) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(settings)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// Validate reports every invalid setting
+func Validate(s model.ApplicationSettings) error {
+	errs := []string{}
+
+	if _, _, err := net.SplitHostPort(s.ServerSettings.ListenAddress); err != nil {
+		errs = append(errs, fmt.Sprintf("server.listen_address: %v", err))
+	}
+
+	if s.ServerSettings.ShutdownTimeout <= 0 {
+		errs = append(errs, "server.shutdown_timeout: must be positive")
+	}
+	timeouts := map[string]time.Duration{
+		"read_header_timeout": s.ServerSettings.ReadHeaderTimeout,
+		"read_timeout":        s.ServerSettings.ReadTimeout,
+		"write_timeout":       s.ServerSettings.WriteTimeout,
+		"idle_timeout":        s.ServerSettings.IdleTimeout,
+	}
+	for key, d := range timeouts {
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("server.%s: cannot be negative", key))
+		}
+	}
+	if s.ServerSettings.MaxBodySize < 0 {
+		errs = append(errs, "server.max_body_size: cannot be negative")
+	}
+	if s.ServerSettings.MaxImportSize < 0 {
+		errs = append(errs, "server.max_import_size: cannot be negative")
+	}
+
+	tls := s.ServerSettings.TLS
+	if (tls.CertFile == "") != (tls.KeyFile == "") {
+		errs = append(errs, "server.tls: cert_file and key_file must be set together")
+	}
+	for _, file := range []string{tls.CertFile, tls.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Sprintf("server.tls: %v", err))
+		}
+	}
+
+	switch s.StoreSettings.Backend {
+	case "", model.StoreBackendSQLite, model.StoreBackendBolt:
+		if !s.StoreSettings.DummyDb && s.StoreSettings.DbPath == "" {
+			errs = append(errs, "store.db_path: cannot be empty")
+		}
+	case model.StoreBackendMemory:
+	default:
+		errs = append(errs, fmt.Sprintf("store.backend: unknown backend %q", s.StoreSettings.Backend))
+	}
+
+	if s.StoreSettings.KeyringFile != "" && (s.StoreSettings.DummyDb || (s.StoreSettings.Backend != "" && s.StoreSettings.Backend != model.StoreBackendSQLite)) {
+		errs = append(errs, "store.keyring_file: encryption is supported by the sqlite backend only")
+	}
+
+	if s.StoreSettings.Timeout < 0 {
+		errs = append(errs, "store.timeout: cannot be negative")
+	}
+	for operation, d := range s.StoreSettings.OperationTimeouts {
+		if !slices.Contains(model.StoreOperations, operation) {
+			errs = append(errs, fmt.Sprintf("store.operation_timeouts: unknown operation %q", operation))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("store.operation_timeouts: %s cannot be negative", operation))
+		}
+	}
+
+	if s.CleanupSettings.Frequency <= 0 {
+		errs = append(errs, "cleanup.frequency: must be positive")
+	}
+	if s.CleanupSettings.Retention < 0 {
+		errs = append(errs, "cleanup.retention: cannot be negative")
+	}
+	for status, d := range s.CleanupSettings.StatusRetention {
+		switch status {
+		case model.TransactionStatusApproved, model.TransactionStatusReversed,
+			model.TransactionStatusRefunded, model.TransactionStatusError:
+		default:
+			errs = append(errs, fmt.Sprintf("cleanup.status_retention: unknown status %q", status))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("cleanup.status_retention: %s cannot be negative", status))
+		}
+	}
+	for merchant, d := range s.CleanupSettings.MerchantRetention {
+		if _, err := uuid.Parse(merchant); err != nil {
+			errs = append(errs, fmt.Sprintf("cleanup.merchant_retention: invalid merchant uuid %q", merchant))
+		}
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("cleanup.merchant_retention: %s cannot be negative", merchant))
+		}
+	}
+
+	if key := s.PrivacySettings.ErasureKey; key != "" && len(key) < minErasureKeyLength {
+		errs = append(errs, fmt.Sprintf("privacy.erasure_key: must be at least %d characters", minErasureKeyLength))
+	}
+
+	switch strings.ToLower(s.LogSettings.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("log.level: unknown level %q", s.LogSettings.Level))
+	}
+
+	switch s.TracingSettings.Exporter {
+	case "", model.TracingExporterNone, model.TracingExporterStdout:
+	case model.TracingExporterFile:
+		if s.TracingSettings.File == "" {
+			errs = append(errs, "tracing.file: cannot be empty with the file exporter")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("tracing.exporter: unknown exporter %q", s.TracingSettings.Exporter))
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// Print renders the settings as a YAML config file with secrets redacted
+func Print(s model.ApplicationSettings) (string, error) {
+	data, err := yaml.Marshal(Summary(s))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Summary nests the settings by their file keys with the secrets redacted
+func Summary(s model.ApplicationSettings) map[string]any {
+	root := map[string]any{}
+
+	for _, st := range entries {
+		value := st.get(&s)
+		if value == nil {
+			continue
+		}
+		if st.secret && value != "" {
+			value = redacted
+		}
+
+		node := root
+		keys := strings.Split(st.key, ".")
+		for _, k := range keys[:len(keys)-1] {
+			child, ok := node[k].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				node[k] = child
+			}
+			node = child
+		}
+		node[keys[len(keys)-1]] = value
+	}
+
+	return root
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	settings, options, err := Load(nil, env(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, Defaults(), settings)
+	assert.False(t, options.PrintConfig)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+server:
+  listen_address: ":9000"
+store:
+  db_path: file.db
+  show_sql_queries: true
+cleanup:
+  frequency: 10m
+  retention: 720h
+`)
+
+	settings, _, err := Load(
+		[]string{"-config", file, "-db-path", "flag.db"},
+		env(map[string]string{
+			"PAYMENT_STORE_DB_PATH":          "env.db",
+			"PAYMENT_CLEANUP_FREQUENCY":      "5m",
+			"PAYMENT_STORE_SHOW_SQL_QUERIES": "false",
+		}))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9000", settings.ServerSettings.ListenAddress)
+	assert.Equal(t, "flag.db", settings.StoreSettings.DbPath)
+	assert.False(t, settings.StoreSettings.ShowSQLQueries)
+	assert.Equal(t, 5*time.Minute, settings.CleanupSettings.Frequency)
+	assert.Equal(t, 720*time.Hour, settings.CleanupSettings.Retention)
+}
+
+func TestLoadLogSettings(t *testing.T) {
+	settings, _, err := Load([]string{"-log-redact=false"}, env(map[string]string{"PAYMENT_LOG_LEVEL": "debug"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "debug", settings.LogSettings.Level)
+	assert.False(t, settings.LogSettings.Redact)
+}
+
+func TestLoadServerLimits(t *testing.T) {
+	settings, _, err := Load([]string{"-max-body-size", "2048", "-write-timeout", "0s"}, env(map[string]string{"PAYMENT_SERVER_READ_TIMEOUT": "10s"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(2048), settings.ServerSettings.MaxBodySize)
+	assert.Equal(t, int64(10<<20), settings.ServerSettings.MaxImportSize)
+	assert.Equal(t, 10*time.Second, settings.ServerSettings.ReadTimeout)
+	assert.Zero(t, settings.ServerSettings.WriteTimeout)
+
+	_, _, err = Load([]string{"-max-body-size", "1MB"}, env(nil))
+	assert.ErrorContains(t, err, "server.max_body_size")
+
+	_, _, err = Load([]string{"-max-import-size", "-1", "-idle-timeout", "-1s"}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.max_import_size")
+	assert.Contains(t, err.Error(), "server.idle_timeout")
+}
+
+func TestLoadStoreTimeouts(t *testing.T) {
+	settings, _, err := Load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, settings.StoreSettings.Timeout)
+
+	settings, _, err = Load(
+		[]string{"-store-timeout", "5s", "-store-operation-timeouts", "get_transactions=1m,delete_transactions=0s"},
+		env(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, 5*time.Second, settings.StoreSettings.Timeout)
+	assert.Equal(t, map[string]time.Duration{
+		"get_transactions":    time.Minute,
+		"delete_transactions": 0,
+	}, settings.StoreSettings.OperationTimeouts)
+}
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	merchant := "c15760c1-bb8d-4717-98f9-feb182950259"
+
+	file := writeFile(t, "config.yaml", `
+cleanup:
+  retention: 720h
+  merchant_retention:
+    `+merchant+`: 87600h
+`)
+
+	settings, _, err := Load(
+		[]string{"-config", file},
+		env(map[string]string{"PAYMENT_CLEANUP_STATUS_RETENTION": "error=24h, reversed=48h"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]time.Duration{
+		"error":    24 * time.Hour,
+		"reversed": 48 * time.Hour,
+	}, settings.CleanupSettings.StatusRetention)
+	assert.Equal(t, map[string]time.Duration{merchant: 87600 * time.Hour}, settings.CleanupSettings.MerchantRetention)
+
+	_, _, err = Load([]string{"-cleanup-status-retention", "pending=1h", "-cleanup-merchant-retention", "acme=1h"}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cleanup.status_retention")
+	assert.Contains(t, err.Error(), "cleanup.merchant_retention")
+
+	_, _, err = Load([]string{"-cleanup-status-retention", "error"}, env(nil))
+	assert.Error(t, err)
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	file := writeFile(t, "config.yaml", "store:\n  dummy_db: true\n")
+
+	settings, options, err := Load([]string{"-show-sql"}, env(map[string]string{"PAYMENT_CONFIG": file}))
+	require.NoError(t, err)
+
+	assert.Equal(t, file, options.ConfigFile)
+	assert.True(t, settings.StoreSettings.DummyDb)
+	assert.True(t, settings.StoreSettings.ShowSQLQueries)
+}
+
+func TestLoadUnknownFileKey(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  port: 8080\n")
+
+	_, _, err := Load([]string{"-config", file}, env(nil))
+	assert.Error(t, err)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	_, _, err := Load([]string{"-listen", "8080", "-cleanup-frequency", "0s", "-tls-cert", "cert.pem", "-store", "postgres", "-erasure-key", "short", "-keyring-file", "keyring.json", "-log-level", "verbose", "-tracing-exporter", "file"}, env(nil))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "server.listen_address")
+	assert.Contains(t, err.Error(), "cleanup.frequency")
+	assert.Contains(t, err.Error(), "server.tls")
+	assert.Contains(t, err.Error(), "store.backend")
+	assert.Contains(t, err.Error(), "privacy.erasure_key")
+	assert.Contains(t, err.Error(), "store.keyring_file")
+	assert.Contains(t, err.Error(), "log.level")
+	assert.Contains(t, err.Error(), "tracing.file")
+
+	_, _, err = Load([]string{"-store-timeout", "-1s", "-store-operation-timeouts", "vacuum=1m"}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "store.timeout")
+	assert.Contains(t, err.Error(), `unknown operation "vacuum"`)
+
+	_, _, err = Load(nil, env(map[string]string{"PAYMENT_CLEANUP_RETENTION": "a week"}))
+	assert.Error(t, err)
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	settings := Defaults()
+	settings.ServerSettings.TLS.CertFile = "cert.pem"
+	settings.ServerSettings.TLS.KeyFile = "key.pem"
+	settings.ServerSettings.AdminToken = "admin-secret"
+	settings.PrivacySettings.ErasureKey = "0123456789abcdef0123456789abcdef"
+
+	out, err := Print(settings)
+	require.NoError(t, err)
+
+	assert.NotContains(t, out, "key.pem")
+	assert.NotContains(t, out, "admin-secret")
+	assert.NotContains(t, out, "0123456789abcdef")
+	assert.Contains(t, out, "cert.pem")
+
+	// the output is a valid config file
+	printed := writeFile(t, "printed.yaml", out)
+	settings.ServerSettings.TLS = Defaults().ServerSettings.TLS
+	settings.ServerSettings.AdminToken = ""
+	settings.PrivacySettings = Defaults().PrivacySettings
+
+	loaded, _, err := Load([]string{"-config", printed, "-tls-cert", "", "-tls-key", "", "-erasure-key", "", "-admin-token", ""}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, settings, loaded)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+const keyringUsage = "usage: payment-system keyring [flags] init|rotate|status"
+
+// runKeyring runs the keyring subcommand on the keyring file of the store
+func runKeyring(settings model.StoreSettings, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(keyringUsage)
+	}
+
+	path := settings.KeyringFile
+	if path == "" {
+		return errors.New("encryption is disabled, store.keyring_file is empty")
+	}
+
+	var k *keyring.Keyring
+	var err error
+
+	switch args[0] {
+	case "init":
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("keyring %s already exists", path)
+		}
+		if k, err = keyring.New(); err != nil {
+			return err
+		}
+		if err := k.Save(path); err != nil {
+			return err
+		}
+
+	case "rotate":
+		if k, err = keyring.Load(path); err != nil {
+			return err
+		}
+		if _, err := k.Rotate(); err != nil {
+			return err
+		}
+		if err := k.Save(path); err != nil {
+			return err
+		}
+
+	case "status":
+		if k, err = keyring.Load(path); err != nil {
+			return err
+		}
+
+	default:
+		return errors.New(keyringUsage)
+	}
+
+	fmt.Fprintf(out, "keyring %s, current key version %d, versions %v\n", path, k.Current(), k.Versions())
+
+	return nil
+}
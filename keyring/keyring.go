@@ -0,0 +1,287 @@
+// Package keyring encrypts personal data at rest with versioned AES-GCM
+// data keys kept in a local file, and computes blind indexes so that
+// encrypted values can still be looked up.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keySize selects AES-256
+const keySize = 32
+
+var (
+	ErrUnknownKey = errors.New("value is encrypted with a key missing in the keyring")
+	ErrCorrupt    = errors.New("encrypted value is corrupt")
+)
+
+// file is the keyring file, the keys are base64 encoded and keyed by their
+// version. The index key is never rotated, the blind indexes would change.
+type file struct {
+	Current  int               `json:"current"`
+	IndexKey string            `json:"index_key"`
+	Keys     map[string]string `json:"keys"`
+}
+
+// Keyring holds the data keys by version, new values are encrypted with
+// the current one. Encrypted values look like enc:v<version>:<base64>, so
+// the key of a value is known without decrypting it.
+type Keyring struct {
+	current  int
+	keys     map[int][]byte
+	aeads    map[int]cipher.AEAD
+	indexKey []byte
+}
+
+// New returns a keyring with a random data key of version 1
+func New() (*Keyring, error) {
+	indexKey, err := randomKey()
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		keys:     map[int][]byte{},
+		aeads:    map[int]cipher.AEAD{},
+		indexKey: indexKey,
+	}
+
+	if _, err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Load reads a keyring file
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+
+	k := &Keyring{
+		current: f.Current,
+		keys:    map[int][]byte{},
+		aeads:   map[int]cipher.AEAD{},
+	}
+
+	k.indexKey, err = decodeKey(f.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: index key: %w", path, err)
+	}
+
+	for name, encoded := range f.Keys {
+		version, err := strconv.Atoi(name)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid keyring %s: invalid key version %q", path, name)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyring %s: key %d: %w", path, version, err)
+		}
+		if err := k.add(version, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := k.keys[k.current]; !ok {
+		return nil, fmt.Errorf("invalid keyring %s: current key %d is missing", path, k.current)
+	}
+
+	return k, nil
+}
+
+// Save writes the keyring file readable by the owner only, replacing it
+// atomically
+func (k *Keyring) Save(path string) error {
+	f := file{
+		Current:  k.current,
+		IndexKey: base64.StdEncoding.EncodeToString(k.indexKey),
+		Keys:     map[string]string{},
+	}
+	for version, key := range k.keys {
+		f.Keys[strconv.Itoa(version)] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Rotate adds a random data key and makes it the current one, it returns
+// the new version. Values encrypted with older keys stay readable.
+func (k *Keyring) Rotate() (int, error) {
+	key, err := randomKey()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 1
+	for v := range k.keys {
+		if v >= version {
+			version = v + 1
+		}
+	}
+
+	if err := k.add(version, key); err != nil {
+		return 0, err
+	}
+	k.current = version
+
+	return version, nil
+}
+
+// Current is the version of the key new values are encrypted with
+func (k *Keyring) Current() int {
+	return k.current
+}
+
+// Versions lists the versions of all keys in ascending order
+func (k *Keyring) Versions() []int {
+	versions := []int{}
+	for v := range k.keys {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Prefix is the prefix of the values encrypted with the key of version
+func Prefix(version int) string {
+	return fmt.Sprintf("enc:v%d:", version)
+}
+
+// Version returns the key version of an encrypted value, zero for a value
+// stored in plain text
+func Version(value string) int {
+	rest, ok := strings.CutPrefix(value, "enc:v")
+	if !ok {
+		return 0
+	}
+	number, _, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0
+	}
+	version, err := strconv.Atoi(number)
+	if err != nil || version <= 0 {
+		return 0
+	}
+	return version
+}
+
+// Encrypt encrypts value with the current key
+func (k *Keyring) Encrypt(value string) (string, error) {
+	aead := k.aeads[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+
+	return Prefix(k.current) + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value encrypted with any key of the keyring, values
+// stored in plain text are returned as they are
+func (k *Keyring) Decrypt(value string) (string, error) {
+	version := Version(value)
+	if version == 0 {
+		return value, nil
+	}
+
+	aead, ok := k.aeads[version]
+	if !ok {
+		return "", fmt.Errorf("%w: version %d", ErrUnknownKey, version)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, Prefix(version)))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrCorrupt
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrCorrupt
+	}
+
+	return string(plain), nil
+}
+
+// Index is the blind index of value, equal values have equal indexes
+func (k *Keyring) Index(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k *Keyring) add(version int, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	k.keys[version] = key
+	k.aeads[version] = aead
+	return nil
+}
+
+func randomKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,116 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	k, err := New()
+	require.NoError(t, err)
+	assert.Equal(t, 1, k.Current())
+
+	encrypted, err := k.Encrypt("customer@example.com")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "enc:v1:"), encrypted)
+	assert.NotContains(t, encrypted, "customer")
+	assert.Equal(t, 1, Version(encrypted))
+
+	// every encryption uses a new nonce
+	again, err := k.Encrypt("customer@example.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again)
+
+	decrypted, err := k.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "customer@example.com", decrypted)
+
+	empty, err := k.Encrypt("")
+	require.NoError(t, err)
+	decrypted, err = k.Decrypt(empty)
+	require.NoError(t, err)
+	assert.Empty(t, decrypted)
+
+	// plain text is read as it is
+	assert.Zero(t, Version("customer@example.com"))
+	decrypted, err = k.Decrypt("customer@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "customer@example.com", decrypted)
+
+	_, err = k.Decrypt(encrypted[:len(encrypted)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	_, err = k.Decrypt("enc:v7:" + strings.TrimPrefix(encrypted, "enc:v1:"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestIndex(t *testing.T) {
+	k, err := New()
+	require.NoError(t, err)
+
+	assert.Equal(t, k.Index("customer@example.com"), k.Index("customer@example.com"))
+	assert.NotEqual(t, k.Index("customer@example.com"), k.Index("other@example.com"))
+
+	other, err := New()
+	require.NoError(t, err)
+	assert.NotEqual(t, k.Index("customer@example.com"), other.Index("customer@example.com"))
+}
+
+func TestRotateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+
+	k, err := New()
+	require.NoError(t, err)
+
+	old, err := k.Encrypt("customer@example.com")
+	require.NoError(t, err)
+
+	version, err := k.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.Equal(t, []int{1, 2}, k.Versions())
+
+	require.NoError(t, k.Save(path))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, loaded.Current())
+	assert.Equal(t, k.Index("customer@example.com"), loaded.Index("customer@example.com"))
+
+	// values of older keys stay readable, new ones use the current key
+	decrypted, err := loaded.Decrypt(old)
+	require.NoError(t, err)
+	assert.Equal(t, "customer@example.com", decrypted)
+
+	encrypted, err := loaded.Encrypt("customer@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, Version(encrypted))
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"json":    "keys",
+		"current": `{"current": 2, "index_key": "` + strings.Repeat("A", 43) + `=", "keys": {}}`,
+		"size":    `{"current": 1, "index_key": "` + strings.Repeat("A", 43) + `=", "keys": {"1": "AAAA"}}`,
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		_, err := Load(path)
+		assert.Error(t, err, name)
+	}
+
+	_, err := Load(filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
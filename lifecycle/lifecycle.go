@@ -0,0 +1,80 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type stopHook struct {
+	name string
+	stop func(context.Context) error
+}
+
+// Manager runs the application until it is asked to stop and then stops
+// its parts in the order they were added, sharing a single deadline.
+type Manager struct {
+	timeout time.Duration
+	hooks   []stopHook
+}
+
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+	}
+}
+
+// OnStop registers a function that stops a part of the application
+func (m *Manager) OnStop(name string, stop func(context.Context) error) {
+	m.hooks = append(m.hooks, stopHook{name: name, stop: stop})
+}
+
+// Run calls serve and blocks until ctx is done or serve returns, then runs
+// the stop hooks. serve is expected to return once the first hook stops it.
+func (m *Manager) Run(ctx context.Context, serve func() error) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- serve()
+	}()
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	case serveErr = <-served:
+		served = nil
+		if serveErr != nil {
+			slog.Error("shutting down after error", "err", serveErr)
+		}
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	// the first error is returned
+	errs := []error{serveErr}
+
+	for _, h := range m.hooks {
+		if err := h.stop(stopCtx); err != nil {
+			slog.Error("stopping failed", "hook", h.name, "err", err)
+			errs = append(errs, fmt.Errorf("stopping %s: %w", h.name, err))
+		}
+	}
+
+	if served != nil {
+		select {
+		case err := <-served:
+			errs = append(errs, err)
+		case <-stopCtx.Done():
+			errs = append(errs, fmt.Errorf("serve did not return: %w", stopCtx.Err()))
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStopsInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopServe := make(chan struct{})
+	stopped := []string{}
+
+	m := NewManager(time.Second)
+	m.OnStop("server", func(context.Context) error {
+		stopped = append(stopped, "server")
+		close(stopServe)
+		return nil
+	})
+	m.OnStop("jobs", func(context.Context) error {
+		stopped = append(stopped, "jobs")
+		return nil
+	})
+	m.OnStop("store", func(context.Context) error {
+		stopped = append(stopped, "store")
+		return nil
+	})
+
+	go cancel()
+
+	err := m.Run(ctx, func() error {
+		<-stopServe
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"server", "jobs", "store"}, stopped)
+}
+
+func TestRunStopsAfterServeError(t *testing.T) {
+	serveErr := errors.New("address in use")
+	stopped := false
+
+	m := NewManager(time.Second)
+	m.OnStop("store", func(context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	err := m.Run(context.Background(), func() error {
+		return serveErr
+	})
+
+	assert.ErrorIs(t, err, serveErr)
+	assert.True(t, stopped)
+}
+
+func TestRunStopDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := NewManager(10 * time.Millisecond)
+	m.OnStop("jobs", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	block := make(chan struct{})
+	defer close(block)
+
+	err := m.Run(ctx, func() error {
+		<-block
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
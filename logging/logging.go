@@ -0,0 +1,97 @@
+// Package logging sets up the structured JSON logs of the service. Log
+// lines carry the request ID of the request they belong to, and personal
+// data is redacted unless disabled.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// level is the level of the default logger, it can be changed at runtime
+var level = new(slog.LevelVar)
+
+// Setup makes a JSON logger writing to out the default of log/slog and of
+// the log package
+func Setup(settings model.LogSettings, out io.Writer) error {
+	l, err := ParseLevel(settings.Level)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+
+	var handler slog.Handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	if settings.Redact {
+		handler = NewRedactingHandler(handler)
+	}
+
+	// lines of the log package go to the same handler at info level
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+// ParseLevel parses debug, info, warn or error, empty means info
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel changes the level of the default logger
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// LevelName is the lower case name of l as accepted by ParseLevel
+func LevelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+type requestIdKey struct{}
+
+// WithRequestId returns a context carrying the ID of a request
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the request ID of ctx, empty outside of a request
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// FromContext returns the default logger with the request ID and the trace
+// ID of ctx
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := WithRequest(RequestId(ctx))
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return logger
+}
+
+// WithRequest returns the default logger with a request ID, for code that
+// gets the ID without a context
+func WithRequest(requestId string) *slog.Logger {
+	if requestId == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", requestId)
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"customer customer@example.com paid", "customer [REDACTED] paid"},
+		{"call +359 888 123 456 now", "call [REDACTED] now"},
+		{"card 4111 1111 1111 1111", "card [REDACTED]"},
+		{"card 4111-1111-1111-1111", "card [REDACTED]"},
+		{"card 4111111111111111", "card [REDACTED]"},
+		// not Luhn valid
+		{"order 4111111111111112", "order 4111111111111112"},
+		{"merchant 3f5b6ad1-7a0c-4c52-9d1f-6f2d0c5e8a11", "merchant 3f5b6ad1-7a0c-4c52-9d1f-6f2d0c5e8a11"},
+		{"amount 100 at 2023-03-01T12:00:00Z", "amount 100 at 2023-03-01T12:00:00Z"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.out, RedactString(tc.in), tc.in)
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(NewRedactingHandler(slog.NewJSONHandler(out, nil))).
+		With("customer_email", "customer@example.com")
+
+	logger.Info("erased customer@example.com",
+		"phone", "0888123456",
+		"amount", 100,
+		"err", errors.New("no transactions of customer@example.com"),
+		slog.Group("card", "pan", "4111111111111111"))
+
+	line := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "erased [REDACTED]", line["msg"])
+	assert.Equal(t, Redacted, line["customer_email"])
+	assert.Equal(t, Redacted, line["phone"])
+	assert.Equal(t, float64(100), line["amount"])
+	assert.Equal(t, "no transactions of [REDACTED]", line["err"])
+	assert.Equal(t, map[string]any{"pan": Redacted}, line["card"])
+}
+
+func TestSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	defer SetLevel(slog.LevelInfo)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, Setup(model.LogSettings{Level: "warn", Redact: true}, out))
+	assert.Equal(t, slog.LevelWarn, Level())
+
+	slog.Info("dropped")
+	FromContext(WithRequestId(context.Background(), "abc")).Warn("kept", "email", "a@b.cd")
+	log.Printf("ignored at warn level")
+
+	line := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "kept", line["msg"])
+	assert.Equal(t, "abc", line["request_id"])
+	assert.Equal(t, Redacted, line["email"])
+
+	SetLevel(slog.LevelDebug)
+	out.Reset()
+	log.Printf("std log line")
+	assert.Contains(t, out.String(), `"msg":"std log line"`)
+
+	assert.Error(t, Setup(model.LogSettings{Level: "verbose"}, out))
+}
+
+func TestParseLevel(t *testing.T) {
+	l, err := ParseLevel("")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelInfo, l)
+
+	l, err = ParseLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, "debug", LevelName(l))
+
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces personal data in log lines
+const Redacted = "[REDACTED]"
+
+// redactedKeys are attributes that always hold personal data
+var redactedKeys = map[string]bool{
+	"email":          true,
+	"customer_email": true,
+	"phone":          true,
+	"customer_phone": true,
+	"card_number":    true,
+	"pan":            true,
+	"cvv":            true,
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+[0-9][0-9 \-]{6,18}[0-9]`)
+	// cardPattern matches 13 to 19 digits, optionally grouped by spaces or
+	// dashes, only Luhn valid numbers are redacted
+	cardPattern = regexp.MustCompile(`\b[0-9](?:[ \-]?[0-9]){12,18}\b`)
+)
+
+// RedactString replaces emails, phone numbers and card numbers in s
+func RedactString(s string) string {
+	s = emailPattern.ReplaceAllString(s, Redacted)
+	s = cardPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if luhnValid(match) {
+			return Redacted
+		}
+		return match
+	})
+	return phonePattern.ReplaceAllString(s, Redacted)
+}
+
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// RedactingHandler removes personal data from the message and attributes
+// of the records it passes to the wrapped handler
+type RedactingHandler struct {
+	next slog.Handler
+}
+
+func NewRedactingHandler(next slog.Handler) *RedactingHandler {
+	return &RedactingHandler{next: next}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, RedactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		redacted = append(redacted, redactAttr(a))
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, g := range group {
+			redacted = append(redacted, redactAttr(g))
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, RedactString(err.Error()))
+		}
+	}
+	return a
+}
@@ -1,38 +1,115 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"time"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/config"
+	"github.com/ivaylo-todorov/payment-system/lifecycle"
+	"github.com/ivaylo-todorov/payment-system/logging"
 	"github.com/ivaylo-todorov/payment-system/server"
+	"github.com/ivaylo-todorov/payment-system/tracing"
 )
 
 func main() {
-	settings := model.ApplicationSettings{
-		StoreSettings: model.StoreSettings{
-			ShowSQLQueries: false,
-		},
-		TransactionCleanupFrequency: time.Duration(60),
+	args := os.Args[1:]
+
+	command := ""
+	if len(args) != 0 && (args[0] == "migrate" || args[0] == "restore" || args[0] == "keyring" || args[0] == "audit") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	settings, options, err := config.Load(args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch command {
+	case "migrate":
+		if err := runMigrate(settings.StoreSettings, options.Args, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "restore":
+		if err := runRestore(settings, options.Args, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "keyring":
+		if err := runKeyring(settings.StoreSettings, options.Args, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "audit":
+		if err := runAudit(settings, options.Args, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if options.PrintConfig {
+		out, err := config.Print(settings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(out)
+		return
+	}
+
+	if err := logging.Setup(settings.LogSettings, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+
+	stopTracing, err := tracing.Setup(settings.TracingSettings)
+	if err != nil {
+		fatal(err)
 	}
 
 	webServer, err := server.NewServer(settings)
 	if err != nil {
-		log.Fatal(err.Error())
+		fatal(err)
 	}
 
-	err = webServer.StartTransactionsCleanup(settings.TransactionCleanupFrequency)
+	err = webServer.StartTransactionsCleanup(settings.CleanupSettings)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	err = webServer.Start()
+	err = webServer.StartReencryption()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	err = webServer.StopTransactionsCleanup()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manager := lifecycle.NewManager(settings.ServerSettings.ShutdownTimeout)
+	manager.OnStop("http server", webServer.Shutdown)
+	manager.OnStop("transactions cleanup", webServer.StopTransactionsCleanup)
+	manager.OnStop("re-encryption", webServer.StopReencryption)
+	manager.OnStop("store", webServer.Close)
+	manager.OnStop("tracing", stopTracing)
+
+	err = manager.Run(ctx, webServer.Start)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 }
+
+// fatal logs err and exits, the log package would drop it at the warn and
+// error levels
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
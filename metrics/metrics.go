@@ -0,0 +1,142 @@
+// Package metrics collects the metrics of the service and exposes them in
+// the Prometheus exposition format.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+const namespace = "payment"
+
+// Metrics holds the metrics of one server in its own registry, so servers
+// in the same process, e.g. in tests, do not share them
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+
+	transactions     *prometheus.CounterVec
+	startTransaction prometheus.Histogram
+	storeQueries     *prometheus.HistogramVec
+
+	cleanupRuns         prometheus.Counter
+	cleanupFailures     prometheus.Counter
+	cleanupTransactions prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transactions_created_total",
+			Help:      "Transactions created by type, status and the status of their merchant.",
+		}, []string{"type", "status", "merchant_status"}),
+		startTransaction: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "start_transaction_duration_seconds",
+			Help:      "Time spent validating and creating a transaction.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		storeQueries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_query_duration_seconds",
+			Help:      "Store call latency by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+
+		cleanupRuns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_runs_total",
+			Help:      "Runs of the transactions cleanup job.",
+		}),
+		cleanupFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_failures_total",
+			Help:      "Failed runs of the transactions cleanup job.",
+		}),
+		cleanupTransactions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_transactions_deleted_total",
+			Help:      "Transactions purged by the transactions cleanup job.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.transactions,
+		m.startTransaction,
+		m.storeQueries,
+		m.cleanupRuns,
+		m.cleanupFailures,
+		m.cleanupTransactions,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDB adds the connection pool statistics of db, name tells the
+// databases of a process apart
+func (m *Metrics) RegisterDB(db *sql.DB, name string) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRequest records a request handled by route, the path template of
+// the matched route
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.requestDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObserveStartTransaction(duration time.Duration) {
+	m.startTransaction.Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObserveStoreQuery(operation string, duration time.Duration) {
+	m.storeQueries.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+func (m *Metrics) TransactionCreated(t model.Transaction, merchantStatus string) {
+	m.transactions.WithLabelValues(t.Type, t.Status, merchantStatus).Inc()
+}
+
+// CleanupRun records a run of the transactions cleanup job, dry runs delete
+// nothing
+func (m *Metrics) CleanupRun(deleted int, err error) {
+	m.cleanupRuns.Inc()
+	if err != nil {
+		m.cleanupFailures.Inc()
+		return
+	}
+	m.cleanupTransactions.Add(float64(deleted))
+}
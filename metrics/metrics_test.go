@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store/memory"
+)
+
+func TestInstrumentStore(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	mem, err := memory.NewMemory(nil)
+	require.NoError(t, err)
+	s := InstrumentStore(mem, m)
+
+	merchant, err := s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, model.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@example.com",
+	})
+	require.NoError(t, err)
+
+	// failed creates are not counted
+	_, err = s.CreateTransaction(ctx, model.Transaction{MerchantId: uuid.New(), Type: model.TransactionTypeAuthorize})
+	require.Error(t, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.transactions.WithLabelValues(
+		model.TransactionTypeAuthorize, model.TransactionStatusApproved, model.MerchantStatusActive)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.storeQueries))
+}
+
+func TestCleanupRun(t *testing.T) {
+	m := New()
+
+	m.CleanupRun(3, nil)
+	m.CleanupRun(0, errors.New("database is locked"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.cleanupRuns))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.cleanupFailures))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.cleanupTransactions))
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+
+	m.ObserveRequest("/v1/merchants/{id}", "GET", 200, time.Millisecond)
+	m.ObserveRequest("/v1/merchants/{id}", "GET", 200, time.Millisecond)
+	m.ObserveRequest("/v1/merchants/{id}", "GET", 404, time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/v1/merchants/{id}", "GET", "200")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.requestDuration))
+}
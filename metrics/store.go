@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+// unknownStatus labels transactions whose store does not report the
+// merchant status
+const unknownStatus = "unknown"
+
+// Store times every call of the store it wraps and counts the transactions
+// created through it
+type Store struct {
+	store.Store
+
+	metrics *Metrics
+}
+
+func InstrumentStore(s store.Store, m *Metrics) *Store {
+	return &Store{
+		Store:   s,
+		metrics: m,
+	}
+}
+
+func (s *Store) observe(operation string, start time.Time) {
+	s.metrics.ObserveStoreQuery(operation, time.Since(start))
+}
+
+func (s *Store) CreateAdmin(ctx context.Context, a model.Admin) (model.Admin, error) {
+	defer s.observe("create_admin", time.Now())
+	return s.Store.CreateAdmin(ctx, a)
+}
+
+func (s *Store) CreateMerchant(ctx context.Context, m model.Merchant) (model.Merchant, error) {
+	defer s.observe("create_merchant", time.Now())
+	return s.Store.CreateMerchant(ctx, m)
+}
+
+func (s *Store) CreateMerchants(ctx context.Context, input []model.Merchant) ([]model.Merchant, error) {
+	defer s.observe("create_merchants", time.Now())
+	return s.Store.CreateMerchants(ctx, input)
+}
+
+func (s *Store) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (model.Merchant, error) {
+	defer s.observe("update_merchant", time.Now())
+	return s.Store.UpdateMerchant(ctx, p)
+}
+
+func (s *Store) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) error {
+	defer s.observe("delete_merchant", time.Now())
+	return s.Store.DeleteMerchant(ctx, id, version)
+}
+
+func (s *Store) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	defer s.observe("get_merchant", time.Now())
+	return s.Store.GetMerchant(ctx, id)
+}
+
+func (s *Store) GetMerchants(ctx context.Context, query model.MerchantQuery) ([]model.Merchant, error) {
+	defer s.observe("get_merchants", time.Now())
+	return s.Store.GetMerchants(ctx, query)
+}
+
+// CreateTransaction labels a created transaction with the merchant status
+// the store checked it against
+func (s *Store) CreateTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
+	defer s.observe("create_transaction", time.Now())
+
+	created, err := s.Store.CreateTransaction(ctx, t)
+	if err != nil {
+		return created, err
+	}
+
+	status := created.MerchantStatus
+	if status == "" {
+		status = unknownStatus
+	}
+	s.metrics.TransactionCreated(created, status)
+
+	return created, nil
+}
+
+func (s *Store) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
+	defer s.observe("get_transaction", time.Now())
+	return s.Store.GetTransaction(ctx, id)
+}
+
+func (s *Store) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	defer s.observe("get_transactions", time.Now())
+	return s.Store.GetTransactions(ctx, query)
+}
+
+func (s *Store) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	defer s.observe("delete_transactions", time.Now())
+	return s.Store.DeleteTransactions(ctx, query)
+}
+
+func (s *Store) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	defer s.observe("restore_transactions", time.Now())
+	return s.Store.RestoreTransactions(ctx, transactions)
+}
+
+func (s *Store) EraseCustomer(ctx context.Context, e model.CustomerErasure) (model.Erasure, int, error) {
+	defer s.observe("erase_customer", time.Now())
+	return s.Store.EraseCustomer(ctx, e)
+}
+
+func (s *Store) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	defer s.observe("get_erasures", time.Now())
+	return s.Store.GetErasures(ctx)
+}
+
+func (s *Store) AppendAudit(ctx context.Context, e model.AuditEntry) (model.AuditEntry, error) {
+	defer s.observe("append_audit", time.Now())
+	return s.Store.AppendAudit(ctx, e)
+}
+
+func (s *Store) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	defer s.observe("get_audit_entries", time.Now())
+	return s.Store.GetAuditEntries(ctx, query)
+}
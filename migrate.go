@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store/db"
+)
+
+const migrateUsage = "usage: payment-system migrate [flags] status|up|down|to <version>"
+
+// runMigrate runs the migrate subcommand against the SQLite database
+func runMigrate(settings model.StoreSettings, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	if settings.DummyDb || (settings.Backend != "" && settings.Backend != model.StoreBackendSQLite) {
+		return errors.New("migrations apply only to the sqlite store backend")
+	}
+
+	database, err := db.Open(settings)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if sqlDb, err := database.DB(); err == nil {
+			sqlDb.Close()
+		}
+	}()
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := db.MigrationsStatus(database)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			name := s.Name
+			if name == "" {
+				name = "(unknown)"
+			}
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, name, appliedAt)
+		}
+		return w.Flush()
+
+	case args[0] == "up" && len(args) == 1:
+		err = db.MigrateUp(database)
+
+	case args[0] == "down" && len(args) == 1:
+		err = db.MigrateDown(database)
+
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = db.MigrateTo(database, version)
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	version, err := db.SchemaVersion(database)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "schema version %d, latest %d\n", version, db.LatestVersion())
+
+	return nil
+}
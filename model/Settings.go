@@ -2,12 +2,121 @@ package model
 
 import "time"
 
+const (
+	StoreBackendSQLite = "sqlite"
+	StoreBackendBolt   = "bolt"
+	StoreBackendMemory = "memory"
+)
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+)
+
 type StoreSettings struct {
-	ShowSQLQueries bool
-	DummyDb        bool
+	// Backend is one of the StoreBackend* values, empty means SQLite
+	Backend        string `yaml:"backend"`
+	ShowSQLQueries bool   `yaml:"show_sql_queries"`
+	// DummyDb selects the memory backend
+	DummyDb bool   `yaml:"dummy_db"`
+	DbPath  string `yaml:"db_path"`
+	// KeyringFile holds the keys personal data is encrypted with, empty
+	// stores it in plain text
+	KeyringFile string `yaml:"keyring_file"`
+	// Timeout bounds every store operation, zero means no deadline
+	Timeout time.Duration `yaml:"timeout"`
+	// OperationTimeouts overrides Timeout for single operations, keyed by
+	// one of StoreOperations
+	OperationTimeouts map[string]time.Duration `yaml:"operation_timeouts"`
+}
+
+// StoreOperations names the operations of the store as in its metrics
+var StoreOperations = []string{
+	"create_admin",
+	"create_merchant",
+	"create_merchants",
+	"update_merchant",
+	"delete_merchant",
+	"get_merchant",
+	"get_merchants",
+	"create_transaction",
+	"get_transaction",
+	"get_transactions",
+	"delete_transactions",
+	"restore_transactions",
+	"erase_customer",
+	"get_erasures",
+	"append_audit",
+	"get_audit_entries",
+}
+
+type TLSSettings struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type ServerSettings struct {
+	ListenAddress string      `yaml:"listen_address"`
+	TLS           TLSSettings `yaml:"tls"`
+	// ShutdownTimeout bounds draining requests and stopping background jobs
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound
+	// the connections as in net/http.Server, zero means no timeout
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// MaxBodySize limits request bodies in bytes and MaxImportSize those
+	// of the bulk imports, zero means no limit
+	MaxBodySize   int64 `yaml:"max_body_size"`
+	MaxImportSize int64 `yaml:"max_import_size"`
+	// AdminToken is the bearer token of the admin routes
+	AdminToken string `yaml:"admin_token"`
+}
+
+type CleanupSettings struct {
+	// Frequency is the interval between transaction cleanup runs
+	Frequency time.Duration `yaml:"frequency"`
+	// Retention is how long transactions are kept, zero keeps them forever
+	Retention time.Duration `yaml:"retention"`
+	// StatusRetention overrides Retention for transactions in a status
+	StatusRetention map[string]time.Duration `yaml:"status_retention"`
+	// MerchantRetention overrides both for the transactions of a merchant,
+	// keyed by merchant uuid
+	MerchantRetention map[string]time.Duration `yaml:"merchant_retention"`
+	// DryRun only reports what the cleanup would purge
+	DryRun bool `yaml:"dry_run"`
+	// ArchiveDir receives a copy of the transactions before they are purged,
+	// empty purges them without a copy
+	ArchiveDir string `yaml:"archive_dir"`
+}
+
+type PrivacySettings struct {
+	// ErasureKey is the secret of the tokens replacing erased customer
+	// data, empty disables erasure. Changing it changes the tokens.
+	ErasureKey string `yaml:"erasure_key"`
+}
+
+type LogSettings struct {
+	// Level is debug, info, warn or error, it can be changed at runtime
+	Level string `yaml:"level"`
+	// Redact replaces customer emails, phones and card numbers in log lines
+	Redact bool `yaml:"redact"`
+}
+
+type TracingSettings struct {
+	// Exporter is one of the TracingExporter* values, empty means none
+	Exporter string `yaml:"exporter"`
+	// File receives the spans of the file exporter as JSON
+	File string `yaml:"file"`
 }
 
 type ApplicationSettings struct {
-	StoreSettings               StoreSettings
-	TransactionCleanupFrequency time.Duration // in minutes
+	ServerSettings  ServerSettings  `yaml:"server"`
+	StoreSettings   StoreSettings   `yaml:"store"`
+	CleanupSettings CleanupSettings `yaml:"cleanup"`
+	PrivacySettings PrivacySettings `yaml:"privacy"`
+	LogSettings     LogSettings     `yaml:"log"`
+	TracingSettings TracingSettings `yaml:"tracing"`
 }
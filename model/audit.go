@@ -0,0 +1,325 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditActionAdminCreate    = "admin.create"
+	AuditActionMerchantCreate = "merchant.create"
+	AuditActionMerchantUpdate = "merchant.update"
+	AuditActionMerchantDelete = "merchant.delete"
+	AuditActionCustomerErase  = "customer.erase"
+)
+
+const (
+	AuditTargetAdmin    = "admin"
+	AuditTargetMerchant = "merchant"
+	AuditTargetErasure  = "erasure"
+)
+
+// Actor is who asks for a change. The name is only the one the client
+// claims, Authenticated tells whether the request bore the admin token,
+// which vouches for the caller but not for the name.
+type Actor struct {
+	Name          string
+	Authenticated bool
+	RequestId     string
+}
+
+// Auditor builds the audit entries of the changes an actor asks for. The
+// stores append them in the transaction of the change, so a change is not
+// committed without its entry.
+type Auditor struct {
+	Actor Actor
+	// Pseudonymizer redacts the personal values of the changes
+	Pseudonymizer Pseudonymizer
+}
+
+type auditorKey struct{}
+
+// WithAuditor returns a copy of ctx in which the store changes are audited
+// by a
+func WithAuditor(ctx context.Context, a Auditor) context.Context {
+	return context.WithValue(ctx, auditorKey{}, a)
+}
+
+// AuditorFrom returns the auditor of ctx, nil if the changes made in ctx
+// are not audited
+func AuditorFrom(ctx context.Context) *Auditor {
+	a, ok := ctx.Value(auditorKey{}).(Auditor)
+	if !ok {
+		return nil
+	}
+	return &a
+}
+
+// Entry is the entry of an action on a target, it is sealed by the store
+func (a Auditor) Entry(action, targetType string, target uuid.UUID, changes []AuditChange) AuditEntry {
+	return AuditEntry{
+		Actor:         a.Actor.Name,
+		Authenticated: a.Actor.Authenticated,
+		RequestId:     a.Actor.RequestId,
+		Action:        action,
+		TargetType:    targetType,
+		TargetId:      target,
+		Changes:       changes,
+	}
+}
+
+// AdminCreated is the entry of the creation of admin
+func (a Auditor) AdminCreated(admin Admin) AuditEntry {
+	return a.Entry(AuditActionAdminCreate, AuditTargetAdmin, admin.Id, DiffAdmin(a.Pseudonymizer, Admin{}, admin))
+}
+
+// Merchant is the entry of action on a merchant, a created merchant has
+// no value before and a deleted one none after
+func (a Auditor) Merchant(action string, before, after Merchant) AuditEntry {
+	target := after.Id
+	if target == uuid.Nil {
+		target = before.Id
+	}
+	return a.Entry(action, AuditTargetMerchant, target, DiffMerchant(a.Pseudonymizer, before, after))
+}
+
+// Erasure is the entry of an erasure of a customer, a first erasure has no
+// record before
+func (a Auditor) Erasure(before, after Erasure) AuditEntry {
+	return a.Entry(AuditActionCustomerErase, AuditTargetErasure, after.Id, DiffErasure(before, after))
+}
+
+// AuditChange is the value of a field before and after a change, a created
+// target has no values before and a deleted one none after
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditEntry records an administrative action. Entries are only appended,
+// each one holds the hash of the one before it, so changing, removing or
+// reordering entries breaks the chain.
+type AuditEntry struct {
+	// Sequence numbers the entries from 1 without gaps
+	Sequence  int64
+	CreatedAt time.Time
+	// Actor is the name the actor claims, Authenticated tells whether its
+	// request bore the admin token
+	Actor         string
+	Authenticated bool
+	RequestId     string
+	Action        string
+	TargetType    string
+	TargetId      uuid.UUID
+	Changes       []AuditChange
+
+	// PrevHash is empty for the first entry
+	PrevHash string
+	Hash     string
+}
+
+// auditContent is the hashed form of an entry, its layout must not change
+type auditContent struct {
+	Sequence      int64         `json:"sequence"`
+	CreatedAt     string        `json:"created_at"`
+	Actor         string        `json:"actor"`
+	Authenticated bool          `json:"authenticated"`
+	RequestId     string        `json:"request_id"`
+	Action        string        `json:"action"`
+	TargetType    string        `json:"target_type"`
+	TargetId      string        `json:"target_id"`
+	Changes       []AuditChange `json:"changes"`
+	PrevHash      string        `json:"prev_hash"`
+}
+
+// ComputeHash is the SHA-256 of the content of e and the hash before it
+func (e AuditEntry) ComputeHash() string {
+	changes := e.Changes
+	if changes == nil {
+		changes = []AuditChange{}
+	}
+
+	content, _ := json.Marshal(auditContent{
+		Sequence:      e.Sequence,
+		CreatedAt:     e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Actor:         e.Actor,
+		Authenticated: e.Authenticated,
+		RequestId:     e.RequestId,
+		Action:        e.Action,
+		TargetType:    e.TargetType,
+		TargetId:      e.TargetId.String(),
+		Changes:       changes,
+		PrevHash:      e.PrevHash,
+	})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Seal links e to prev, the last entry of the log or nil for an empty log,
+// and sets its hash. Stores call it while no other entry can be appended.
+func (e AuditEntry) Seal(prev *AuditEntry) AuditEntry {
+	e.Sequence = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Sequence = prev.Sequence + 1
+		e.PrevHash = prev.Hash
+	}
+	e.Hash = e.ComputeHash()
+	return e
+}
+
+// AuditQuery selects the entries matching all of its non empty fields
+type AuditQuery struct {
+	Actor    string
+	Action   string
+	TargetId uuid.UUID
+	Since    *time.Time
+	Until    *time.Time
+}
+
+// Matches reports whether e is selected by q
+func (q AuditQuery) Matches(e AuditEntry) bool {
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if q.TargetId != uuid.Nil && e.TargetId != q.TargetId {
+		return false
+	}
+	if q.Since != nil && e.CreatedAt.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && !e.CreatedAt.Before(*q.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditBreak is an entry at which the chain does not hold
+type AuditBreak struct {
+	Sequence int64
+	Reason   string
+}
+
+// AuditVerification is the outcome of walking the audit chain
+type AuditVerification struct {
+	Entries int
+	// Head is the hash of the last entry. Entries removed from the end of
+	// the log are only noticed by comparing it with an earlier head.
+	Head   string
+	Breaks []AuditBreak
+}
+
+func (v AuditVerification) Valid() bool {
+	return len(v.Breaks) == 0
+}
+
+// VerifyAuditChain checks the entries of the whole log, given in sequence
+// order, and reports every entry that was changed or does not follow the
+// entry before it
+func VerifyAuditChain(entries []AuditEntry) AuditVerification {
+	v := AuditVerification{
+		Entries: len(entries),
+		Breaks:  []AuditBreak{},
+	}
+
+	var prev *AuditEntry
+	for i := range entries {
+		e := entries[i]
+
+		expected, prevHash := int64(1), ""
+		if prev != nil {
+			expected, prevHash = prev.Sequence+1, prev.Hash
+		}
+
+		if e.Sequence != expected {
+			v.Breaks = append(v.Breaks, AuditBreak{
+				Sequence: e.Sequence,
+				Reason:   fmt.Sprintf("expected sequence %d, entries are missing or reordered", expected),
+			})
+		} else if e.PrevHash != prevHash {
+			v.Breaks = append(v.Breaks, AuditBreak{
+				Sequence: e.Sequence,
+				Reason:   "previous hash does not match the entry before",
+			})
+		}
+
+		if e.ComputeHash() != e.Hash {
+			v.Breaks = append(v.Breaks, AuditBreak{
+				Sequence: e.Sequence,
+				Reason:   "hash does not match the content, the entry was changed",
+			})
+		}
+
+		prev = &entries[i]
+		v.Head = e.Hash
+	}
+
+	return v
+}
+
+// DiffAdmin lists the fields that differ between before and after, the
+// personal ones redacted by p
+func DiffAdmin(p Pseudonymizer, before, after Admin) []AuditChange {
+	changes := []AuditChange{}
+	changes = appendRedactedChange(changes, p, "name", before.Name, after.Name)
+	changes = appendRedactedChange(changes, p, "description", before.Description, after.Description)
+	changes = appendRedactedChange(changes, p, "email", before.Email, after.Email)
+	return changes
+}
+
+// DiffMerchant lists the fields that differ between before and after, the
+// personal ones redacted by p
+func DiffMerchant(p Pseudonymizer, before, after Merchant) []AuditChange {
+	changes := []AuditChange{}
+	changes = appendRedactedChange(changes, p, "name", before.Name, after.Name)
+	changes = appendRedactedChange(changes, p, "description", before.Description, after.Description)
+	changes = appendRedactedChange(changes, p, "email", before.Email, after.Email)
+	changes = appendChange(changes, "status", before.Status, after.Status)
+	changes = appendChange(changes, "status_reason", before.StatusReason, after.StatusReason)
+	changes = appendChange(changes, "version", formatVersion(before.Version), formatVersion(after.Version))
+	return changes
+}
+
+// DiffErasure lists the fields that differ between before and after, the
+// subject is the token of the customer and no personal data
+func DiffErasure(before, after Erasure) []AuditChange {
+	changes := []AuditChange{}
+	changes = appendChange(changes, "subject", before.Subject, after.Subject)
+	changes = appendChange(changes, "transactions", strconv.Itoa(before.Transactions), strconv.Itoa(after.Transactions))
+	return changes
+}
+
+func appendChange(changes []AuditChange, field, before, after string) []AuditChange {
+	if before == after {
+		return changes
+	}
+	return append(changes, AuditChange{Field: field, Before: before, After: after})
+}
+
+// appendRedactedChange records a change of a personal field by the tokens
+// of its values, so the hashed log holds no personal data
+func appendRedactedChange(changes []AuditChange, p Pseudonymizer, field, before, after string) []AuditChange {
+	if before == after {
+		return changes
+	}
+	return append(changes, AuditChange{Field: field, Before: p.Redact(field, before), After: p.Redact(field, after)})
+}
+
+func formatVersion(version int64) string {
+	if version == 0 {
+		return ""
+	}
+	return strconv.FormatInt(version, 10)
+}
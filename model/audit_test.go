@@ -0,0 +1,138 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func auditChain(n int) []AuditEntry {
+	entries := []AuditEntry{}
+	var prev *AuditEntry
+	for i := 0; i < n; i++ {
+		e := AuditEntry{
+			CreatedAt:  time.Date(2024, 1, 2, 3, 4, i, 0, time.UTC),
+			Actor:      "ops",
+			RequestId:  uuid.NewString(),
+			Action:     AuditActionMerchantUpdate,
+			TargetType: AuditTargetMerchant,
+			TargetId:   uuid.New(),
+			Changes:    []AuditChange{{Field: "name", Before: "old", After: "new"}},
+		}.Seal(prev)
+		entries = append(entries, e)
+		prev = &entries[len(entries)-1]
+	}
+	return entries
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	entries := auditChain(4)
+	assert.Equal(t, int64(1), entries[0].Sequence)
+	assert.Empty(t, entries[0].PrevHash)
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+
+	v := VerifyAuditChain(entries)
+	assert.True(t, v.Valid(), v.Breaks)
+	assert.Equal(t, 4, v.Entries)
+	assert.Equal(t, entries[3].Hash, v.Head)
+
+	assert.True(t, VerifyAuditChain(nil).Valid())
+
+	// the time zone of a stored time does not change the hash
+	local := entries[0]
+	local.CreatedAt = local.CreatedAt.In(time.FixedZone("EET", 2*60*60))
+	assert.Equal(t, entries[0].Hash, local.ComputeHash())
+}
+
+func TestVerifyAuditChainBreaks(t *testing.T) {
+	changed := auditChain(3)
+	changed[1].Changes[0].After = "forged"
+	v := VerifyAuditChain(changed)
+	assert.Equal(t, []int64{2}, breakSequences(v))
+
+	// rehashing a changed entry breaks the link of the next one
+	rehashed := auditChain(3)
+	rehashed[1].Actor = "someone else"
+	rehashed[1].Hash = rehashed[1].ComputeHash()
+	v = VerifyAuditChain(rehashed)
+	assert.Equal(t, []int64{3}, breakSequences(v))
+
+	removed := auditChain(3)
+	removed = append(removed[:1], removed[2])
+	v = VerifyAuditChain(removed)
+	assert.Equal(t, []int64{3}, breakSequences(v))
+
+	reordered := auditChain(3)
+	reordered[1], reordered[2] = reordered[2], reordered[1]
+	v = VerifyAuditChain(reordered)
+	assert.False(t, v.Valid())
+}
+
+func breakSequences(v AuditVerification) []int64 {
+	sequences := []int64{}
+	for _, b := range v.Breaks {
+		sequences = append(sequences, b.Sequence)
+	}
+	return sequences
+}
+
+func TestAuditDiff(t *testing.T) {
+	before := Merchant{Name: "name", Email: "merchant@example.com", Status: MerchantStatusActive, Version: 1}
+	after := before
+	after.Status = MerchantStatusSuspended
+	after.StatusReason = "fraud"
+	after.Version = 2
+
+	p := NewPseudonymizer("0123456789abcdef0123456789abcdef")
+
+	assert.Equal(t, []AuditChange{
+		{Field: "status", Before: MerchantStatusActive, After: MerchantStatusSuspended},
+		{Field: "status_reason", Before: "", After: "fraud"},
+		{Field: "version", Before: "1", After: "2"},
+	}, DiffMerchant(p, before, after))
+
+	assert.Equal(t, []AuditChange{
+		{Field: "name", Before: "", After: p.Redact("name", "admin")},
+	}, DiffAdmin(p, Admin{}, Admin{Name: "admin"}))
+}
+
+func TestAuditDiffRedacts(t *testing.T) {
+	before := Merchant{Name: "name", Email: "merchant@example.com", Status: MerchantStatusActive}
+	after := before
+	after.Email = "other@example.com"
+
+	p := NewPseudonymizer("0123456789abcdef0123456789abcdef")
+	changes := DiffMerchant(p, before, after)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "email", changes[0].Field)
+	assert.True(t, strings.HasPrefix(changes[0].Before, "redacted:"), changes[0].Before)
+	assert.NotEqual(t, changes[0].Before, changes[0].After)
+	assert.NotContains(t, changes[0].Before+changes[0].After, "example.com")
+
+	// the tokens are stable, so equal values can be told apart from changed ones
+	assert.Equal(t, changes, DiffMerchant(p, before, after))
+
+	// without a key the values are only marked
+	assert.Equal(t, []AuditChange{
+		{Field: "email", Before: "redacted", After: "redacted"},
+	}, DiffMerchant(Pseudonymizer{}, before, after))
+	assert.Equal(t, []AuditChange{
+		{Field: "email", Before: "", After: "redacted"},
+	}, DiffAdmin(Pseudonymizer{}, Admin{}, Admin{Email: "admin@example.com"}))
+}
+
+func TestAuditQuery(t *testing.T) {
+	e := auditChain(1)[0]
+	since := e.CreatedAt
+	until := e.CreatedAt.Add(time.Second)
+
+	assert.True(t, AuditQuery{}.Matches(e))
+	assert.True(t, AuditQuery{Actor: "ops", Action: AuditActionMerchantUpdate, TargetId: e.TargetId, Since: &since, Until: &until}.Matches(e))
+	assert.False(t, AuditQuery{Actor: "other"}.Matches(e))
+	assert.False(t, AuditQuery{TargetId: uuid.New()}.Matches(e))
+	assert.False(t, AuditQuery{Until: &since}.Matches(e))
+}
@@ -0,0 +1,6 @@
+package model
+
+import "time"
+
+// Clock returns the current time, tests replace it to control time
+type Clock func() time.Time
@@ -1,161 +1,321 @@
 package controller
 
 import (
-	"fmt"
+	"context"
+
+	"github.com/google/uuid"
 
 	"github.com/ivaylo-todorov/payment-system/model"
 	"github.com/ivaylo-todorov/payment-system/store"
 )
 
 type Controller interface {
-	CreateAdmins([]model.Admin) ([]model.Admin, error)
-
-	CreateMerchants([]model.Merchant) ([]model.Merchant, error)
-	UpdateMerchant(model.Merchant) (model.Merchant, error)
-	DeleteMerchant(model.Merchant) error
-	GetMerchants(model.MerchantQuery) ([]model.Merchant, error)
-
-	StartTransaction(model.Transaction) (model.Transaction, error)
-	GetTransactions(model.TransactionQuery) ([]model.Transaction, error)
-	DeleteTransactions(model.TransactionQuery) error
+	CreateAdmins(context.Context, model.Actor, []model.Admin) ([]model.Admin, error)
+
+	CreateMerchants(context.Context, model.Actor, []model.Merchant) ([]model.Merchant, error)
+	ImportMerchants(context.Context, model.Actor, []model.Merchant) []model.MerchantImportResult
+	UpdateMerchant(context.Context, model.Actor, model.Merchant) (model.Merchant, error)
+	PatchMerchant(context.Context, model.Actor, model.MerchantPatch) (model.Merchant, error)
+	DeleteMerchant(context.Context, model.Actor, model.Merchant) error
+	GetMerchant(context.Context, uuid.UUID) (model.Merchant, error)
+	GetMerchants(context.Context, model.MerchantQuery) ([]model.Merchant, error)
+
+	StartTransaction(context.Context, model.Transaction) (model.Transaction, error)
+	GetTransaction(context.Context, uuid.UUID) (model.Transaction, error)
+	GetTransactions(context.Context, model.TransactionQuery) ([]model.Transaction, error)
+	DeleteTransactions(context.Context, model.TransactionQuery) error
+	RestoreTransactions(context.Context, []model.Transaction) (int, error)
+
+	EraseCustomer(ctx context.Context, actor model.Actor, email string) (model.Erasure, int, error)
+	GetErasures(context.Context) ([]model.Erasure, error)
+
+	GetAuditEntries(context.Context, model.AuditQuery) ([]model.AuditEntry, error)
+	VerifyAudit(context.Context) (model.AuditVerification, error)
 }
 
 func NewController(settings model.ApplicationSettings, store store.Store) (*controller, error) {
 	return &controller{
-		Store: store,
+		Store:         store,
+		Pseudonymizer: model.NewPseudonymizer(settings.PrivacySettings.ErasureKey),
 	}, nil
 }
 
 type controller struct {
-	Store store.Store
+	Store         store.Store
+	Pseudonymizer model.Pseudonymizer
 }
 
-func (c *controller) CreateAdmins(input []model.Admin) ([]model.Admin, error) {
-	result := []model.Admin{}
-	for _, i := range input {
+func (c *controller) CreateAdmins(ctx context.Context, actor model.Actor, input []model.Admin) ([]model.Admin, error) {
+	errs := model.ValidationErrors{}
+	for n, i := range input {
 		if err := model.ValidateAdminCreate(i); err != nil {
-			return result, err
+			errs.Append(err, n+1)
 		}
+	}
+	if len(errs) != 0 {
+		return []model.Admin{}, errs
+	}
 
-		a, err := c.Store.CreateAdmin(i)
+	ctx = c.audited(ctx, actor)
+
+	result := []model.Admin{}
+	for n, i := range input {
+		a, err := c.Store.CreateAdmin(ctx, i)
 		if err != nil {
-			return result, err
+			return result, model.ErrorAtRow(err, n+1)
 		}
 		result = append(result, a)
 	}
 	return result, nil
 }
 
-func (c *controller) CreateMerchants(input []model.Merchant) ([]model.Merchant, error) {
-	result := []model.Merchant{}
-	for _, i := range input {
+func (c *controller) CreateMerchants(ctx context.Context, actor model.Actor, input []model.Merchant) ([]model.Merchant, error) {
+	errs := model.ValidationErrors{}
+	for n, i := range input {
+		if err := model.ValidateMerchantCreate(i); err != nil {
+			errs.Append(err, n+1)
+		}
+	}
+	if len(errs) != 0 {
+		return []model.Merchant{}, errs
+	}
+
+	return c.Store.CreateMerchants(c.audited(ctx, actor), input)
+}
+
+// ImportMerchants creates every valid merchant independently of the others
+// and reports the outcome for each row
+func (c *controller) ImportMerchants(ctx context.Context, actor model.Actor, input []model.Merchant) []model.MerchantImportResult {
+	ctx = c.audited(ctx, actor)
+
+	result := []model.MerchantImportResult{}
+	for n, i := range input {
+		r := model.MerchantImportResult{
+			Row:      n + 1,
+			Merchant: i,
+		}
+
 		if err := model.ValidateMerchantCreate(i); err != nil {
-			return result, err
+			r.Err = model.ErrorAtRow(err, r.Row)
+			result = append(result, r)
+			continue
 		}
 
-		m, err := c.Store.CreateMerchant(i)
+		m, err := c.Store.CreateMerchant(ctx, i)
 		if err != nil {
-			return result, err
+			r.Err = model.ErrorAtRow(err, r.Row)
+		} else {
+			r.Merchant = m
 		}
-		result = append(result, m)
+		result = append(result, r)
 	}
-	return result, nil
+	return result
 }
 
-func (c *controller) UpdateMerchant(merchant model.Merchant) (model.Merchant, error) {
+// UpdateMerchant changes the non empty fields of merchant
+func (c *controller) UpdateMerchant(ctx context.Context, actor model.Actor, merchant model.Merchant) (model.Merchant, error) {
 	if err := model.ValidateMerchantUpdate(merchant); err != nil {
 		return merchant, err
 	}
 
-	return c.Store.UpdateMerchant(merchant)
+	patch := model.MerchantPatch{
+		Id:      merchant.Id,
+		Version: merchant.Version,
+	}
+	if merchant.Name != "" {
+		patch.Name = &merchant.Name
+	}
+	if merchant.Description != "" {
+		patch.Description = &merchant.Description
+	}
+	if merchant.Email != "" {
+		patch.Email = &merchant.Email
+	}
+	if merchant.Status != "" {
+		patch.Status = &merchant.Status
+	}
+	if merchant.StatusReason != "" {
+		patch.StatusReason = &merchant.StatusReason
+	}
+
+	return c.Store.UpdateMerchant(c.audited(ctx, actor), patch)
+}
+
+func (c *controller) PatchMerchant(ctx context.Context, actor model.Actor, patch model.MerchantPatch) (model.Merchant, error) {
+	if err := model.ValidateMerchantPatch(patch); err != nil {
+		return model.Merchant{}, err
+	}
+
+	return c.Store.UpdateMerchant(c.audited(ctx, actor), patch)
 }
 
-func (c *controller) DeleteMerchant(merchant model.Merchant) error {
+func (c *controller) DeleteMerchant(ctx context.Context, actor model.Actor, merchant model.Merchant) error {
 	if err := model.ValidateMerchantDelete(merchant); err != nil {
 		return err
 	}
 
-	return c.Store.DeleteMerchant(merchant.Id)
+	return c.Store.DeleteMerchant(c.audited(ctx, actor), merchant.Id, merchant.Version)
+}
+
+func (c *controller) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	return c.Store.GetMerchant(ctx, id)
 }
 
-func (c *controller) GetMerchants(query model.MerchantQuery) ([]model.Merchant, error) {
-	return c.Store.GetMerchants(query)
+func (c *controller) GetMerchants(ctx context.Context, query model.MerchantQuery) ([]model.Merchant, error) {
+	return c.Store.GetMerchants(ctx, query)
 }
 
-func (c *controller) StartTransaction(transaction model.Transaction) (model.Transaction, error) {
+func (c *controller) StartTransaction(ctx context.Context, transaction model.Transaction) (model.Transaction, error) {
 	if err := model.ValidateTransactionCreate(transaction); err != nil {
 		return transaction, err
 	}
 
 	if transaction.Type == model.TransactionTypeAuthorize {
 		transaction.Status = model.TransactionStatusApproved
-		return c.Store.CreateTransaction(transaction)
+		return c.Store.CreateTransaction(ctx, transaction)
 	}
 
-	parent, err := c.Store.GetTransaction(transaction.ParentId)
+	parent, err := c.Store.GetTransaction(ctx, transaction.ParentId)
 	if err != nil {
 		return transaction, err
 	}
 
 	if parent.Status != model.TransactionStatusApproved && parent.Status != model.TransactionStatusRefunded {
 		transaction.Status = model.TransactionStatusError
-		return c.Store.CreateTransaction(transaction)
+		return c.Store.CreateTransaction(ctx, transaction)
 	}
 
 	if transaction.Type == model.TransactionTypeCharge {
 		if parent.Type != model.TransactionTypeAuthorize {
-			return transaction, fmt.Errorf("invalid reference transaction type, %s", parent.Type)
+			return transaction, model.NewPreconditionFailedError("parent_uuid", "invalid_parent_type", "invalid reference transaction type, %s", parent.Type)
 		}
 
 		if parent.Status != model.TransactionStatusApproved {
-			return transaction, fmt.Errorf("invalid reference transaction status, %s", parent.Status)
+			return transaction, model.NewPreconditionFailedError("parent_uuid", "invalid_parent_status", "invalid reference transaction status, %s", parent.Status)
 		}
 
 		if transaction.Amount > parent.Amount {
-			return transaction, fmt.Errorf("transaction amount bigger than authorized")
+			return transaction, model.NewPreconditionFailedError("amount", "amount_exceeds_authorized", "transaction amount bigger than authorized")
 		}
 
 		transaction.Status = model.TransactionStatusApproved
-		return c.Store.CreateTransaction(transaction)
+		return c.Store.CreateTransaction(ctx, transaction)
 	}
 
 	if transaction.Type == model.TransactionTypeRefund {
 		if parent.Type != model.TransactionTypeCharge {
-			return transaction, fmt.Errorf("invalid reference transaction type, %s", parent.Type)
+			return transaction, model.NewPreconditionFailedError("parent_uuid", "invalid_parent_type", "invalid reference transaction type, %s", parent.Type)
 		}
 
 		if parent.Status != model.TransactionStatusApproved {
-			return transaction, fmt.Errorf("invalid reference transaction status, %s", parent.Status)
+			return transaction, model.NewPreconditionFailedError("parent_uuid", "invalid_parent_status", "invalid reference transaction status, %s", parent.Status)
 		}
 
 		if transaction.Amount != parent.Amount {
-			return transaction, fmt.Errorf("transaction amount different than charged")
+			return transaction, model.NewPreconditionFailedError("amount", "amount_differs_from_charged", "transaction amount different than charged")
 		}
 
 		transaction.Status = model.TransactionStatusRefunded
-		return c.Store.CreateTransaction(transaction)
+		return c.Store.CreateTransaction(ctx, transaction)
 	}
 
 	if transaction.Type == model.TransactionTypeReversal {
 		if parent.Type != model.TransactionTypeAuthorize {
-			return transaction, fmt.Errorf("invalid reference transaction type, %s", parent.Type)
+			return transaction, model.NewPreconditionFailedError("parent_uuid", "invalid_parent_type", "invalid reference transaction type, %s", parent.Type)
 		}
 
 		if parent.Status != model.TransactionStatusApproved {
-			return transaction, fmt.Errorf("invalid reference transaction status, %s", parent.Status)
+			return transaction, model.NewPreconditionFailedError("parent_uuid", "invalid_parent_status", "invalid reference transaction status, %s", parent.Status)
 		}
 
 		transaction.Status = model.TransactionStatusReversed
-		return c.Store.CreateTransaction(transaction)
+		return c.Store.CreateTransaction(ctx, transaction)
 	}
 
-	return transaction, fmt.Errorf("invalid transaction type")
+	return transaction, model.NewValidationError("type", "invalid", "invalid transaction type")
 }
 
-func (c *controller) GetTransactions(query model.TransactionQuery) ([]model.Transaction, error) {
-	return c.Store.GetTransactions(query)
+func (c *controller) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
+	return c.Store.GetTransaction(ctx, id)
+}
+
+func (c *controller) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	return c.Store.GetTransactions(ctx, query)
+}
+
+func (c *controller) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	return c.Store.DeleteTransactions(ctx, query)
+}
+
+// RestoreTransactions restores archived transactions, the ones of erased
+// customers are pseudonymized again before they reach the store
+func (c *controller) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	erasures, err := c.Store.GetErasures(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(erasures) != 0 {
+		if !c.Pseudonymizer.Enabled() {
+			return 0, model.ErrErasureDisabled
+		}
+
+		erased := map[string]bool{}
+		for _, e := range erasures {
+			erased[e.Subject] = true
+		}
+
+		restored := make([]model.Transaction, 0, len(transactions))
+		for _, t := range transactions {
+			if erased[c.Pseudonymizer.Subject(t.CustomerEmail)] {
+				t = c.Pseudonymizer.Transaction(t)
+			}
+			restored = append(restored, t)
+		}
+		transactions = restored
+	}
+
+	return c.Store.RestoreTransactions(ctx, transactions)
+}
+
+// EraseCustomer pseudonymizes the transactions of the customer with email
+// and returns the erasure record with the number of erased transactions
+func (c *controller) EraseCustomer(ctx context.Context, actor model.Actor, email string) (model.Erasure, int, error) {
+	if !c.Pseudonymizer.Enabled() {
+		return model.Erasure{}, 0, model.ErrErasureDisabled
+	}
+
+	erasure := model.CustomerErasure{
+		Email:         email,
+		Pseudonymizer: c.Pseudonymizer,
+	}
+	if err := model.ValidateCustomerErasure(erasure); err != nil {
+		return model.Erasure{}, 0, err
+	}
+
+	return c.Store.EraseCustomer(c.audited(ctx, actor), erasure)
+}
+
+func (c *controller) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	return c.Store.GetErasures(ctx)
+}
+
+func (c *controller) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	return c.Store.GetAuditEntries(ctx, query)
+}
+
+// VerifyAudit walks the whole audit chain and reports where it breaks
+func (c *controller) VerifyAudit(ctx context.Context) (model.AuditVerification, error) {
+	entries, err := c.Store.GetAuditEntries(ctx, model.AuditQuery{})
+	if err != nil {
+		return model.AuditVerification{}, err
+	}
+
+	return model.VerifyAuditChain(entries), nil
 }
 
-func (c *controller) DeleteTransactions(query model.TransactionQuery) error {
-	return c.Store.DeleteTransactions(query)
+// audited makes the stores append the audit entries of the changes made in
+// ctx for actor, in the transactions of the changes
+func (c *controller) audited(ctx context.Context, actor model.Actor) context.Context {
+	return model.WithAuditor(ctx, model.Auditor{Actor: actor, Pseudonymizer: c.Pseudonymizer})
 }
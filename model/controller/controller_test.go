@@ -1,6 +1,7 @@
 package controller_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -54,12 +55,15 @@ var _ = Describe("Model Controller", func() {
 	c, err := controller.NewController(model.ApplicationSettings{}, s)
 	Expect(err).To(BeNil())
 
+	ctx := context.Background()
+	actor := model.Actor{Name: "ops", RequestId: "request-1"}
+
 	Context("initially", func() {
 		It("has 0 merchants", func() {
-			Expect(c.GetMerchants(model.MerchantQuery{})).To(HaveLen(0))
+			Expect(c.GetMerchants(ctx, model.MerchantQuery{})).To(HaveLen(0))
 		})
 		It("has 0 transactions", func() {
-			Expect(c.GetTransactions(model.TransactionQuery{})).To(HaveLen(0))
+			Expect(c.GetTransactions(ctx, model.TransactionQuery{})).To(HaveLen(0))
 		})
 	})
 
@@ -74,20 +78,21 @@ var _ = Describe("Model Controller", func() {
 		It("with empty name", func() {
 			a := admin
 			a.Name = ""
-			_, err := c.CreateAdmins([]model.Admin{a})
+			_, err := c.CreateAdmins(ctx, actor, []model.Admin{a})
 			Expect(err).Should(HaveOccurred())
+			Expect(model.KindOf(err)).To(Equal(model.ErrorKindValidation))
 		})
 
 		It("with invalid email", func() {
 			a := admin
 			a.Email = "my_email@"
-			_, err := c.CreateAdmins([]model.Admin{a})
+			_, err := c.CreateAdmins(ctx, actor, []model.Admin{a})
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
 			admins := []model.Admin{admin}
-			Expect(c.CreateAdmins(admins)).Should(Equal(admins))
+			Expect(c.CreateAdmins(ctx, actor, admins)).Should(Equal(admins))
 		})
 	})
 
@@ -102,24 +107,24 @@ var _ = Describe("Model Controller", func() {
 		It("with empty name", func() {
 			m := merchant
 			m.Name = ""
-			_, err := c.CreateMerchants([]model.Merchant{m})
+			_, err := c.CreateMerchants(ctx, actor, []model.Merchant{m})
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with invalid email", func() {
 			m := merchant
 			m.Email = "my_email@"
-			_, err := c.CreateMerchants([]model.Merchant{m})
+			_, err := c.CreateMerchants(ctx, actor, []model.Merchant{m})
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
 			merchants := []model.Merchant{merchant}
-			Expect(c.CreateMerchants(merchants)).Should(Equal(merchants))
+			Expect(c.CreateMerchants(ctx, actor, merchants)).Should(Equal(merchants))
 		})
 
 		It("has 1 merchants", func() {
-			Expect(c.GetMerchants(model.MerchantQuery{})).To(HaveLen(1))
+			Expect(c.GetMerchants(ctx, model.MerchantQuery{})).To(HaveLen(1))
 		})
 	})
 
@@ -134,17 +139,17 @@ var _ = Describe("Model Controller", func() {
 		It("with invalid status", func() {
 			m := merchant
 			m.Status = "wrong status"
-			_, err := c.CreateMerchants([]model.Merchant{m})
+			_, err := c.CreateMerchants(ctx, actor, []model.Merchant{m})
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
 			merchants := []model.Merchant{merchant}
-			Expect(c.CreateMerchants(merchants)).Should(Equal(merchants))
+			Expect(c.CreateMerchants(ctx, actor, merchants)).Should(Equal(merchants))
 		})
 
 		It("has w merchants", func() {
-			Expect(c.GetMerchants(model.MerchantQuery{})).To(HaveLen(2))
+			Expect(c.GetMerchants(ctx, model.MerchantQuery{})).To(HaveLen(2))
 		})
 	})
 
@@ -154,11 +159,40 @@ var _ = Describe("Model Controller", func() {
 			m := model.Merchant{
 				Name: "merchant_one_updated",
 			}
-			_, err := c.UpdateMerchant(m)
+			_, err := c.UpdateMerchant(ctx, actor, m)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("and name is cleared", func() {
+			empty := ""
+			_, err := c.PatchMerchant(ctx, actor, model.MerchantPatch{
+				Id:   store.MerchantOneUuid,
+				Name: &empty,
+			})
 			Expect(err).Should(HaveOccurred())
+			Expect(model.KindOf(err)).To(Equal(model.ErrorKindValidation))
 		})
 
-		// TODO: check Name, Description, Email update
+		It("and description is set and cleared", func() {
+			description := "some merchant"
+			r, err := c.PatchMerchant(ctx, actor, model.MerchantPatch{
+				Id:          store.MerchantOneUuid,
+				Description: &description,
+			})
+			Expect(err).Should(Succeed())
+			Expect(r.Description).To(Equal(description))
+
+			empty := ""
+			r, err = c.PatchMerchant(ctx, actor, model.MerchantPatch{
+				Id:          store.MerchantOneUuid,
+				Description: &empty,
+			})
+			Expect(err).Should(Succeed())
+			Expect(r.Description).To(BeEmpty())
+			Expect(r.Name).To(Equal("merchant_one"))
+		})
+
+		// TODO: check Name, Email update
 	})
 
 	Context("when an authorize transaction is created", func() {
@@ -173,44 +207,44 @@ var _ = Describe("Model Controller", func() {
 		It("with invalid type", func() {
 			t := transaction
 			t.Type = "type"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty merchant id", func() {
 			t := transaction
 			t.Id = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with invalid customer email", func() {
 			t := transaction
 			t.CustomerEmail = "customer"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with zero amount", func() {
 			t := transaction
 			t.Amount = 0
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with negative amount", func() {
 			t := transaction
 			t.Amount = -1
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
-			Expect(c.StartTransaction(transaction)).Should(TransactionStatus(model.TransactionStatusApproved))
+			Expect(c.StartTransaction(ctx, transaction)).Should(TransactionStatus(model.TransactionStatusApproved))
 		})
 
 		It("has 1 transactions", func() {
-			Expect(c.GetTransactions(model.TransactionQuery{})).To(HaveLen(1))
+			Expect(c.GetTransactions(ctx, model.TransactionQuery{})).To(HaveLen(1))
 		})
 	})
 
@@ -227,58 +261,58 @@ var _ = Describe("Model Controller", func() {
 		It("with invalid type", func() {
 			t := transaction
 			t.Type = "type"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty merchant id", func() {
 			t := transaction
 			t.Id = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with invalid customer email", func() {
 			t := transaction
 			t.CustomerEmail = "customer"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with zero amount", func() {
 			t := transaction
 			t.Amount = 0
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with negative amount", func() {
 			t := transaction
 			t.Amount = -1
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty parent id", func() {
 			t := transaction
 			t.ParentId = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with bigger amount", func() {
 			t := transaction
 			t.Amount = 1111
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
-			Expect(c.StartTransaction(transaction)).Should(TransactionStatus(model.TransactionStatusApproved))
+			Expect(c.StartTransaction(ctx, transaction)).Should(TransactionStatus(model.TransactionStatusApproved))
 		})
 
 		It("has 2 transactions", func() {
-			Expect(c.GetTransactions(model.TransactionQuery{})).To(HaveLen(2))
+			Expect(c.GetTransactions(ctx, model.TransactionQuery{})).To(HaveLen(2))
 		})
 	})
 
@@ -295,65 +329,66 @@ var _ = Describe("Model Controller", func() {
 		It("with invalid type", func() {
 			t := transaction
 			t.Type = "type"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty merchant id", func() {
 			t := transaction
 			t.Id = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with invalid customer email", func() {
 			t := transaction
 			t.CustomerEmail = "customer"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with zero amount", func() {
 			t := transaction
 			t.Amount = 0
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with negative amount", func() {
 			t := transaction
 			t.Amount = -1
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty parent id", func() {
 			t := transaction
 			t.ParentId = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with wrong parent type", func() {
 			t := transaction
 			t.ParentId = store.AuthorizeTransactionOneUuid
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
+			Expect(model.KindOf(err)).To(Equal(model.ErrorKindPreconditionFailed))
 		})
 
 		It("with different amount", func() {
 			t := transaction
 			t.Amount = 1111
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
-			Expect(c.StartTransaction(transaction)).Should(TransactionStatus(model.TransactionStatusRefunded))
+			Expect(c.StartTransaction(ctx, transaction)).Should(TransactionStatus(model.TransactionStatusRefunded))
 		})
 
 		It("has 3 transactions", func() {
-			Expect(c.GetTransactions(model.TransactionQuery{})).To(HaveLen(3))
+			Expect(c.GetTransactions(ctx, model.TransactionQuery{})).To(HaveLen(3))
 		})
 	})
 
@@ -369,25 +404,27 @@ var _ = Describe("Model Controller", func() {
 		It("with inactive merchant", func() {
 			t := transaction
 			t.Id = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		Context("then the merchant is activeted", func() {
-			m := model.Merchant{
-				Id:     store.MerchantTwoUuid,
-				Status: model.MerchantStatusActive,
-			}
-			r, err := c.UpdateMerchant(m)
-			Expect(err).Should(Succeed())
-			Expect(r.Status).To(Equal(model.MerchantStatusActive))
+			It("and the merchant is updated", func() {
+				m := model.Merchant{
+					Id:     store.MerchantTwoUuid,
+					Status: model.MerchantStatusActive,
+				}
+				r, err := c.UpdateMerchant(ctx, actor, m)
+				Expect(err).Should(Succeed())
+				Expect(r.Status).To(Equal(model.MerchantStatusActive))
+			})
 
 			It("successfully", func() {
-				Expect(c.StartTransaction(transaction)).Should(TransactionStatus(model.TransactionStatusApproved))
+				Expect(c.StartTransaction(ctx, transaction)).Should(TransactionStatus(model.TransactionStatusApproved))
 			})
 
 			It("has 4 transactions", func() {
-				Expect(c.GetTransactions(model.TransactionQuery{})).To(HaveLen(4))
+				Expect(c.GetTransactions(ctx, model.TransactionQuery{})).To(HaveLen(4))
 			})
 		})
 	})
@@ -405,44 +442,44 @@ var _ = Describe("Model Controller", func() {
 		It("with invalid type", func() {
 			t := transaction
 			t.Type = "type"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty merchant id", func() {
 			t := transaction
 			t.Id = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with invalid customer email", func() {
 			t := transaction
 			t.CustomerEmail = "customer"
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with non zero amount", func() {
 			t := transaction
 			t.Amount = 120
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("with empty parent id", func() {
 			t := transaction
 			t.ParentId = uuid.Nil
-			_, err := c.StartTransaction(t)
+			_, err := c.StartTransaction(ctx, t)
 			Expect(err).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
-			Expect(c.StartTransaction(transaction)).Should(TransactionStatus(model.TransactionStatusReversed))
+			Expect(c.StartTransaction(ctx, transaction)).Should(TransactionStatus(model.TransactionStatusReversed))
 		})
 
 		It("has 5 transactions", func() {
-			Expect(c.GetTransactions(model.TransactionQuery{})).To(HaveLen(5))
+			Expect(c.GetTransactions(ctx, model.TransactionQuery{})).To(HaveLen(5))
 		})
 	})
 
@@ -452,14 +489,68 @@ var _ = Describe("Model Controller", func() {
 			m := model.Merchant{
 				Name: "merchant_one_updated",
 			}
-			Expect(c.DeleteMerchant(m)).Should(HaveOccurred())
+			Expect(c.DeleteMerchant(ctx, actor, m)).Should(HaveOccurred())
 		})
 
 		It("successfully", func() {
 			m := model.Merchant{
 				Id: store.MerchantOneUuid,
 			}
-			Expect(c.DeleteMerchant(m)).Should(Succeed())
+			Expect(c.DeleteMerchant(ctx, actor, m)).Should(Succeed())
+		})
+	})
+
+	Context("when the audit log is read", func() {
+
+		It("has an entry for every change", func() {
+			entries, err := c.GetAuditEntries(ctx, model.AuditQuery{Action: model.AuditActionAdminCreate})
+			Expect(err).Should(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Actor).To(Equal("ops"))
+			Expect(entries[0].RequestId).To(Equal("request-1"))
+			Expect(entries[0].TargetId).To(Equal(store.AdminUuid))
+
+			entries, err = c.GetAuditEntries(ctx, model.AuditQuery{Action: model.AuditActionMerchantCreate})
+			Expect(err).Should(Succeed())
+			Expect(entries).To(HaveLen(2))
+		})
+
+		It("records the merchant before it was deleted", func() {
+			entries, err := c.GetAuditEntries(ctx, model.AuditQuery{Action: model.AuditActionMerchantDelete})
+			Expect(err).Should(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].TargetId).To(Equal(store.MerchantOneUuid))
+			Expect(entries[0].Changes).To(ContainElement(model.AuditChange{Field: "email", Before: "redacted"}))
+		})
+
+		It("has a valid chain", func() {
+			v, err := c.VerifyAudit(ctx)
+			Expect(err).Should(Succeed())
+			Expect(v.Valid()).To(BeTrue())
+			Expect(v.Entries).ToNot(BeZero())
+		})
+	})
+
+	Context("when a customer is erased", func() {
+		erasing, err := controller.NewController(model.ApplicationSettings{
+			PrivacySettings: model.PrivacySettings{ErasureKey: "0123456789abcdef0123456789abcdef"},
+		}, s)
+		Expect(err).To(BeNil())
+
+		It("and erasure is disabled", func() {
+			_, _, err := c.EraseCustomer(ctx, actor, "customer@email.com")
+			Expect(err).Should(MatchError(model.ErrErasureDisabled))
+		})
+
+		It("with invalid email", func() {
+			_, _, err := erasing.EraseCustomer(ctx, actor, "customer")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("successfully", func() {
+			erasure, _, err := erasing.EraseCustomer(ctx, actor, "customer@email.com")
+			Expect(err).Should(Succeed())
+			Expect(erasure.Subject).ShouldNot(ContainSubstring("customer"))
 		})
 	})
 
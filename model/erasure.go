@@ -0,0 +1,115 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// erasedDomain marks the customer emails of erased transactions
+const erasedDomain = "@erased.invalid"
+
+// redacted marks the personal values left out of the audit log
+const redacted = "redacted"
+
+var (
+	ErrErasureDisabled = NewPreconditionFailedError("", "erasure_disabled", "customer erasure is disabled, privacy.erasure_key is empty")
+)
+
+// Pseudonymizer replaces customer data with tokens. A token is an HMAC of
+// the value under a secret key, so the same value always gives the same
+// token but a token cannot be traced back without the key.
+type Pseudonymizer struct {
+	key []byte
+}
+
+func NewPseudonymizer(key string) Pseudonymizer {
+	return Pseudonymizer{key: []byte(key)}
+}
+
+// Enabled reports whether the pseudonymizer has a key
+func (p Pseudonymizer) Enabled() bool {
+	return len(p.key) != 0
+}
+
+// Subject is the token of a customer email, emails differing only in case
+// or surrounding spaces give the same token
+func (p Pseudonymizer) Subject(email string) string {
+	return p.token("email", NormalizeCustomerEmail(email))
+}
+
+// Email is the pseudonymized customer email, it stays a valid address
+func (p Pseudonymizer) Email(email string) string {
+	return "erased-" + p.Subject(email) + erasedDomain
+}
+
+// Phone is the pseudonymized customer phone, an empty phone stays empty
+func (p Pseudonymizer) Phone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return ""
+	}
+	return "erased-" + p.token("phone", phone)
+}
+
+// Transaction returns the transaction with its customer data pseudonymized,
+// transactions already erased are returned unchanged
+func (p Pseudonymizer) Transaction(t Transaction) Transaction {
+	if IsErasedEmail(t.CustomerEmail) {
+		return t
+	}
+	t.CustomerEmail = p.Email(t.CustomerEmail)
+	t.CustomerPhone = p.Phone(t.CustomerPhone)
+	return t
+}
+
+// Redact is the token recorded in place of a personal value, it tells
+// whether the value changed without revealing it. Without a key the value
+// is only marked as redacted, an empty value stays empty.
+func (p Pseudonymizer) Redact(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	if !p.Enabled() {
+		return redacted
+	}
+	return redacted + ":" + p.token("audit-"+field, value)
+}
+
+func (p Pseudonymizer) token(kind, value string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(kind + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NormalizeCustomerEmail is the form in which customer emails are matched
+func NormalizeCustomerEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// IsErasedEmail reports whether email was pseudonymized by an erasure
+func IsErasedEmail(email string) bool {
+	return strings.HasPrefix(email, "erased-") && strings.HasSuffix(email, erasedDomain)
+}
+
+// CustomerErasure asks to pseudonymize every transaction of the customer
+// with Email
+type CustomerErasure struct {
+	Email         string
+	Pseudonymizer Pseudonymizer
+}
+
+// Erasure is the audit record of the erasures of a customer. It holds no
+// personal data, the customer is identified by the token of the email.
+type Erasure struct {
+	Id      uuid.UUID
+	Subject string
+	// Transactions is the number of transactions pseudonymized so far
+	Transactions int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPseudonymizer(t *testing.T) {
+	p := NewPseudonymizer("0123456789abcdef0123456789abcdef")
+
+	// tokens are stable and ignore case and surrounding spaces
+	assert.Equal(t, p.Email("customer@example.com"), p.Email(" Customer@Example.com "))
+	assert.NotEqual(t, p.Email("customer@example.com"), p.Email("other@example.com"))
+	assert.NotContains(t, p.Email("customer@example.com"), "customer")
+	assert.True(t, IsErasedEmail(p.Email("customer@example.com")))
+	assert.False(t, IsErasedEmail("customer@example.com"))
+
+	_, err := mail.ParseAddress(p.Email("customer@example.com"))
+	assert.NoError(t, err)
+
+	assert.Empty(t, p.Phone(""))
+	assert.NotContains(t, p.Phone("+359888123456"), "888")
+
+	// tokens depend on the key
+	other := NewPseudonymizer("fedcba9876543210fedcba9876543210")
+	assert.NotEqual(t, p.Subject("customer@example.com"), other.Subject("customer@example.com"))
+	assert.False(t, NewPseudonymizer("").Enabled())
+
+	// erased transactions stay as they are
+	erased := p.Transaction(Transaction{CustomerEmail: "customer@example.com", CustomerPhone: "+359888123456", Amount: 100})
+	assert.Equal(t, p.Email("customer@example.com"), erased.CustomerEmail)
+	assert.Equal(t, int64(100), erased.Amount)
+	assert.Equal(t, erased, p.Transaction(erased))
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ErrorKind int
+
+const (
+	ErrorKindInternal ErrorKind = iota
+	ErrorKindValidation
+	ErrorKindNotFound
+	ErrorKindConflict
+	ErrorKindPreconditionFailed
+	ErrorKindVersionMismatch
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindValidation:
+		return "validation"
+	case ErrorKindNotFound:
+		return "not_found"
+	case ErrorKindConflict:
+		return "conflict"
+	case ErrorKindPreconditionFailed:
+		return "precondition_failed"
+	case ErrorKindVersionMismatch:
+		return "version_mismatch"
+	}
+	return "internal"
+}
+
+// Error is a domain error. Code is a stable machine readable identifier,
+// Field is the input field the error refers to, if any. Row is the 1-based
+// position of the offending record in a batch input, 0 otherwise.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Field   string
+	Row     int
+	Message string
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Field != "" {
+		msg = fmt.Sprintf("%s: %s", e.Field, msg)
+	}
+	if e.Row != 0 {
+		msg = fmt.Sprintf("row %d: %s", e.Row, msg)
+	}
+	return msg
+}
+
+// Is reports errors with the same kind and code as equal, so that
+// copies of the sentinel errors, e.g. with Row set, still match them
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Kind == t.Kind && e.Code == t.Code
+}
+
+// ValidationErrors holds every violation found in an input
+type ValidationErrors []*Error
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *ValidationErrors) Add(field, code, format string, a ...any) {
+	*v = append(*v, NewValidationError(field, code, format, a...))
+}
+
+// Append adds the domain errors found in err, tagged with the batch row
+func (v *ValidationErrors) Append(err error, row int) {
+	var errs ValidationErrors
+	if errors.As(err, &errs) {
+		*v = append(*v, errs.WithRow(row)...)
+		return
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		*v = append(*v, ValidationErrors{e}.WithRow(row)...)
+		return
+	}
+	*v = append(*v, &Error{Kind: ErrorKindValidation, Code: "invalid", Row: row, Message: err.Error()})
+}
+
+// WithRow returns a copy of the errors with Row set
+func (v ValidationErrors) WithRow(row int) ValidationErrors {
+	result := make(ValidationErrors, 0, len(v))
+	for _, e := range v {
+		c := *e
+		c.Row = row
+		result = append(result, &c)
+	}
+	return result
+}
+
+// Err returns nil when there are no violations, so that a nil
+// ValidationErrors is never returned as a non-nil error.
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func NewValidationError(field, code, format string, a ...any) *Error {
+	return &Error{Kind: ErrorKindValidation, Code: code, Field: field, Message: fmt.Sprintf(format, a...)}
+}
+
+func NewNotFoundError(code, format string, a ...any) *Error {
+	return &Error{Kind: ErrorKindNotFound, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func NewConflictError(field, code, format string, a ...any) *Error {
+	return &Error{Kind: ErrorKindConflict, Code: code, Field: field, Message: fmt.Sprintf(format, a...)}
+}
+
+func NewPreconditionFailedError(field, code, format string, a ...any) *Error {
+	return &Error{Kind: ErrorKindPreconditionFailed, Code: code, Field: field, Message: fmt.Sprintf(format, a...)}
+}
+
+// KindOf returns the kind of the first domain error in err's chain,
+// or ErrorKindInternal if there is none.
+func KindOf(err error) ErrorKind {
+	var v ValidationErrors
+	if errors.As(err, &v) {
+		return ErrorKindValidation
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ErrorKindInternal
+}
+
+// ErrorAtRow attaches a batch row number to the domain errors in err
+func ErrorAtRow(err error, row int) error {
+	var v ValidationErrors
+	if errors.As(err, &v) {
+		return v.WithRow(row)
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		c := *e
+		c.Row = row
+		return &c
+	}
+	return err
+}
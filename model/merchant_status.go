@@ -0,0 +1,89 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Merchants start pending or active. A closed merchant is anonymized,
+// which frees its email, and cannot change any more.
+//
+//	pending   -> active, closed
+//	active    -> suspended, closed, inactive
+//	suspended -> active, closed
+//	inactive  -> active, closed
+//
+// Transactions a merchant accepts in each status:
+//
+//	pending, inactive  none
+//	active             all
+//	suspended, closed  refunds and reversals, so that held and charged
+//	                   funds can still be returned to the customers
+var merchantTransitions = map[string][]string{
+	MerchantStatusPending:   {MerchantStatusActive, MerchantStatusClosed},
+	MerchantStatusActive:    {MerchantStatusSuspended, MerchantStatusClosed, MerchantStatusInactive},
+	MerchantStatusSuspended: {MerchantStatusActive, MerchantStatusClosed},
+	MerchantStatusInactive:  {MerchantStatusActive, MerchantStatusClosed},
+}
+
+// IsMerchantStatus reports whether status is a known merchant status
+func IsMerchantStatus(status string) bool {
+	_, ok := merchantTransitions[status]
+	return ok || status == MerchantStatusClosed
+}
+
+// CheckMerchantTransition returns an error unless a merchant may move from
+// status from to status to. Staying in the same status is allowed, except
+// for closed merchants.
+func CheckMerchantTransition(from, to string) error {
+	if from == MerchantStatusClosed {
+		return ErrMerchantClosed
+	}
+	if from == to {
+		return nil
+	}
+	for _, allowed := range merchantTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return NewPreconditionFailedError("status", ErrInvalidStatusTransition.Code,
+		"merchant status cannot change from %s to %s", from, to)
+}
+
+// CheckMerchantTransaction returns an error unless a merchant in status
+// accepts new transactions of transactionType
+func CheckMerchantTransaction(status, transactionType string) error {
+	refundOrReversal := transactionType == TransactionTypeRefund || transactionType == TransactionTypeReversal
+
+	switch status {
+	case MerchantStatusActive:
+		return nil
+	case MerchantStatusSuspended:
+		if refundOrReversal {
+			return nil
+		}
+		return ErrMerchantSuspended
+	case MerchantStatusClosed:
+		if refundOrReversal {
+			return nil
+		}
+		return ErrMerchantClosed
+	}
+	return ErrMerchantNotActive
+}
+
+// AnonymizedMerchant returns the merchant with its personal data replaced,
+// the email stays unique but no longer blocks the original one
+func AnonymizedMerchant(m Merchant) Merchant {
+	m.Name = "closed merchant"
+	m.Description = ""
+	m.Email = AnonymizedEmail(m.Id)
+	return m
+}
+
+// AnonymizedEmail is the email of a closed merchant
+func AnonymizedEmail(id uuid.UUID) string {
+	return fmt.Sprintf("closed-%s@anonymized.invalid", id)
+}
@@ -1,7 +1,6 @@
 package model
 
 import (
-	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,7 +10,12 @@ const (
 	UserRoleAdmin    = "admin"
 	UserRoleMerchant = "merchant"
 
-	MerchantStatusActive   = "active"
+	MerchantStatusPending   = "pending"
+	MerchantStatusActive    = "active"
+	MerchantStatusSuspended = "suspended"
+	MerchantStatusClosed    = "closed"
+	// MerchantStatusInactive is the status from before the lifecycle, it
+	// behaves like pending but may also be entered from active
 	MerchantStatusInactive = "inactive"
 
 	TransactionTypeAuthorize = "authorize"
@@ -26,9 +30,19 @@ const (
 )
 
 var (
-	ErrRecordNotFound      = errors.New("record not found")
-	ErrMerchantNotFound    = errors.New("merchant not found")
-	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrRecordNotFound      = NewNotFoundError("record_not_found", "record not found")
+	ErrMerchantNotFound    = NewNotFoundError("merchant_not_found", "merchant not found")
+	ErrTransactionNotFound = NewNotFoundError("transaction_not_found", "transaction not found")
+
+	ErrMerchantNotActive       = NewPreconditionFailedError("merchant_uuid", "merchant_not_active", "merchant is not active")
+	ErrMerchantSuspended       = NewPreconditionFailedError("merchant_uuid", "merchant_suspended", "merchant is suspended")
+	ErrMerchantClosed          = NewPreconditionFailedError("merchant_uuid", "merchant_closed", "merchant is closed")
+	ErrInvalidStatusTransition = NewPreconditionFailedError("status", "invalid_status_transition", "invalid merchant status transition")
+	ErrParentNotFound          = NewPreconditionFailedError("parent_uuid", "parent_not_found", "reference transaction not found")
+	ErrMerchantHasTransactions = NewConflictError("", "merchant_has_transactions", "cannot delete merchant with transactions")
+	ErrEmailAlreadyExists      = NewConflictError("email", "email_already_exists", "email already exists")
+
+	ErrMerchantVersionMismatch = &Error{Kind: ErrorKindVersionMismatch, Code: "merchant_version_mismatch", Message: "merchant was modified concurrently"}
 )
 
 type Admin struct {
@@ -44,8 +58,15 @@ type Merchant struct {
 	Description string
 	Email       string
 
-	Status             string
+	Status string
+	// StatusReason explains the last status change
+	StatusReason    string
+	StatusChangedAt time.Time
+
 	TransactionsAmount int64
+
+	// Version is incremented on every change of the merchant
+	Version int64
 }
 
 type Transaction struct {
@@ -57,11 +78,79 @@ type Transaction struct {
 	Status        string
 	CustomerEmail string
 	CustomerPhone string
+	CreatedAt     time.Time
+
+	// MerchantStatus is the status of the merchant when the transaction was
+	// created, only CreateTransaction sets it
+	MerchantStatus string
+}
+
+// MerchantPatch holds the merchant fields to change, nil fields are left
+// unchanged and empty strings clear the field. A non zero Version must
+// match the current merchant version.
+type MerchantPatch struct {
+	Id          uuid.UUID
+	Version     int64
+	Name        *string
+	Description *string
+	Email       *string
+	Status      *string
+	// StatusReason is set together with Status
+	StatusReason *string
+}
+
+// MerchantImportResult is the outcome of importing a single merchant,
+// Row is its 1-based position in the imported batch
+type MerchantImportResult struct {
+	Row      int
+	Merchant Merchant
+	Err      error
 }
 
 type MerchantQuery struct {
 }
 
+// TransactionQuery selects transactions created before OlderThan and, when
+// Ids or ParentIds are not empty, only the listed ones or the children of
+// the listed ones.
+//
+// Reading transactions, at most Limit of them are returned in creation
+// order, starting after the transaction After. Deleting ignores both.
 type TransactionQuery struct {
 	OlderThan *time.Time
+	Ids       []uuid.UUID
+	ParentIds []uuid.UUID
+
+	After uuid.UUID
+	Limit int
+}
+
+// Selects reports whether q narrows down the transactions. Without, reading
+// returns all transactions and deleting deletes none.
+func (q TransactionQuery) Selects() bool {
+	return q.OlderThan != nil || len(q.Ids) != 0 || len(q.ParentIds) != 0
+}
+
+// Matches reports whether t is selected by q, regardless of After and
+// Limit
+func (q TransactionQuery) Matches(t Transaction) bool {
+	if q.OlderThan != nil && !t.CreatedAt.Before(*q.OlderThan) {
+		return false
+	}
+	if len(q.Ids) != 0 && !containsId(q.Ids, t.Id) {
+		return false
+	}
+	if len(q.ParentIds) != 0 && !containsId(q.ParentIds, t.ParentId) {
+		return false
+	}
+	return true
+}
+
+func containsId(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
 }
@@ -1,103 +1,143 @@
 package model
 
 import (
-	"fmt"
 	"net/mail"
 
 	"github.com/google/uuid"
 )
 
+// Validate* functions report every violation found in their input
+// as ValidationErrors, or nil if the input is valid.
+
 func ValidateAdminCreate(a Admin) error {
+	errs := ValidationErrors{}
 	if a.Name == "" {
-		return fmt.Errorf("name cannot be empty")
-	}
-	if err := validateEmailString(a.Email); err != nil {
-		return err
+		errs.Add("name", "required", "name cannot be empty")
 	}
-	return nil
+	validateEmailString(&errs, "email", a.Email)
+	return errs.Err()
 }
 
 func ValidateMerchantCreate(m Merchant) error {
-	if m.Status != MerchantStatusActive && m.Status != MerchantStatusInactive {
-		return fmt.Errorf("invalid merchant status")
+	errs := ValidationErrors{}
+	switch m.Status {
+	case MerchantStatusPending, MerchantStatusActive, MerchantStatusInactive:
+	case MerchantStatusSuspended, MerchantStatusClosed:
+		errs.Add("status", "invalid", "merchants cannot be created %s", m.Status)
+	default:
+		errs.Add("status", "invalid", "invalid merchant status")
 	}
 	if m.Name == "" {
-		return fmt.Errorf("name cannot be empty")
-	}
-	if err := validateEmailString(m.Email); err != nil {
-		return err
+		errs.Add("name", "required", "name cannot be empty")
 	}
-	return nil
+	validateEmailString(&errs, "email", m.Email)
+	return errs.Err()
 }
 
 func ValidateMerchantUpdate(m Merchant) error {
+	errs := ValidationErrors{}
 	if m.Id == uuid.Nil {
-		return fmt.Errorf("missing merchant id")
+		errs.Add("uuid", "required", "missing merchant id")
 	}
 
 	if m.Status != "" {
-		if m.Status != MerchantStatusActive && m.Status != MerchantStatusInactive {
-			return fmt.Errorf("invalid merchant status")
-		}
+		reason := m.StatusReason
+		validateStatusChange(&errs, &m.Status, &reason)
 	}
 
 	if m.Email != "" {
-		if err := validateEmailString(m.Email); err != nil {
-			return err
+		validateEmailString(&errs, "email", m.Email)
+	}
+	return errs.Err()
+}
+
+func ValidateMerchantPatch(p MerchantPatch) error {
+	errs := ValidationErrors{}
+	if p.Id == uuid.Nil {
+		errs.Add("uuid", "required", "missing merchant id")
+	}
+	if p.Name != nil && *p.Name == "" {
+		errs.Add("name", "required", "name cannot be empty")
+	}
+	if p.Email != nil {
+		validateEmailString(&errs, "email", *p.Email)
+	}
+	if p.Status != nil || p.StatusReason != nil {
+		validateStatusChange(&errs, p.Status, p.StatusReason)
+	}
+	return errs.Err()
+}
+
+// validateStatusChange checks a new status, suspending and closing need a
+// reason
+func validateStatusChange(errs *ValidationErrors, status, reason *string) {
+	if status == nil {
+		errs.Add("status_reason", "requires_status", "status reason can only be set together with the status")
+		return
+	}
+	if !IsMerchantStatus(*status) {
+		errs.Add("status", "invalid", "invalid merchant status")
+		return
+	}
+	if *status == MerchantStatusSuspended || *status == MerchantStatusClosed {
+		if reason == nil || *reason == "" {
+			errs.Add("status_reason", "required", "a reason is required to change the status to %s", *status)
 		}
 	}
-	return nil
 }
 
 func ValidateMerchantDelete(m Merchant) error {
+	errs := ValidationErrors{}
 	if m.Id == uuid.Nil {
-		return fmt.Errorf("missing merchant id")
+		errs.Add("uuid", "required", "missing merchant id")
 	}
-	return nil
+	return errs.Err()
 }
 
 func ValidateTransactionCreate(t Transaction) error {
+	errs := ValidationErrors{}
 	if t.MerchantId == uuid.Nil {
-		return fmt.Errorf("missing merchant id")
-	}
-	if err := validateEmailString(t.CustomerEmail); err != nil {
-		return err
+		errs.Add("merchant_uuid", "required", "missing merchant id")
 	}
+	validateEmailString(&errs, "customer_email", t.CustomerEmail)
 	if t.Status != "" {
-		return fmt.Errorf("transaction status must be empty")
+		errs.Add("status", "must_be_empty", "transaction status must be empty")
 	}
 
-	if t.Type == TransactionTypeAuthorize {
+	switch t.Type {
+	case TransactionTypeAuthorize:
 		if t.Amount <= 0 {
-			return fmt.Errorf("zero transaction amount")
+			errs.Add("amount", "not_positive", "zero transaction amount")
 		}
-		return nil
-	}
-
-	if t.Type == TransactionTypeCharge || t.Type == TransactionTypeRefund {
+	case TransactionTypeCharge, TransactionTypeRefund:
 		if t.ParentId == uuid.Nil {
-			return fmt.Errorf("missing reference transaction id")
+			errs.Add("parent_uuid", "required", "missing reference transaction id")
 		}
 		if t.Amount <= 0 {
-			return fmt.Errorf("zeero transaction amount")
+			errs.Add("amount", "not_positive", "zero transaction amount")
 		}
-		return nil
-	}
-
-	if t.Type == TransactionTypeReversal {
+	case TransactionTypeReversal:
 		if t.ParentId == uuid.Nil {
-			return fmt.Errorf("missing reference transaction id")
+			errs.Add("parent_uuid", "required", "missing reference transaction id")
 		}
 		if t.Amount != 0 {
-			return fmt.Errorf("transaction amount should be zero")
+			errs.Add("amount", "must_be_zero", "transaction amount should be zero")
 		}
-		return nil
+	default:
+		errs.Add("type", "invalid", "invalid transaction type, %s", t.Type)
 	}
 
-	return fmt.Errorf("invalid transaction type, %s", t.Type)
+	return errs.Err()
 }
 
-func validateEmailString(address string) error {
-	_, err := mail.ParseAddress(address)
-	return err
+func ValidateCustomerErasure(e CustomerErasure) error {
+	errs := ValidationErrors{}
+	validateEmailString(&errs, "customer_email", e.Email)
+	return errs.Err()
+}
+
+func validateEmailString(errs *ValidationErrors, field, address string) {
+	if _, err := mail.ParseAddress(address); err != nil {
+		errs.Add(field, "invalid_email", "%s", err.Error())
+	}
 }
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTransactionCreateCollectsAllViolations(t *testing.T) {
+	err := ValidateTransactionCreate(Transaction{
+		Type:          TransactionTypeCharge,
+		CustomerEmail: "customer",
+		Status:        TransactionStatusApproved,
+	})
+	require.Error(t, err)
+
+	var errs ValidationErrors
+	require.True(t, errors.As(err, &errs))
+
+	fields := []string{}
+	for _, e := range errs {
+		assert.Equal(t, ErrorKindValidation, e.Kind)
+		assert.NotEmpty(t, e.Code)
+		fields = append(fields, e.Field)
+	}
+
+	assert.ElementsMatch(t, []string{"merchant_uuid", "customer_email", "status", "parent_uuid", "amount"}, fields)
+}
+
+func TestValidateMerchantCreateValid(t *testing.T) {
+	err := ValidateMerchantCreate(Merchant{
+		Name:   "merchant",
+		Email:  "merchant@email.com",
+		Status: MerchantStatusActive,
+	})
+	assert.NoError(t, err)
+}
+
+func TestValidationErrorsAppendRow(t *testing.T) {
+	errs := ValidationErrors{}
+	errs.Append(ValidateAdminCreate(Admin{Email: "admin@email.com"}), 3)
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, 3, errs[0].Row)
+	assert.Equal(t, "name", errs[0].Field)
+	assert.Equal(t, "row 3: name: name cannot be empty", errs.Error())
+}
+
+func TestValidateMerchantPatchStatusReason(t *testing.T) {
+	id := uuid.New()
+	suspended := MerchantStatusSuspended
+	reason := "fraud"
+
+	err := ValidateMerchantPatch(MerchantPatch{Id: id, Status: &suspended})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status_reason")
+
+	assert.NoError(t, ValidateMerchantPatch(MerchantPatch{Id: id, Status: &suspended, StatusReason: &reason}))
+
+	err = ValidateMerchantPatch(MerchantPatch{Id: id, StatusReason: &reason})
+	assert.Error(t, err)
+
+	closed := MerchantStatusClosed
+	err = ValidateMerchantCreate(Merchant{Name: "merchant", Email: "merchant@email.com", Status: closed})
+	assert.Error(t, err)
+}
+
+func TestCheckMerchantTransition(t *testing.T) {
+	assert.NoError(t, CheckMerchantTransition(MerchantStatusPending, MerchantStatusActive))
+	assert.NoError(t, CheckMerchantTransition(MerchantStatusSuspended, MerchantStatusActive))
+	assert.NoError(t, CheckMerchantTransition(MerchantStatusActive, MerchantStatusActive))
+	assert.ErrorIs(t, CheckMerchantTransition(MerchantStatusPending, MerchantStatusSuspended), ErrInvalidStatusTransition)
+	assert.ErrorIs(t, CheckMerchantTransition(MerchantStatusClosed, MerchantStatusClosed), ErrMerchantClosed)
+}
+
+func TestCheckMerchantTransaction(t *testing.T) {
+	for _, status := range []string{MerchantStatusPending, MerchantStatusInactive} {
+		assert.ErrorIs(t, CheckMerchantTransaction(status, TransactionTypeReversal), ErrMerchantNotActive)
+	}
+
+	assert.NoError(t, CheckMerchantTransaction(MerchantStatusActive, TransactionTypeAuthorize))
+
+	assert.ErrorIs(t, CheckMerchantTransaction(MerchantStatusSuspended, TransactionTypeCharge), ErrMerchantSuspended)
+	assert.NoError(t, CheckMerchantTransaction(MerchantStatusSuspended, TransactionTypeRefund))
+
+	assert.ErrorIs(t, CheckMerchantTransaction(MerchantStatusClosed, TransactionTypeAuthorize), ErrMerchantClosed)
+	assert.NoError(t, CheckMerchantTransaction(MerchantStatusClosed, TransactionTypeReversal))
+}
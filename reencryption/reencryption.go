@@ -0,0 +1,180 @@
+// Package reencryption moves the personal data of a store to the current
+// key of its keyring in the background, e.g. after a key rotation.
+package reencryption
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// rows are re-encrypted in batches so that writers are not blocked for long
+const batchSize = 200
+
+// ErrRunning is returned when a run is started while another one runs
+var ErrRunning = model.NewConflictError("", "reencryption_running", "re-encryption is already running")
+
+// Store is the part of the store the job works on
+type Store interface {
+	KeyVersion() int
+	PendingReencryption() (int, error)
+	ReencryptBatch(limit int) (int, error)
+}
+
+// Progress describes the current or the last run of the job
+type Progress struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Running    bool
+	KeyVersion int
+
+	// Total is the number of rows pending when the run started
+	Total       int
+	Reencrypted int
+
+	Err error
+}
+
+// Remaining is the number of the rows pending at the start not yet done
+func (p Progress) Remaining() int {
+	if p.Reencrypted > p.Total {
+		return 0
+	}
+	return p.Total - p.Reencrypted
+}
+
+type Job struct {
+	store Store
+	clock model.Clock
+
+	mu       sync.Mutex
+	progress *Progress
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewJob returns a job on store, a nil clock means time.Now
+func NewJob(store Store, clock model.Clock) *Job {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	return &Job{
+		store: store,
+		clock: clock,
+	}
+}
+
+// Progress returns the progress of the current or the last run
+func (j *Job) Progress() (Progress, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.progress == nil {
+		return Progress{}, false
+	}
+	return *j.progress, true
+}
+
+// Start starts a run in the background
+func (j *Job) Start() (Progress, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.progress != nil && j.progress.Running {
+		return *j.progress, ErrRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.done = make(chan struct{})
+	j.progress = &Progress{
+		StartedAt:  j.clock(),
+		Running:    true,
+		KeyVersion: j.store.KeyVersion(),
+	}
+	progress := *j.progress
+
+	go func(done chan struct{}) {
+		defer close(done)
+		defer cancel()
+		j.run(ctx)
+	}(j.done)
+
+	return progress, nil
+}
+
+// Stop cancels the current run and waits for it to stop or for ctx to be
+// done. The rows re-encrypted so far stay re-encrypted.
+func (j *Job) Stop(ctx context.Context) error {
+	j.mu.Lock()
+	cancel, done := j.cancel, j.done
+	j.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (j *Job) run(ctx context.Context) {
+	total, err := j.store.PendingReencryption()
+	j.update(func(p *Progress) {
+		p.Total = total
+	})
+	if err != nil {
+		j.finish(err)
+		return
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			j.finish(err)
+			return
+		}
+
+		done, err := j.store.ReencryptBatch(batchSize)
+		if err != nil {
+			j.finish(err)
+			return
+		}
+		if done == 0 {
+			j.finish(nil)
+			return
+		}
+
+		j.update(func(p *Progress) {
+			p.Reencrypted += done
+		})
+	}
+}
+
+func (j *Job) update(fn func(*Progress)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fn(j.progress)
+}
+
+func (j *Job) finish(err error) {
+	if errors.Is(err, context.Canceled) {
+		err = errors.New("re-encryption was stopped")
+	}
+
+	j.update(func(p *Progress) {
+		p.Running = false
+		p.FinishedAt = j.clock()
+		p.Err = err
+	})
+}
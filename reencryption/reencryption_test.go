@@ -0,0 +1,128 @@
+package reencryption
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStore struct {
+	mu      sync.Mutex
+	pending int
+	err     error
+	// block makes every batch wait until it is closed, entered is
+	// signalled when a batch waits
+	block   chan struct{}
+	entered chan struct{}
+}
+
+func (s *fakeStore) KeyVersion() int {
+	return 2
+}
+
+func (s *fakeStore) PendingReencryption() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending, nil
+}
+
+func (s *fakeStore) ReencryptBatch(limit int) (int, error) {
+	if s.block != nil {
+		select {
+		case s.entered <- struct{}{}:
+		default:
+		}
+		<-s.block
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+	done := limit
+	if s.pending < done {
+		done = s.pending
+	}
+	s.pending -= done
+	return done, nil
+}
+
+func wait(t *testing.T, j *Job) Progress {
+	require.Eventually(t, func() bool {
+		p, ok := j.Progress()
+		return ok && !p.Running
+	}, 5*time.Second, time.Millisecond)
+
+	p, _ := j.Progress()
+	return p
+}
+
+func TestRun(t *testing.T) {
+	store := &fakeStore{pending: 2*batchSize + 10}
+	j := NewJob(store, nil)
+
+	_, ok := j.Progress()
+	assert.False(t, ok)
+
+	started, err := j.Start()
+	require.NoError(t, err)
+	assert.True(t, started.Running)
+	assert.Equal(t, 2, started.KeyVersion)
+
+	p := wait(t, j)
+	require.NoError(t, p.Err)
+	assert.Equal(t, 2*batchSize+10, p.Total)
+	assert.Equal(t, 2*batchSize+10, p.Reencrypted)
+	assert.Zero(t, p.Remaining())
+	assert.False(t, p.FinishedAt.IsZero())
+}
+
+func TestRunFails(t *testing.T) {
+	store := &fakeStore{pending: 10, err: errors.New("unknown key")}
+	j := NewJob(store, nil)
+
+	_, err := j.Start()
+	require.NoError(t, err)
+
+	p := wait(t, j)
+	assert.EqualError(t, p.Err, "unknown key")
+	assert.Equal(t, 10, p.Remaining())
+
+	// a failed run can be started again
+	store.mu.Lock()
+	store.err = nil
+	store.mu.Unlock()
+
+	_, err = j.Start()
+	require.NoError(t, err)
+	assert.NoError(t, wait(t, j).Err)
+}
+
+func TestStartWhileRunningAndStop(t *testing.T) {
+	store := &fakeStore{pending: 10, block: make(chan struct{}), entered: make(chan struct{}, 1)}
+	j := NewJob(store, nil)
+
+	_, err := j.Start()
+	require.NoError(t, err)
+	<-store.entered
+
+	_, err = j.Start()
+	assert.ErrorIs(t, err, ErrRunning)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, j.Stop(ctx), context.DeadlineExceeded)
+
+	close(store.block)
+	require.NoError(t, j.Stop(context.Background()))
+
+	p, _ := j.Progress()
+	assert.False(t, p.Running)
+}
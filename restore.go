@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ivaylo-todorov/payment-system/archive"
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/model/controller"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+const restoreUsage = "usage: payment-system restore [flags] <manifest>"
+
+// runRestore runs the restore subcommand, it restores the archive of a
+// manifest given relative to the archive directory into the store
+func runRestore(settings model.ApplicationSettings, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(restoreUsage)
+	}
+
+	if settings.CleanupSettings.ArchiveDir == "" {
+		return errors.New("archiving is disabled, cleanup.archive_dir is empty")
+	}
+
+	s, err := store.NewStore(settings.StoreSettings, nil)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// the controller pseudonymizes the transactions of erased customers
+	c, err := controller.NewController(settings, s)
+	if err != nil {
+		return err
+	}
+
+	manifest, restored, err := archive.New(settings.CleanupSettings.ArchiveDir, store.Keyring(s), nil).Restore(context.Background(), args[0], c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "restored %d of %d transactions from %s\n", restored, manifest.Transactions, args[0])
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package retention
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// chain is a root transaction followed by all transactions below it
+type chain struct {
+	transactions []model.Transaction
+	// complete chains start with an authorization and reference no
+	// missing transaction
+	complete bool
+}
+
+// settled reports whether nothing more is expected to happen to the chain,
+// i.e. the authorization was reversed, failed or charged
+func (c chain) settled() bool {
+	root := c.transactions[0]
+
+	if root.Status == model.TransactionStatusReversed || root.Status == model.TransactionStatusError {
+		return true
+	}
+
+	for _, t := range c.transactions[1:] {
+		if t.Type != model.TransactionTypeCharge || t.ParentId != root.Id {
+			continue
+		}
+		if t.Status == model.TransactionStatusApproved || t.Status == model.TransactionStatusRefunded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildChains groups transactions by the topmost ancestor found among them
+func buildChains(transactions []model.Transaction) []chain {
+	byId := map[uuid.UUID]model.Transaction{}
+	children := map[uuid.UUID][]uuid.UUID{}
+
+	for _, t := range transactions {
+		byId[t.Id] = t
+	}
+	for _, t := range transactions {
+		if _, ok := byId[t.ParentId]; ok {
+			children[t.ParentId] = append(children[t.ParentId], t.Id)
+		}
+	}
+
+	chains := []chain{}
+
+	for _, root := range transactions {
+		if _, ok := byId[root.ParentId]; ok {
+			continue
+		}
+
+		c := chain{
+			complete: root.ParentId == uuid.Nil && root.Type == model.TransactionTypeAuthorize,
+		}
+
+		queue := []uuid.UUID{root.Id}
+		for len(queue) != 0 {
+			t := byId[queue[0]]
+			queue = append(queue[1:], children[t.Id]...)
+			c.transactions = append(c.transactions, t)
+		}
+
+		chains = append(chains, c)
+	}
+
+	return chains
+}
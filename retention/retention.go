@@ -0,0 +1,335 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/archive"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// chains are purged in batches to bound the size of a single delete
+const purgeBatchSize = 500
+
+// transactions are read in pages to bound the size of a single read
+const readPageSize = 1000
+
+// Reasons a chain is kept
+const (
+	KeptRetained   = "retained"
+	KeptUnsettled  = "unsettled"
+	KeptIncomplete = "incomplete"
+)
+
+// Store is the part of the controller or store the engine works on
+type Store interface {
+	GetTransactions(context.Context, model.TransactionQuery) ([]model.Transaction, error)
+	DeleteTransactions(context.Context, model.TransactionQuery) error
+}
+
+// Report describes a single run of the engine
+type Report struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	DryRun     bool
+
+	// Transactions and Chains count those older than the shortest
+	// retention period, the others are not read
+	Transactions int
+	Chains       int
+
+	// PurgedChains and PurgedTransactions are those purged, or in a dry
+	// run those that would be purged
+	PurgedChains       int
+	PurgedTransactions int
+	PurgedByStatus     map[string]int
+	// Kept counts the kept chains by reason
+	Kept map[string]int
+
+	// Purged lists the root transactions of the purged chains in a dry run
+	Purged []uuid.UUID
+	// Archive is the manifest of the archived copy of the purged chains,
+	// relative to the archive directory
+	Archive string
+
+	Err error
+}
+
+// Engine purges complete, settled transaction chains whose transactions
+// are all older than their retention period. A chain is an authorization
+// and everything referencing it, directly or not.
+//
+// The retention period of a transaction is the one of its merchant if set,
+// else the one of its status if set, else the default. A zero period keeps
+// the transaction forever.
+//
+// With an archive directory the expired chains are archived first, and
+// nothing is purged when that fails.
+type Engine struct {
+	settings model.CleanupSettings
+	store    Store
+	clock    model.Clock
+	archive  *archive.Archive
+
+	mu   sync.Mutex
+	last *Report
+}
+
+// NewEngine returns an engine for the policy in settings archiving to a, a
+// nil archive disables archiving and a nil clock means time.Now
+func NewEngine(settings model.CleanupSettings, store Store, a *archive.Archive, clock model.Clock) *Engine {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	e := &Engine{
+		settings: settings,
+		store:    store,
+		clock:    clock,
+		archive:  a,
+	}
+
+	return e
+}
+
+// LastRun returns the report of the last call to Run
+func (e *Engine) LastRun() (Report, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.last == nil {
+		return Report{}, false
+	}
+	return *e.last, true
+}
+
+// Run purges the expired chains, or only reports them when dryRun is set.
+// The report of every run is kept for LastRun, the error is also part of it.
+func (e *Engine) Run(ctx context.Context, dryRun bool) (Report, error) {
+	report := e.run(ctx, dryRun)
+
+	e.mu.Lock()
+	e.last = &report
+	e.mu.Unlock()
+
+	return report, report.Err
+}
+
+// DryRun reports what Run would purge without changing LastRun
+func (e *Engine) DryRun(ctx context.Context) (Report, error) {
+	report := e.run(ctx, true)
+	return report, report.Err
+}
+
+func (e *Engine) run(ctx context.Context, dryRun bool) Report {
+	now := e.clock()
+
+	report := Report{
+		StartedAt:      now,
+		DryRun:         dryRun,
+		PurgedByStatus: map[string]int{},
+		Kept:           map[string]int{},
+	}
+
+	// no chain expires while a transaction in it is newer than the
+	// shortest period
+	shortest := e.shortestPeriod()
+	if shortest <= 0 {
+		report.FinishedAt = e.clock()
+		return report
+	}
+
+	transactions, err := e.olderThan(ctx, now.Add(-shortest))
+	if err != nil {
+		report.Err = err
+		report.FinishedAt = e.clock()
+		return report
+	}
+
+	report.Transactions = len(transactions)
+
+	expired := [][]model.Transaction{}
+
+	for _, chain := range buildChains(transactions) {
+		report.Chains++
+
+		switch {
+		case !chain.complete:
+			report.Kept[KeptIncomplete]++
+		case !chain.settled():
+			report.Kept[KeptUnsettled]++
+		case !e.expired(chain, now):
+			report.Kept[KeptRetained]++
+		default:
+			expired = append(expired, chain.transactions)
+		}
+	}
+
+	// the chains read may continue with newer transactions
+	expired, continued, err := e.withoutNewer(ctx, expired)
+	if err != nil {
+		report.Err = err
+		report.FinishedAt = e.clock()
+		return report
+	}
+	if continued != 0 {
+		report.Kept[KeptRetained] += continued
+	}
+
+	if !dryRun && e.archive != nil && len(expired) != 0 {
+		report.Archive, err = e.archive.Write(expired)
+		if err != nil {
+			report.Err = err
+			report.FinishedAt = e.clock()
+			return report
+		}
+	}
+
+	for start := 0; start < len(expired); start += purgeBatchSize {
+		end := start + purgeBatchSize
+		if end > len(expired) {
+			end = len(expired)
+		}
+		batch := expired[start:end]
+
+		ids := []uuid.UUID{}
+		for _, chain := range batch {
+			for _, t := range chain {
+				ids = append(ids, t.Id)
+			}
+		}
+
+		if !dryRun {
+			if err := e.store.DeleteTransactions(ctx, model.TransactionQuery{Ids: ids}); err != nil {
+				report.Err = err
+				break
+			}
+		}
+
+		for _, chain := range batch {
+			report.PurgedChains++
+			report.PurgedTransactions += len(chain)
+			for _, t := range chain {
+				report.PurgedByStatus[t.Status]++
+			}
+			if dryRun {
+				report.Purged = append(report.Purged, chain[0].Id)
+			}
+		}
+	}
+
+	report.FinishedAt = e.clock()
+	return report
+}
+
+// olderThan pages through the transactions created before t
+func (e *Engine) olderThan(ctx context.Context, t time.Time) ([]model.Transaction, error) {
+	transactions := []model.Transaction{}
+
+	query := model.TransactionQuery{OlderThan: &t, Limit: readPageSize}
+	for {
+		page, err := e.store.GetTransactions(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, page...)
+
+		if len(page) < readPageSize {
+			return transactions, nil
+		}
+		query.After = page[len(page)-1].Id
+	}
+}
+
+// withoutNewer drops the chains with a child not among their
+// transactions, created after those were read. It returns the remaining
+// chains and the number dropped.
+func (e *Engine) withoutNewer(ctx context.Context, chains [][]model.Transaction) ([][]model.Transaction, int, error) {
+	remaining := [][]model.Transaction{}
+	dropped := 0
+
+	for start := 0; start < len(chains); start += purgeBatchSize {
+		end := start + purgeBatchSize
+		if end > len(chains) {
+			end = len(chains)
+		}
+		batch := chains[start:end]
+
+		// the chain of every transaction of the batch
+		chainOf := map[uuid.UUID]int{}
+		ids := []uuid.UUID{}
+		for i, chain := range batch {
+			for _, t := range chain {
+				chainOf[t.Id] = i
+				ids = append(ids, t.Id)
+			}
+		}
+
+		children, err := e.store.GetTransactions(ctx, model.TransactionQuery{ParentIds: ids})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		continued := map[int]bool{}
+		for _, child := range children {
+			if _, ok := chainOf[child.Id]; !ok {
+				continued[chainOf[child.ParentId]] = true
+			}
+		}
+
+		for i, chain := range batch {
+			if continued[i] {
+				dropped++
+				continue
+			}
+			remaining = append(remaining, chain)
+		}
+	}
+
+	return remaining, dropped, nil
+}
+
+// shortestPeriod is the shortest retention period that is not forever,
+// zero when all are
+func (e *Engine) shortestPeriod() time.Duration {
+	periods := []time.Duration{e.settings.Retention}
+	for _, period := range e.settings.MerchantRetention {
+		periods = append(periods, period)
+	}
+	for _, period := range e.settings.StatusRetention {
+		periods = append(periods, period)
+	}
+
+	shortest := time.Duration(0)
+	for _, period := range periods {
+		if period > 0 && (shortest == 0 || period < shortest) {
+			shortest = period
+		}
+	}
+	return shortest
+}
+
+// expired reports whether every transaction of the chain outlived its
+// retention period
+func (e *Engine) expired(c chain, now time.Time) bool {
+	for _, t := range c.transactions {
+		period := e.period(t)
+		if period <= 0 || !t.CreatedAt.Before(now.Add(-period)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) period(t model.Transaction) time.Duration {
+	if period, ok := e.settings.MerchantRetention[t.MerchantId.String()]; ok {
+		return period
+	}
+	if period, ok := e.settings.StatusRetention[t.Status]; ok {
+		return period
+	}
+	return e.settings.Retention
+}
@@ -0,0 +1,264 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/archive"
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+	"github.com/ivaylo-todorov/payment-system/store/memory"
+)
+
+var ctx = context.Background()
+
+type fixture struct {
+	t     *testing.T
+	store store.Store
+	now   time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{t: t, now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	s, err := memory.NewMemory(func() time.Time { return f.now })
+	require.NoError(t, err)
+	f.store = s
+
+	return f
+}
+
+func (f *fixture) clock() time.Time {
+	return f.now
+}
+
+func (f *fixture) merchant(email string) uuid.UUID {
+	m, err := f.store.CreateMerchant(ctx, model.Merchant{
+		Name:   "name",
+		Email:  email,
+		Status: model.MerchantStatusActive,
+	})
+	require.NoError(f.t, err)
+	return m.Id
+}
+
+func (f *fixture) transaction(merchantId, parentId uuid.UUID, transactionType, status string) uuid.UUID {
+	t, err := f.store.CreateTransaction(ctx, model.Transaction{
+		MerchantId:    merchantId,
+		ParentId:      parentId,
+		Type:          transactionType,
+		Amount:        100,
+		Status:        status,
+		CustomerEmail: "customer@example.com",
+	})
+	require.NoError(f.t, err)
+	return t.Id
+}
+
+func (f *fixture) exists(id uuid.UUID) bool {
+	_, err := f.store.GetTransaction(ctx, id)
+	return err == nil
+}
+
+func TestRunPurgesSettledChains(t *testing.T) {
+	f := newFixture(t)
+	m := f.merchant("merchant@example.com")
+
+	charged := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusApproved)
+	charge := f.transaction(m, charged, model.TransactionTypeCharge, model.TransactionStatusApproved)
+	refund := f.transaction(m, charge, model.TransactionTypeRefund, model.TransactionStatusApproved)
+
+	reversed := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusApproved)
+	f.transaction(m, reversed, model.TransactionTypeReversal, model.TransactionStatusApproved)
+
+	unsettled := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusApproved)
+
+	f.now = f.now.Add(2 * time.Hour)
+
+	e := NewEngine(model.CleanupSettings{Retention: time.Hour}, f.store, nil, f.clock)
+
+	_, ok := e.LastRun()
+	assert.False(t, ok)
+
+	report, err := e.Run(ctx, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, report.Transactions)
+	assert.Equal(t, 3, report.Chains)
+	assert.Equal(t, 2, report.PurgedChains)
+	assert.Equal(t, 5, report.PurgedTransactions)
+	assert.Equal(t, map[string]int{KeptUnsettled: 1}, report.Kept)
+	assert.Equal(t, 1, report.PurgedByStatus[model.TransactionStatusRefunded])
+	assert.Equal(t, 1, report.PurgedByStatus[model.TransactionStatusReversed])
+	assert.Equal(t, 3, report.PurgedByStatus[model.TransactionStatusApproved])
+	assert.Empty(t, report.Purged)
+
+	assert.False(t, f.exists(charged))
+	assert.False(t, f.exists(refund))
+	assert.False(t, f.exists(reversed))
+	assert.True(t, f.exists(unsettled))
+
+	last, ok := e.LastRun()
+	require.True(t, ok)
+	assert.Equal(t, report.PurgedChains, last.PurgedChains)
+}
+
+func TestRunKeepsChainsWithRecentTransactions(t *testing.T) {
+	f := newFixture(t)
+	m := f.merchant("merchant@example.com")
+
+	authorize := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusApproved)
+	f.transaction(m, authorize, model.TransactionTypeCharge, model.TransactionStatusApproved)
+
+	f.now = f.now.Add(2 * time.Hour)
+	f.transaction(m, authorize, model.TransactionTypeCharge, model.TransactionStatusError)
+
+	e := NewEngine(model.CleanupSettings{Retention: time.Hour}, f.store, nil, f.clock)
+
+	report, err := e.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.PurgedChains)
+	assert.Equal(t, map[string]int{KeptRetained: 1}, report.Kept)
+	assert.True(t, f.exists(authorize))
+}
+
+func TestRunReadsOlderTransactionsInPages(t *testing.T) {
+	f := newFixture(t)
+	m := f.merchant("merchant@example.com")
+
+	for i := 0; i < readPageSize+10; i++ {
+		f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusError)
+	}
+
+	f.now = f.now.Add(2 * time.Hour)
+	recent := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusError)
+
+	e := NewEngine(model.CleanupSettings{Retention: time.Hour}, f.store, nil, f.clock)
+
+	report, err := e.Run(ctx, false)
+	require.NoError(t, err)
+
+	// the recent transaction is not read
+	assert.Equal(t, readPageSize+10, report.Transactions)
+	assert.Equal(t, readPageSize+10, report.PurgedChains)
+	assert.True(t, f.exists(recent))
+
+	// without a retention period nothing is read
+	e = NewEngine(model.CleanupSettings{}, f.store, nil, f.clock)
+
+	report, err = e.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Transactions)
+	assert.True(t, f.exists(recent))
+}
+
+func TestRunOverrides(t *testing.T) {
+	f := newFixture(t)
+	kept := f.merchant("kept@example.com")
+	purged := f.merchant("purged@example.com")
+
+	keptErrored := f.transaction(kept, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusError)
+	purgedErrored := f.transaction(purged, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusError)
+
+	f.now = f.now.Add(2 * time.Hour)
+
+	settings := model.CleanupSettings{
+		// errored transactions are kept forever, except for one merchant
+		StatusRetention:   map[string]time.Duration{model.TransactionStatusError: 0},
+		MerchantRetention: map[string]time.Duration{purged.String(): time.Hour},
+	}
+	e := NewEngine(settings, f.store, nil, f.clock)
+
+	report, err := e.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.PurgedChains)
+	assert.True(t, f.exists(keptErrored))
+	assert.False(t, f.exists(purgedErrored))
+}
+
+func TestDryRun(t *testing.T) {
+	f := newFixture(t)
+	m := f.merchant("merchant@example.com")
+
+	authorize := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusError)
+
+	f.now = f.now.Add(2 * time.Hour)
+
+	e := NewEngine(model.CleanupSettings{Retention: time.Hour}, f.store, nil, f.clock)
+
+	report, err := e.DryRun(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.PurgedChains)
+	assert.Equal(t, []uuid.UUID{authorize}, report.Purged)
+	assert.True(t, f.exists(authorize))
+
+	// dry runs requested on demand are not the last run
+	_, ok := e.LastRun()
+	assert.False(t, ok)
+
+	report, err = e.Run(ctx, true)
+	require.NoError(t, err)
+	assert.True(t, f.exists(authorize))
+
+	last, ok := e.LastRun()
+	require.True(t, ok)
+	assert.True(t, last.DryRun)
+}
+
+func TestBuildChainsIncomplete(t *testing.T) {
+	authorize := model.Transaction{Id: uuid.New(), Type: model.TransactionTypeAuthorize}
+	charge := model.Transaction{Id: uuid.New(), ParentId: authorize.Id, Type: model.TransactionTypeCharge}
+	orphan := model.Transaction{Id: uuid.New(), ParentId: uuid.New(), Type: model.TransactionTypeRefund}
+
+	chains := buildChains([]model.Transaction{charge, orphan, authorize})
+	require.Len(t, chains, 2)
+
+	assert.False(t, chains[0].complete)
+	assert.Equal(t, []model.Transaction{orphan}, chains[0].transactions)
+
+	assert.True(t, chains[1].complete)
+	assert.Equal(t, []model.Transaction{authorize, charge}, chains[1].transactions)
+}
+
+func TestRunArchivesBeforePurge(t *testing.T) {
+	f := newFixture(t)
+	m := f.merchant("merchant@example.com")
+
+	authorize := f.transaction(m, uuid.Nil, model.TransactionTypeAuthorize, model.TransactionStatusApproved)
+	reversal := f.transaction(m, authorize, model.TransactionTypeReversal, model.TransactionStatusApproved)
+
+	f.now = f.now.Add(2 * time.Hour)
+
+	dir := t.TempDir()
+	e := NewEngine(model.CleanupSettings{Retention: time.Hour, ArchiveDir: dir}, f.store, archive.New(dir, nil, f.clock), f.clock)
+
+	report, err := e.Run(ctx, false)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.Archive)
+	assert.False(t, f.exists(authorize))
+
+	manifest, restored, err := archive.New(dir, nil, nil).Restore(ctx, report.Archive, f.store)
+	require.NoError(t, err)
+	assert.Equal(t, 2, manifest.Transactions)
+	assert.Equal(t, 2, restored)
+	assert.True(t, f.exists(authorize))
+	assert.True(t, f.exists(reversal))
+
+	// nothing is purged when the archive cannot be written
+	blocked := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(blocked, nil, 0o640))
+
+	e = NewEngine(model.CleanupSettings{Retention: time.Hour, ArchiveDir: blocked}, f.store, archive.New(blocked, nil, f.clock), f.clock)
+
+	_, err = e.Run(ctx, false)
+	assert.Error(t, err)
+	assert.True(t, f.exists(authorize))
+}
@@ -3,12 +3,19 @@ package server
 import (
 	"bytes"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/reencryption"
+	"github.com/ivaylo-todorov/payment-system/retention"
 )
 
 const (
@@ -26,14 +33,23 @@ func ConvertAdminFromModel(a model.Admin) Admin {
 }
 
 func ConvertMerchantFromModel(m model.Merchant) Merchant {
-	return Merchant{
+	merchant := Merchant{
 		Id:                 m.Id.String(),
 		Name:               m.Name,
 		Description:        m.Description,
 		Email:              m.Email,
 		Status:             m.Status,
+		StatusReason:       m.StatusReason,
 		TransactionsAmount: m.TransactionsAmount,
+		Version:            m.Version,
 	}
+
+	if !m.StatusChangedAt.IsZero() {
+		changedAt := m.StatusChangedAt
+		merchant.StatusChangedAt = &changedAt
+	}
+
+	return merchant
 }
 
 func ConvertMerchantToModel(m Merchant) (model.Merchant, error) {
@@ -48,11 +64,12 @@ func ConvertMerchantToModel(m Merchant) (model.Merchant, error) {
 	}
 
 	return model.Merchant{
-		Id:          id,
-		Name:        m.Name,
-		Description: m.Description,
-		Email:       m.Email,
-		Status:      m.Status,
+		Id:           id,
+		Name:         m.Name,
+		Description:  m.Description,
+		Email:        m.Email,
+		Status:       m.Status,
+		StatusReason: m.StatusReason,
 	}, nil
 }
 
@@ -69,6 +86,125 @@ func ConvertTransactionFromModel(t model.Transaction) Transaction {
 	}
 }
 
+func ConvertRetentionReport(r retention.Report) RetentionReport {
+	report := RetentionReport{
+		StartedAt:          r.StartedAt,
+		FinishedAt:         r.FinishedAt,
+		DryRun:             r.DryRun,
+		Transactions:       r.Transactions,
+		Chains:             r.Chains,
+		PurgedChains:       r.PurgedChains,
+		PurgedTransactions: r.PurgedTransactions,
+		PurgedByStatus:     r.PurgedByStatus,
+		Kept:               r.Kept,
+		Archive:            r.Archive,
+	}
+
+	for _, id := range r.Purged {
+		report.Purged = append(report.Purged, id.String())
+	}
+
+	if r.Err != nil {
+		report.Error = r.Err.Error()
+	}
+
+	return report
+}
+
+func ConvertReencryptionProgress(p reencryption.Progress) ReencryptionProgress {
+	progress := ReencryptionProgress{
+		StartedAt:   p.StartedAt,
+		Running:     p.Running,
+		KeyVersion:  p.KeyVersion,
+		Total:       p.Total,
+		Reencrypted: p.Reencrypted,
+		Remaining:   p.Remaining(),
+	}
+
+	if !p.FinishedAt.IsZero() {
+		finishedAt := p.FinishedAt
+		progress.FinishedAt = &finishedAt
+	}
+
+	if p.Err != nil {
+		progress.Error = p.Err.Error()
+	}
+
+	return progress
+}
+
+func ConvertErasureFromModel(e model.Erasure) Erasure {
+	return Erasure{
+		Id:           e.Id.String(),
+		Subject:      e.Subject,
+		Transactions: e.Transactions,
+		CreatedAt:    e.CreatedAt,
+		UpdatedAt:    e.UpdatedAt,
+	}
+}
+
+func ConvertAuditEntryFromModel(e model.AuditEntry) AuditEntry {
+	changes := []AuditChange{}
+	for _, c := range e.Changes {
+		changes = append(changes, AuditChange{
+			Field:  c.Field,
+			Before: c.Before,
+			After:  c.After,
+		})
+	}
+
+	return AuditEntry{
+		Sequence:      e.Sequence,
+		CreatedAt:     e.CreatedAt,
+		Actor:         e.Actor,
+		Authenticated: e.Authenticated,
+		RequestId:     e.RequestId,
+		Action:        e.Action,
+		TargetType:    e.TargetType,
+		TargetId:      e.TargetId.String(),
+		Changes:       changes,
+		PrevHash:      e.PrevHash,
+		Hash:          e.Hash,
+	}
+}
+
+// ConvertAuditQueryToModel reads the audit filters of a query string,
+// times are RFC 3339
+func ConvertAuditQueryToModel(values url.Values) (model.AuditQuery, error) {
+	query := model.AuditQuery{
+		Actor:  values.Get("actor"),
+		Action: values.Get("action"),
+	}
+
+	if v := values.Get("target_uuid"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return query, model.NewValidationError("target_uuid", "invalid_uuid", "invalid target_uuid: %v", err)
+		}
+		query.TargetId = id
+	}
+
+	for _, f := range []struct {
+		name  string
+		value **time.Time
+	}{
+		{"since", &query.Since},
+		{"until", &query.Until},
+	} {
+		v := values.Get(f.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, model.NewValidationError(f.name, "invalid_time", "invalid %s, expected an RFC 3339 time: %v", f.name, err)
+		}
+		*f.value = &t
+	}
+
+	return query, nil
+}
+
 func ConvertTransactionToModel(t Transaction) (model.Transaction, error) {
 
 	var err error
@@ -109,17 +245,25 @@ func ConvertTransactionToModel(t Transaction) (model.Transaction, error) {
 }
 
 func ConvertCsvToAdmins(data []byte) ([]model.Admin, error) {
-	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	_, records, err := readCsvRecords(data, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	admins := []model.Admin{}
 
-	for _, r := range records {
+	errs := model.ValidationErrors{}
+
+	for n, r := range records {
 
 		if len(r) != AdminRecordSize {
-			return nil, fmt.Errorf("invalid admin csv data")
+			errs = append(errs, &model.Error{
+				Kind:    model.ErrorKindValidation,
+				Code:    "invalid_record",
+				Row:     n + 1,
+				Message: fmt.Sprintf("invalid admin csv data, expected %d fields, got %d", AdminRecordSize, len(r)),
+			})
+			continue
 		}
 
 		admins = append(admins, model.Admin{
@@ -129,30 +273,208 @@ func ConvertCsvToAdmins(data []byte) ([]model.Admin, error) {
 		})
 	}
 
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
 	return admins, nil
 }
 
+// merchantCsvColumns is the column order of headerless merchant csv data
+var merchantCsvColumns = []string{"name", "description", "email", "status"}
+
+// ConvertCsvToMerchants reads merchant csv data. If the first record
+// consists of known column names it is used as a header, otherwise the
+// data is expected in merchantCsvColumns order.
 func ConvertCsvToMerchants(data []byte) ([]model.Merchant, error) {
-	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	header, records, err := readCsvRecords(data, merchantCsvColumns)
 	if err != nil {
 		return nil, err
 	}
 
+	columns := map[string]int{}
+	for n, c := range merchantCsvColumns {
+		columns[c] = n
+	}
+	recordSize := MerchantRecordSize
+
+	if header != nil {
+		columns = header
+		recordSize = len(header)
+	}
+
+	field := func(r []string, name string) string {
+		if n, ok := columns[name]; ok {
+			return strings.TrimSpace(r[n])
+		}
+		return ""
+	}
+
 	merchants := []model.Merchant{}
 
-	for _, r := range records {
+	errs := model.ValidationErrors{}
 
-		if len(r) != MerchantRecordSize {
-			return nil, fmt.Errorf("invalid merchant csv data")
+	for n, r := range records {
+
+		if len(r) != recordSize {
+			errs = append(errs, &model.Error{
+				Kind:    model.ErrorKindValidation,
+				Code:    "invalid_record",
+				Row:     n + 1,
+				Message: fmt.Sprintf("invalid merchant csv data, expected %d fields, got %d", recordSize, len(r)),
+			})
+			continue
 		}
 
 		merchants = append(merchants, model.Merchant{
-			Name:        strings.TrimSpace(r[0]),
-			Description: strings.TrimSpace(r[1]),
-			Email:       strings.TrimSpace(r[2]),
-			Status:      strings.TrimSpace(r[3]),
+			Name:        field(r, "name"),
+			Description: field(r, "description"),
+			Email:       field(r, "email"),
+			Status:      field(r, "status"),
 		})
 	}
 
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return merchants, nil
+}
+
+// parseCsvHeader maps column names to their position if every field
+// of the record is one of the known columns
+func parseCsvHeader(record []string, known []string) (map[string]int, bool) {
+	columns := map[string]int{}
+	for n, f := range record {
+		name := strings.ToLower(strings.TrimSpace(f))
+		found := false
+		for _, k := range known {
+			if k == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		if _, ok := columns[name]; ok {
+			return nil, false
+		}
+		columns[name] = n
+	}
+	return columns, true
+}
+
+func ConvertJsonToMerchants(data []byte) ([]model.Merchant, error) {
+	var request MerchantsRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, model.NewValidationError("", "invalid_json", "could not decode request payload: %v", err)
+	}
+
+	merchants := []model.Merchant{}
+
+	errs := model.ValidationErrors{}
+
+	for n, m := range request.Merchants {
+		merchant, err := ConvertMerchantToModel(m)
+		if err != nil {
+			errs.Append(model.NewValidationError("uuid", "invalid_uuid", "%s", err.Error()), n+1)
+			continue
+		}
+		merchants = append(merchants, merchant)
+	}
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
 	return merchants, nil
 }
+
+// ConvertMergePatchToMerchantPatch reads a JSON Merge Patch document,
+// null members clear the corresponding merchant field
+func ConvertMergePatchToMerchantPatch(id uuid.UUID, data []byte) (model.MerchantPatch, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return model.MerchantPatch{}, model.NewValidationError("", "invalid_json", "could not decode merge patch: %v", err)
+	}
+
+	patch := model.MerchantPatch{
+		Id: id,
+	}
+
+	fields := map[string]**string{
+		"name":          &patch.Name,
+		"description":   &patch.Description,
+		"email":         &patch.Email,
+		"status":        &patch.Status,
+		"status_reason": &patch.StatusReason,
+	}
+
+	errs := model.ValidationErrors{}
+
+	for name, raw := range members {
+		field, ok := fields[name]
+		if !ok {
+			if name == "uuid" || name == "total_transaction_sum" || name == "version" || name == "status_changed_at" {
+				errs.Add(name, "read_only", "%s cannot be changed", name)
+			} else {
+				errs.Add(name, "unknown_field", "unknown merchant field %s", name)
+			}
+			continue
+		}
+
+		value := ""
+		if string(raw) != "null" {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				errs.Add(name, "invalid_type", "%s must be a string or null", name)
+				continue
+			}
+		}
+		*field = &value
+	}
+
+	return patch, errs.Err()
+}
+
+// readCsvRecords reads records of any length, the callers check the
+// record size so that every malformed row is reported. With known columns
+// a first record naming only known columns is returned as the header.
+// Errors number the rows like the callers do, the data records from 1
+// without the header.
+func readCsvRecords(data []byte, known []string) (map[string]int, [][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	var header map[string]int
+	records := [][]string{}
+
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, nil, model.ValidationErrors{{
+					Kind:    model.ErrorKindValidation,
+					Code:    "invalid_csv",
+					Row:     len(records) + 1,
+					Message: parseErr.Err.Error(),
+				}}
+			}
+			return nil, nil, err
+		}
+
+		if first && known != nil {
+			if columns, ok := parseCsvHeader(record, known); ok {
+				header = columns
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+
+	return header, records, nil
+}
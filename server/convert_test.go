@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func TestConvertCsvToMerchantsHeaderless(t *testing.T) {
+	merchants, err := ConvertCsvToMerchants([]byte("name, description, name@email.com, active\n"))
+	require.NoError(t, err)
+	require.Len(t, merchants, 1)
+
+	assert.Equal(t, model.Merchant{
+		Name:        "name",
+		Description: "description",
+		Email:       "name@email.com",
+		Status:      "active",
+	}, merchants[0])
+}
+
+func TestConvertCsvToMerchantsWithHeader(t *testing.T) {
+	data := "Email,Status,Name\none@email.com,active,one\ntwo@email.com,inactive,two\n"
+
+	merchants, err := ConvertCsvToMerchants([]byte(data))
+	require.NoError(t, err)
+	require.Len(t, merchants, 2)
+
+	assert.Equal(t, model.Merchant{
+		Name:   "two",
+		Email:  "two@email.com",
+		Status: "inactive",
+	}, merchants[1])
+}
+
+func TestConvertCsvToMerchantsWithHeaderInvalidRow(t *testing.T) {
+	data := "name,email,status\none,one@email.com\n"
+
+	_, err := ConvertCsvToMerchants([]byte(data))
+
+	var errs model.ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	assert.Equal(t, 1, errs[0].Row)
+}
+
+// parse and record errors number the same row alike, by data row without
+// the header
+func TestConvertCsvRowNumbers(t *testing.T) {
+	firstError := func(err error) *model.Error {
+		var errs model.ValidationErrors
+		require.True(t, errors.As(err, &errs), err)
+		require.Len(t, errs, 1)
+		return errs[0]
+	}
+
+	tests := []struct {
+		data string
+		code string
+	}{
+		{"name,email,status\none,one@email.com,active\ntwo,two@email.com\n", "invalid_record"},
+		{"name,email,status\none,one@email.com,active\ntwo,\"two@email.com,active\n", "invalid_csv"},
+		{"one, , one@email.com, active\ntwo, , two@email.com\n", "invalid_record"},
+		{"one, , one@email.com, active\ntwo, ,\"two@email.com, active\n", "invalid_csv"},
+	}
+
+	for _, test := range tests {
+		_, err := ConvertCsvToMerchants([]byte(test.data))
+		e := firstError(err)
+		assert.Equal(t, test.code, e.Code, test.data)
+		assert.Equal(t, 2, e.Row, test.data)
+	}
+
+	_, err := ConvertCsvToAdmins([]byte("one, , one@email.com\ntwo, \"two@email.com\n"))
+	assert.Equal(t, 2, firstError(err).Row)
+}
+
+func TestConvertJsonToMerchants(t *testing.T) {
+	data := `{"merchants": [
+		{"name": "one", "email": "one@email.com", "status": "active"},
+		{"uuid": "not a uuid", "name": "two"}
+	]}`
+
+	_, err := ConvertJsonToMerchants([]byte(data))
+
+	var errs model.ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	assert.Equal(t, 2, errs[0].Row)
+	assert.Equal(t, "uuid", errs[0].Field)
+
+	merchants, err := ConvertJsonToMerchants([]byte(`{"merchants": [{"name": "one", "email": "one@email.com", "status": "active"}]}`))
+	require.NoError(t, err)
+	require.Len(t, merchants, 1)
+	assert.Equal(t, "one", merchants[0].Name)
+}
+
+func TestConvertMergePatchToMerchantPatch(t *testing.T) {
+	id := uuid.New()
+
+	patch, err := ConvertMergePatchToMerchantPatch(id, []byte(`{"name": "new name", "description": null}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, id, patch.Id)
+	require.NotNil(t, patch.Name)
+	assert.Equal(t, "new name", *patch.Name)
+	require.NotNil(t, patch.Description)
+	assert.Equal(t, "", *patch.Description)
+	assert.Nil(t, patch.Email)
+	assert.Nil(t, patch.Status)
+
+	_, err = ConvertMergePatchToMerchantPatch(id, []byte(`{"uuid": "x", "status": 1}`))
+
+	var errs model.ValidationErrors
+	require.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+}
+
+func TestConvertAuditQueryToModel(t *testing.T) {
+	id := uuid.New()
+
+	query, err := ConvertAuditQueryToModel(url.Values{
+		"actor":       {"ops"},
+		"action":      {model.AuditActionMerchantUpdate},
+		"target_uuid": {id.String()},
+		"since":       {"2024-01-02T03:04:05Z"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ops", query.Actor)
+	assert.Equal(t, model.AuditActionMerchantUpdate, query.Action)
+	assert.Equal(t, id, query.TargetId)
+	require.NotNil(t, query.Since)
+	assert.True(t, query.Since.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	assert.Nil(t, query.Until)
+
+	for _, values := range []url.Values{
+		{"target_uuid": {"merchant"}},
+		{"until": {"yesterday"}},
+	} {
+		_, err := ConvertAuditQueryToModel(values)
+		assert.Equal(t, model.ErrorKindValidation, model.KindOf(err), values)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// Merchant entity tags are the quoted merchant version
+
+func merchantETag(m model.Merchant) string {
+	return fmt.Sprintf(`"%d"`, m.Version)
+}
+
+// ifMatchVersion reads the merchant version from the If-Match header.
+// A "*" matches any version and is returned as 0. The returned bool is
+// false if there is no If-Match header.
+func ifMatchVersion(r *http.Request) (int64, bool, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, false, nil
+	}
+	if header == "*" {
+		return 0, true, nil
+	}
+
+	// weak tags never match If-Match, see RFC 7232 section 3.1
+	if strings.HasPrefix(header, "W/") {
+		return 0, true, model.ErrMerchantVersionMismatch
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, true, model.NewValidationError("If-Match", "invalid_etag", "invalid entity tag %s", header)
+	}
+	return version, true, nil
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches etag
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if header == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		// If-None-Match uses the weak comparison
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPatch, "/v1/merchants/id", nil)
+
+	_, present, err := ifMatchVersion(r)
+	require.NoError(t, err)
+	assert.False(t, present)
+
+	r.Header.Set("If-Match", `"7"`)
+	version, present, err := ifMatchVersion(r)
+	require.NoError(t, err)
+	assert.True(t, present)
+	assert.Equal(t, int64(7), version)
+
+	r.Header.Set("If-Match", "*")
+	version, present, err = ifMatchVersion(r)
+	require.NoError(t, err)
+	assert.True(t, present)
+	assert.Zero(t, version)
+
+	r.Header.Set("If-Match", `W/"7"`)
+	_, _, err = ifMatchVersion(r)
+	assert.ErrorIs(t, err, model.ErrMerchantVersionMismatch)
+
+	r.Header.Set("If-Match", `"abc"`)
+	_, _, err = ifMatchVersion(r)
+	assert.Equal(t, model.ErrorKindValidation, model.KindOf(err))
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	etag := merchantETag(model.Merchant{Version: 3})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/merchants/id", nil)
+	assert.False(t, ifNoneMatch(r, etag))
+
+	r.Header.Set("If-None-Match", `"2", W/"3"`)
+	assert.True(t, ifNoneMatch(r, etag))
+
+	r.Header.Set("If-None-Match", `"2"`)
+	assert.False(t, ifNoneMatch(r, etag))
+}
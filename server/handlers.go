@@ -1,68 +1,59 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
+	"mime"
 	"net/http"
-	"text/template"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/ivaylo-todorov/payment-system/model"
 )
 
-func (s *server) root(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got GET / request\n")
+func (s *Server) root(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("A Payment System!"))
 }
 
-func (s *server) createAdmins(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got POST /admins request\n")
+func (s *Server) createAdmins(w http.ResponseWriter, r *http.Request) {
+	if t := mediaType(r); t != "text/csv" && t != "text/plain" {
+		writeStatusProblem(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			"admins are created from text/csv")
+		return
+	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("can't read body: %v", err), http.StatusBadRequest)
+	body, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
 	admins, err := ConvertCsvToAdmins(body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("invalid data: %v", err), http.StatusBadRequest)
+		writeProblem(w, r, err)
 		return
 	}
 
-	response := &AdminResponse{}
-
-	res, err := s.Controller.CreateAdmins(admins)
+	res, err := s.Controller.CreateAdmins(r.Context(), requestActor(r), admins)
 	if err != nil {
-		response.Error = err.Error()
+		writeProblem(w, r, err)
+		return
 	}
 
+	response := &AdminResponse{}
+
 	for _, a := range res {
 		response.Admins = append(response.Admins, ConvertAdminFromModel(a))
 	}
 
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not encode response payload: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write(jsonResp)
+	writeJSON(w, http.StatusCreated, response)
 }
 
-func (s *server) getMerchants(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got GET /merchants request\n")
-
+func (s *Server) getMerchants(w http.ResponseWriter, r *http.Request) {
 	query := model.MerchantQuery{}
 
-	merchants, err := s.Controller.GetMerchants(query)
+	merchants, err := s.Controller.GetMerchants(r.Context(), query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not get merchants: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, err)
 		return
 	}
 
@@ -73,76 +64,113 @@ func (s *server) getMerchants(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, ok := r.URL.Query()["render"]; ok {
-		tmpl, err := template.ParseFiles(`.\server\merchants.html`)
+		writePage(w, r, "merchants.html", response)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// createMerchants imports a batch of merchants given as json or csv.
+// With atomic=true nothing is created if any merchant fails, otherwise
+// every valid merchant is created and the outcome is reported per row.
+func (s *Server) createMerchants(w http.ResponseWriter, r *http.Request) {
+	atomic := false
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		var err error
+		atomic, err = strconv.ParseBool(v)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("could not parse merchants template: %v", err), http.StatusInternalServerError)
+			writeBadRequest(w, r, "invalid_query", fmt.Sprintf("invalid atomic value: %v", err))
 			return
 		}
-
-		tmpl.Execute(w, response)
-		return
 	}
 
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not encode response payload: %v", err), http.StatusInternalServerError)
+	body, ok := readBody(w, r)
+	if !ok {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write(jsonResp)
-}
-
-func (s *server) createMerchants(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got POST /merchants request\n")
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("can't read body: %v", err), http.StatusBadRequest)
+	var merchants []model.Merchant
+	var err error
+
+	switch mediaType(r) {
+	case ContentTypeJSON:
+		merchants, err = ConvertJsonToMerchants(body)
+	case "text/csv", "text/plain":
+		merchants, err = ConvertCsvToMerchants(body)
+	default:
+		writeStatusProblem(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			"merchants can be imported as application/json or text/csv")
 		return
 	}
-
-	merchants, err := ConvertCsvToMerchants(body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("invalid data: %v", err), http.StatusBadRequest)
+		writeProblem(w, r, err)
 		return
 	}
 
 	response := &MerchantResponse{}
 
-	res, err := s.Controller.CreateMerchants(merchants)
-	if err != nil {
-		response.Error = err.Error()
+	if atomic {
+		res, err := s.Controller.CreateMerchants(r.Context(), requestActor(r), merchants)
+		if err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+
+		for n, m := range res {
+			response.Merchants = append(response.Merchants, ConvertMerchantFromModel(m))
+			response.Results = append(response.Results, MerchantImportResult{
+				Row: n + 1,
+				Id:  m.Id.String(),
+			})
+		}
+
+		writeJSON(w, http.StatusCreated, response)
+		return
 	}
 
-	for _, m := range res {
-		response.Merchants = append(response.Merchants, ConvertMerchantFromModel(m))
+	failed := false
+	for _, res := range s.Controller.ImportMerchants(r.Context(), requestActor(r), merchants) {
+		result := MerchantImportResult{
+			Row: res.Row,
+		}
+		if res.Err != nil {
+			failed = true
+			result.Errors = NewProblemErrors(res.Err)
+		} else {
+			result.Id = res.Merchant.Id.String()
+			response.Merchants = append(response.Merchants, ConvertMerchantFromModel(res.Merchant))
+		}
+		response.Results = append(response.Results, result)
 	}
 
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not encode response payload: %v", err), http.StatusInternalServerError)
+	if failed {
+		response.Error = "some merchants could not be created"
+		writeJSON(w, http.StatusMultiStatus, response)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write(jsonResp)
+	writeJSON(w, http.StatusCreated, response)
 }
 
-func (s *server) updateMerchant(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got POST /merchants/{id} request\n")
+// mediaType returns the request media type without parameters
+func mediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return ""
+	}
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return t
+}
 
+func (s *Server) updateMerchant(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var request MerchantRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not decode request payload: %v", err), http.StatusBadRequest)
+	if !decodeJSON(w, r, &request) {
 		return
 	}
 
@@ -152,13 +180,19 @@ func (s *server) updateMerchant(w http.ResponseWriter, r *http.Request) {
 
 	merchant, err := ConvertMerchantToModel(request.Merchant)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, "invalid_uuid", err.Error())
 		return
 	}
 
-	merchant, err = s.Controller.UpdateMerchant(merchant)
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+	merchant.Version = version
+
+	merchant, err = s.Controller.UpdateMerchant(r.Context(), requestActor(r), merchant)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not update merchant: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, err)
 		return
 	}
 
@@ -168,68 +202,59 @@ func (s *server) updateMerchant(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not encode response payload: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write(jsonResp)
+	w.Header().Set("ETag", merchantETag(merchant))
+	writeJSON(w, http.StatusOK, response)
 }
 
-func (s *server) deleteMerchants(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got DELETE /merchants request\n")
-
+func (s *Server) deleteMerchants(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var request MerchantRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not decode request payload: %v", err), http.StatusBadRequest)
+	if !decodeJSON(w, r, &request) {
 		return
 	}
 
 	merchant, err := ConvertMerchantToModel(request.Merchant)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, "invalid_uuid", err.Error())
+		return
+	}
+	linkSuccessor(w, http.MethodDelete, "/v1/merchants/"+merchant.Id.String())
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
+	merchant.Version = version
 
-	err = s.Controller.DeleteMerchant(merchant)
+	err = s.Controller.DeleteMerchant(r.Context(), requestActor(r), merchant)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not delete merchant: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *server) postTransaction(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("got POST /transactions request\n")
-
+func (s *Server) postTransaction(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var request TransactionRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not decode request payload: %v", err), http.StatusBadRequest)
+	if !decodeJSON(w, r, &request) {
 		return
 	}
 
 	transaction, err := ConvertTransactionToModel(request.Transaction)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, r, "invalid_uuid", err.Error())
 		return
 	}
 
-	transaction, err = s.Controller.StartTransaction(transaction)
+	start := time.Now()
+	transaction, err = s.Controller.StartTransaction(r.Context(), transaction)
+	s.Metrics.ObserveStartTransaction(time.Since(start))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not start transaction: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, err)
 		return
 	}
 
@@ -239,26 +264,15 @@ func (s *server) postTransaction(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not encode response payload: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write(jsonResp)
+	writeJSON(w, http.StatusOK, response)
 }
 
-func (s *server) getTransactions(w http.ResponseWriter, r *http.Request) {
-	log.Printf("got GET /transactions request\n")
-
+func (s *Server) getTransactions(w http.ResponseWriter, r *http.Request) {
 	query := model.TransactionQuery{}
 
-	transactions, err := s.Controller.GetTransactions(query)
+	transactions, err := s.Controller.GetTransactions(r.Context(), query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not get transactions: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, err)
 		return
 	}
 
@@ -269,24 +283,9 @@ func (s *server) getTransactions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, ok := r.URL.Query()["render"]; ok {
-		tmpl, err := template.ParseFiles(`.\server\transactions.html`)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("could not parse transactions template: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		tmpl.Execute(w, response)
+		writePage(w, r, "transactions.html", response)
 		return
 	}
 
-	jsonResp, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not encode response payload: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-
-	w.Write(jsonResp)
+	writeJSON(w, http.StatusOK, response)
 }
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// adminOnly serves fn to the requests bearing the admin token. Without a
+// configured token the route is disabled.
+func (s *Server) adminOnly(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.Settings.AdminToken
+		if token == "" {
+			writeStatusProblem(w, r, http.StatusNotFound, "admin_token_unset", "the route is disabled without an admin token")
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeStatusProblem(w, r, http.StatusUnauthorized, "unauthorized", "the admin token is missing or wrong")
+			return
+		}
+
+		fn(w, r.WithContext(context.WithValue(r.Context(), adminKey{}, true)))
+	}
+}
+
+// adminKey marks the context of a request adminOnly let through
+type adminKey struct{}
+
+// getRetentionRun returns the report of the last transactions cleanup
+func (s *Server) getRetentionRun(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.Retention.LastRun()
+	if !ok {
+		writeStatusProblem(w, r, http.StatusNotFound, "no_retention_run", "the transactions cleanup did not run yet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConvertRetentionReport(report))
+}
+
+// postRetentionDryRun reports what the transactions cleanup would purge now
+func (s *Server) postRetentionDryRun(w http.ResponseWriter, r *http.Request) {
+	report, err := s.Retention.DryRun(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConvertRetentionReport(report))
+}
+
+// postRestore restores the transactions of an archive written by the
+// transactions cleanup
+func (s *Server) postRestore(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if s.Archive == nil {
+		writeStatusProblem(w, r, http.StatusUnprocessableEntity, "archive_disabled", "archiving is disabled, cleanup.archive_dir is empty")
+		return
+	}
+
+	var request RestoreRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+
+	manifest, restored, err := s.Archive.Restore(r.Context(), request.Manifest, s.Controller)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RestoreResponse{
+		Manifest:     request.Manifest,
+		Transactions: manifest.Transactions,
+		Restored:     restored,
+	})
+}
+
+// getReencryption returns the progress of the current or last re-encryption
+// of personal data
+func (s *Server) getReencryption(w http.ResponseWriter, r *http.Request) {
+	if s.Reencryption == nil {
+		writeStatusProblem(w, r, http.StatusUnprocessableEntity, "encryption_disabled", "encryption is disabled, store.keyring_file is empty")
+		return
+	}
+
+	progress, ok := s.Reencryption.Progress()
+	if !ok {
+		writeStatusProblem(w, r, http.StatusNotFound, "no_reencryption_run", "the re-encryption did not run yet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConvertReencryptionProgress(progress))
+}
+
+// postReencryption starts a re-encryption of personal data with the current
+// key in the background
+func (s *Server) postReencryption(w http.ResponseWriter, r *http.Request) {
+	if s.Reencryption == nil {
+		writeStatusProblem(w, r, http.StatusUnprocessableEntity, "encryption_disabled", "encryption is disabled, store.keyring_file is empty")
+		return
+	}
+
+	progress, err := s.Reencryption.Start()
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, ConvertReencryptionProgress(progress))
+}
+
+// postErasure pseudonymizes the transactions of a customer in the store and
+// then in the archives, repeating it only erases the transactions created
+// since and what a failed request left
+func (s *Server) postErasure(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request ErasureRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+
+	erasure, erased, err := s.Controller.EraseCustomer(r.Context(), requestActor(r), request.CustomerEmail)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	archived := 0
+	if s.Archive != nil {
+		archived, err = s.Archive.Erase(model.NewPseudonymizer(s.settings.PrivacySettings.ErasureKey), request.CustomerEmail)
+		if err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ErasureResponse{
+		Erasure:  ConvertErasureFromModel(erasure),
+		Erased:   erased,
+		Archived: archived,
+	})
+}
+
+// getErasures returns the audit records of all erasures
+func (s *Server) getErasures(w http.ResponseWriter, r *http.Request) {
+	erasures, err := s.Controller.GetErasures(r.Context())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	response := ErasuresResponse{
+		Erasures: []Erasure{},
+	}
+	for _, e := range erasures {
+		response.Erasures = append(response.Erasures, ConvertErasureFromModel(e))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getAudit returns the audit log entries matching the query filters in
+// sequence order
+func (s *Server) getAudit(w http.ResponseWriter, r *http.Request) {
+	query, err := ConvertAuditQueryToModel(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	entries, err := s.Controller.GetAuditEntries(r.Context(), query)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	response := AuditResponse{
+		Entries: []AuditEntry{},
+	}
+	for _, e := range entries {
+		response.Entries = append(response.Entries, ConvertAuditEntryFromModel(e))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getLogLevel returns the current log level
+func (s *Server) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LogLevel{Level: logging.LevelName(logging.Level())})
+}
+
+// putLogLevel changes the log level until the server restarts
+func (s *Server) putLogLevel(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var request LogLevel
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+
+	level, err := logging.ParseLevel(request.Level)
+	if err != nil || request.Level == "" {
+		writeBadRequest(w, r, "invalid_log_level", "level must be debug, info, warn or error")
+		return
+	}
+
+	logging.SetLevel(level)
+	logging.FromContext(r.Context()).Warn("log level changed", "level", logging.LevelName(level))
+
+	writeJSON(w, http.StatusOK, LogLevel{Level: logging.LevelName(level)})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const ContentTypeMergePatch = "application/merge-patch+json"
+
+func (s *Server) getMerchantV1(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUuid(w, r)
+	if !ok {
+		return
+	}
+
+	merchant, err := s.Controller.GetMerchant(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	etag := merchantETag(merchant)
+	w.Header().Set("ETag", etag)
+
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConvertMerchantFromModel(merchant))
+}
+
+// patchMerchantV1 applies a JSON Merge Patch (RFC 7396) to a merchant,
+// the If-Match header must hold the merchant ETag
+func (s *Server) patchMerchantV1(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUuid(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if t := mediaType(r); t != ContentTypeMergePatch && t != ContentTypeJSON {
+		writeStatusProblem(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Sprintf("merchants are patched with %s", ContentTypeMergePatch))
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	patch, err := ConvertMergePatchToMerchantPatch(id, body)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	patch.Version = version
+
+	merchant, err := s.Controller.PatchMerchant(r.Context(), requestActor(r), patch)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", merchantETag(merchant))
+	writeJSON(w, http.StatusOK, ConvertMerchantFromModel(merchant))
+}
+
+// deleteMerchantV1 deletes a merchant, the If-Match header must hold
+// the merchant ETag
+func (s *Server) deleteMerchantV1(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUuid(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	merchant, err := s.Controller.GetMerchant(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	merchant.Version = version
+
+	if err := s.Controller.DeleteMerchant(r.Context(), requestActor(r), merchant); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTransactionV1(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUuid(w, r)
+	if !ok {
+		return
+	}
+
+	transaction, err := s.Controller.GetTransaction(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConvertTransactionFromModel(transaction))
+}
+
+// pathUuid parses the {id} path variable, it writes a bad request
+// response if the id is invalid
+func pathUuid(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeBadRequest(w, r, "invalid_uuid", err.Error())
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// requireIfMatch reads the merchant version from the If-Match header,
+// it writes an error response if the header is missing or invalid
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	version, present, err := ifMatchVersion(r)
+	if err != nil {
+		writeProblem(w, r, err)
+		return 0, false
+	}
+	if !present {
+		writeStatusProblem(w, r, http.StatusPreconditionRequired, "if_match_required",
+			"the If-Match header is required to change a merchant")
+		return 0, false
+	}
+	return version, true
+}
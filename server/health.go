@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/ivaylo-todorov/payment-system/config"
+)
+
+// readinessTimeout bounds the checks of a readiness probe
+const readinessTimeout = 2 * time.Second
+
+// schemaStore is a store with a migrated schema
+type schemaStore interface {
+	SchemaVersions(context.Context) (int, int, error)
+}
+
+// fileStore is a store kept in a single database file
+type fileStore interface {
+	Path() string
+	TableRows(context.Context) (map[string]int64, error)
+}
+
+// getHealth tells the process is alive, it does not look at its
+// dependencies
+func (s *Server) getHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Health{Status: CheckStatusOk})
+}
+
+// getReadiness tells whether the server can take traffic: the database
+// answers, its schema is migrated to the latest version and the
+// transactions cleanup keeps running
+func (s *Server) getReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	readiness := Readiness{
+		Ready: true,
+		Checks: []ReadinessCheck{
+			s.checkDatabase(ctx),
+			s.checkMigrations(ctx),
+			s.checkCleanup(),
+		},
+	}
+
+	status := http.StatusOK
+	for _, check := range readiness.Checks {
+		if check.Status == CheckStatusFailed {
+			readiness.Ready = false
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, readiness)
+}
+
+func (s *Server) checkDatabase(ctx context.Context) ReadinessCheck {
+	check := ReadinessCheck{Name: "database", Status: CheckStatusOk}
+
+	store, ok := s.Store.(sqlStore)
+	if !ok {
+		check.Status = CheckStatusSkipped
+		return check
+	}
+
+	db, err := store.SqlDb()
+	if err == nil {
+		err = db.PingContext(ctx)
+	}
+	if err != nil {
+		check.Status = CheckStatusFailed
+		check.Detail = err.Error()
+	}
+	return check
+}
+
+func (s *Server) checkMigrations(ctx context.Context) ReadinessCheck {
+	check := ReadinessCheck{Name: "migrations", Status: CheckStatusOk}
+
+	store, ok := s.Store.(schemaStore)
+	if !ok {
+		check.Status = CheckStatusSkipped
+		return check
+	}
+
+	version, latest, err := store.SchemaVersions(ctx)
+	switch {
+	case err != nil:
+		check.Status = CheckStatusFailed
+		check.Detail = err.Error()
+	case version != latest:
+		check.Status = CheckStatusFailed
+		check.Detail = fmt.Sprintf("schema version %d, latest is %d", version, latest)
+	default:
+		check.Detail = fmt.Sprintf("schema version %d", version)
+	}
+	return check
+}
+
+// checkCleanup fails when the periodic transactions cleanup missed a run
+// or its last run failed. It is skipped when the cleanup is not started.
+func (s *Server) checkCleanup() ReadinessCheck {
+	check := ReadinessCheck{Name: "cleanup", Status: CheckStatusOk}
+
+	s.cleanupMu.Lock()
+	started, last, frequency := s.cleanupDone != nil, s.cleanupStartedAt, s.cleanupFrequency
+	s.cleanupMu.Unlock()
+
+	if !started {
+		check.Status = CheckStatusSkipped
+		return check
+	}
+
+	report, ran := s.Retention.LastRun()
+	if ran {
+		last = report.FinishedAt
+	}
+
+	switch {
+	case ran && report.Err != nil:
+		check.Status = CheckStatusFailed
+		check.Detail = fmt.Sprintf("the last run failed: %v", report.Err)
+	case s.Clock().Sub(last) > 2*frequency:
+		check.Status = CheckStatusFailed
+		check.Detail = fmt.Sprintf("no run since %s", last.Format(time.RFC3339))
+	case ran:
+		check.Detail = fmt.Sprintf("last run at %s", last.Format(time.RFC3339))
+	}
+	return check
+}
+
+// getDebugInfo reports the build, uptime and settings of the server and the
+// state of its store and transactions cleanup
+func (s *Server) getDebugInfo(w http.ResponseWriter, r *http.Request) {
+	now := s.Clock()
+
+	info := DebugInfo{
+		Build:     buildInfo(),
+		StartedAt: s.startedAt,
+		Uptime:    now.Sub(s.startedAt).Round(time.Second).String(),
+		Config:    config.Summary(s.settings),
+	}
+
+	if store, ok := s.Store.(fileStore); ok {
+		if stat, err := os.Stat(store.Path()); err == nil {
+			info.DbFileSize = stat.Size()
+		}
+
+		rows, err := store.TableRows(r.Context())
+		if err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		info.TableRows = rows
+	}
+
+	if report, ok := s.Retention.LastRun(); ok {
+		last := ConvertRetentionReport(report)
+		info.LastCleanup = &last
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+// buildInfo reads the module version and version control details stamped
+// into the binary
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: "unknown"}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.Version = build.Main.Version
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
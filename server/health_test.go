@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store/memory"
+)
+
+func TestCheckCleanup(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	store, err := memory.NewMemory(clock)
+	require.NoError(t, err)
+
+	s, err := New(model.ApplicationSettings{}, store, clock)
+	require.NoError(t, err)
+
+	assert.Equal(t, CheckStatusSkipped, s.checkCleanup().Status)
+
+	require.NoError(t, s.StartTransactionsCleanup(model.CleanupSettings{Frequency: time.Hour}))
+	defer s.StopTransactionsCleanup(context.Background())
+
+	now = now.Add(90 * time.Minute)
+	assert.Equal(t, CheckStatusOk, s.checkCleanup().Status)
+
+	now = now.Add(time.Hour)
+	check := s.checkCleanup()
+	assert.Equal(t, CheckStatusFailed, check.Status)
+	assert.Contains(t, check.Detail, "no run since")
+
+	_, err = s.Retention.Run(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, CheckStatusOk, s.checkCleanup().Status)
+}
+
+func TestCheckCleanupWhileStarting(t *testing.T) {
+	store, err := memory.NewMemory(nil)
+	require.NoError(t, err)
+
+	s, err := New(model.ApplicationSettings{}, store, nil)
+	require.NoError(t, err)
+
+	// run with -race, the check reads what the start writes
+	checked := make(chan struct{})
+	go func() {
+		defer close(checked)
+		s.checkCleanup()
+	}()
+
+	require.NoError(t, s.StartTransactionsCleanup(model.CleanupSettings{Frequency: time.Hour}))
+	<-checked
+	require.NoError(t, s.StopTransactionsCleanup(context.Background()))
+}
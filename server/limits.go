@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// importRoutes take the bulk imports, their bodies are limited by
+// MaxImportSize instead of MaxBodySize
+var importRoutes = map[string]bool{
+	"POST /admins":       true,
+	"POST /merchants":    true,
+	"POST /v1/admins":    true,
+	"POST /v1/merchants": true,
+}
+
+// limitBody caps the request body at the limit of its route. A body
+// announced larger is refused before it is read.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.Settings.MaxBodySize
+		if importRoutes[r.Method+" "+routeTemplate(r)] {
+			limit = s.Settings.MaxImportSize
+		}
+
+		if limit > 0 {
+			if r.ContentLength > limit {
+				writeBodyTooLarge(w, r, limit)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readBody reads the whole request body. On failure the problem is written
+// and false returned.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeJSON decodes the request body into v, it must be application/json.
+// On failure the problem is written and false returned.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mediaType(r) != ContentTypeJSON {
+		writeStatusProblem(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Sprintf("the request body must be %s", ContentTypeJSON))
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeBodyTooLarge(w, r, tooLarge.Limit)
+			return false
+		}
+		writeBadRequest(w, r, "invalid_json", fmt.Sprintf("could not decode request payload: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeBodyTooLarge(w, r, tooLarge.Limit)
+		return
+	}
+	writeBadRequest(w, r, "unreadable_body", fmt.Sprintf("can't read body: %v", err))
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	writeStatusProblem(w, r, http.StatusRequestEntityTooLarge, "body_too_large",
+		fmt.Sprintf("the request body is larger than %d bytes", limit))
+}
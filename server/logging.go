@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength bounds the request IDs taken from clients
+const maxRequestIdLength = 128
+
+// logRequests gives every request an ID, the X-Request-ID header of the
+// request or a new one, returns it in the response and logs one line per
+// request with it
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if id == "" || len(id) > maxRequestIdLength {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIdHeader, id)
+
+		r = r.WithContext(logging.WithRequestId(r.Context(), id))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(r.Context()).Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// requestId is the ID logRequests gave to r
+func requestId(r *http.Request) string {
+	return logging.RequestId(r.Context())
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed *.html
+var pageFiles embed.FS
+
+// pages are the HTML renderings of the merchants and transactions lists,
+// html/template escapes the values coming from clients
+var pages = template.Must(template.ParseFS(pageFiles, "*.html"))
+
+// pageContentSecurityPolicy allows no scripts, styles, frames or forms, the
+// pages are plain tables
+const pageContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// writePage renders the page with data and the headers keeping browsers
+// from running, framing, sniffing or caching it
+func writePage(w http.ResponseWriter, r *http.Request, page string, data any) {
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, page, data); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Security-Policy", pageContentSecurityPolicy)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "no-store")
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
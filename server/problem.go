@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeProblem = "application/problem+json"
+)
+
+// StatusClientClosedRequest is recorded for requests whose client went
+// away before the response, as nginx does. It is not a server failure.
+const StatusClientClosedRequest = 499
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Field    string `json:"field,omitempty"`
+	// Row is the position of the failing row of a bulk import, counted
+	// from 1 over the data rows. A CSV header row is not counted.
+	Row int `json:"row,omitempty"`
+
+	Errors []ProblemError `json:"errors,omitempty"`
+}
+
+// ProblemError describes a single violation of a validation problem
+type ProblemError struct {
+	Field string `json:"field,omitempty"`
+	// Row is counted like Problem.Row
+	Row     int    `json:"row,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func statusFromErrorKind(kind model.ErrorKind) int {
+	switch kind {
+	case model.ErrorKindValidation:
+		return http.StatusBadRequest
+	case model.ErrorKindNotFound:
+		return http.StatusNotFound
+	case model.ErrorKindConflict:
+		return http.StatusConflict
+	case model.ErrorKindPreconditionFailed:
+		return http.StatusUnprocessableEntity
+	case model.ErrorKindVersionMismatch:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// NewProblem builds problem details for err. Errors which are not domain
+// errors are reported as internal errors without exposing their message.
+func NewProblem(r *http.Request, err error) Problem {
+	var errs model.ValidationErrors
+	if errors.As(err, &errs) {
+		problem := Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(http.StatusBadRequest),
+			Status:   http.StatusBadRequest,
+			Detail:   "request validation failed",
+			Instance: r.URL.Path,
+			Code:     "validation_failed",
+		}
+		problem.Errors = NewProblemErrors(err)
+		return problem
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(http.StatusServiceUnavailable),
+			Status:   http.StatusServiceUnavailable,
+			Detail:   "the request did not complete in time",
+			Instance: r.URL.Path,
+			Code:     "timeout",
+		}
+	case errors.Is(err, context.Canceled):
+		return Problem{
+			Type:     "about:blank",
+			Title:    "Client Closed Request",
+			Status:   StatusClientClosedRequest,
+			Detail:   "the request was canceled",
+			Instance: r.URL.Path,
+			Code:     "canceled",
+		}
+	}
+
+	var e *model.Error
+	if !errors.As(err, &e) {
+		return Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(http.StatusInternalServerError),
+			Status:   http.StatusInternalServerError,
+			Instance: r.URL.Path,
+		}
+	}
+
+	status := statusFromErrorKind(e.Kind)
+
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   e.Message,
+		Instance: r.URL.Path,
+		Code:     e.Code,
+		Field:    e.Field,
+		Row:      e.Row,
+	}
+}
+
+// NewProblemErrors lists the violations reported by err
+func NewProblemErrors(err error) []ProblemError {
+	var errs model.ValidationErrors
+	if !errors.As(err, &errs) {
+		var e *model.Error
+		if !errors.As(err, &e) {
+			return []ProblemError{{Code: "internal", Message: http.StatusText(http.StatusInternalServerError)}}
+		}
+		errs = model.ValidationErrors{e}
+	}
+
+	result := []ProblemError{}
+	for _, e := range errs {
+		result = append(result, ProblemError{
+			Field:   e.Field,
+			Row:     e.Row,
+			Code:    e.Code,
+			Message: e.Message,
+		})
+	}
+	return result
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	problem := NewProblem(r, err)
+	switch problem.Status {
+	case http.StatusInternalServerError:
+		logging.FromContext(r.Context()).Error("request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+	case http.StatusServiceUnavailable:
+		logging.FromContext(r.Context()).Warn("request timed out", "method", r.Method, "path", r.URL.Path, "err", err)
+	case StatusClientClosedRequest:
+		logging.FromContext(r.Context()).Debug("request canceled by the client", "method", r.Method, "path", r.URL.Path)
+	}
+	writeResponse(w, problem.Status, ContentTypeProblem, problem)
+}
+
+func writeBadRequest(w http.ResponseWriter, r *http.Request, code, detail string) {
+	writeStatusProblem(w, r, http.StatusBadRequest, code, detail)
+}
+
+func writeStatusProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeResponse(w, status, ContentTypeProblem, Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	writeResponse(w, status, ContentTypeJSON, v)
+}
+
+func writeResponse(w http.ResponseWriter, status int, contentType string, v any) {
+	jsonResp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "could not encode response payload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	w.Write(jsonResp)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{model.NewValidationError("name", "required", "name cannot be empty"), http.StatusBadRequest, "required"},
+		{model.ErrMerchantNotFound, http.StatusNotFound, "merchant_not_found"},
+		{fmt.Errorf("wrapped: %w", model.ErrTransactionNotFound), http.StatusNotFound, "transaction_not_found"},
+		{model.ErrEmailAlreadyExists, http.StatusConflict, "email_already_exists"},
+		{model.ErrMerchantNotActive, http.StatusUnprocessableEntity, "merchant_not_active"},
+		{fmt.Errorf("disk I/O error"), http.StatusInternalServerError, ""},
+		{fmt.Errorf("querying: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, "timeout"},
+		{fmt.Errorf("querying: %w", context.Canceled), StatusClientClosedRequest, "canceled"},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/merchants", nil)
+
+	for _, test := range tests {
+		p := NewProblem(r, test.err)
+		assert.Equal(t, test.status, p.Status)
+		assert.Equal(t, test.code, p.Code)
+		assert.Equal(t, "/merchants", p.Instance)
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/transactions", nil)
+	w := httptest.NewRecorder()
+
+	writeProblem(w, r, model.ErrTransactionNotFound)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ContentTypeProblem, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"code":"transaction_not_found"`)
+}
+
+func TestNewProblemValidationErrors(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/merchants", nil)
+
+	_, err := ConvertCsvToMerchants([]byte("name,description,a@b.com,active\nname,a@b.com\n"))
+	require.Error(t, err)
+
+	p := NewProblem(r, err)
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, "validation_failed", p.Code)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, 2, p.Errors[0].Row)
+	assert.Equal(t, "invalid_record", p.Errors[0].Code)
+}
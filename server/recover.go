@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+)
+
+// recoverPanics logs a panic of a handler with its stack and answers the
+// request with a problem+json 500 instead of dropping the connection. The
+// response is left as is when the handler already started it.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			logging.FromContext(r.Context()).Error("handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()))
+
+			if recorder.status == 0 {
+				problem := NewProblem(r, fmt.Errorf("panic: %v", v))
+				writeResponse(recorder, problem.Status, ContentTypeProblem, problem)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/metrics"
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/model/controller"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+// panickingController panics in GetTransactions
+type panickingController struct {
+	controller.Controller
+}
+
+func (c *panickingController) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	panic("something went wrong")
+}
+
+func TestRecoverPanics(t *testing.T) {
+	mockStore, err := store.NewMockStore()
+	require.NoError(t, err)
+
+	s := newServer(model.ApplicationSettings{}, &panickingController{}, mockStore, nil, metrics.New())
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/transactions", nil))
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ContentTypeProblem, w.Header().Get("Content-Type"))
+
+	var problem Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "/v1/transactions", problem.Instance)
+	assert.NotContains(t, w.Body.String(), "something went wrong")
+
+	// the server keeps serving
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
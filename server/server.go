@@ -2,80 +2,284 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/ivaylo-todorov/payment-system/archive"
+	"github.com/ivaylo-todorov/payment-system/metrics"
 	"github.com/ivaylo-todorov/payment-system/model"
 	"github.com/ivaylo-todorov/payment-system/model/controller"
+	"github.com/ivaylo-todorov/payment-system/reencryption"
+	"github.com/ivaylo-todorov/payment-system/retention"
 	"github.com/ivaylo-todorov/payment-system/store"
+	"github.com/ivaylo-todorov/payment-system/tracing"
 )
 
-type server struct {
+type Server struct {
 	Cancel     context.CancelFunc
 	Controller controller.Controller
+	Settings   model.ServerSettings
+	Store      store.Store
+	Clock      model.Clock
+	Retention  *retention.Engine
+	// Archive is nil when archiving is disabled
+	Archive *archive.Archive
+	// Reencryption is nil when the store does not encrypt personal data
+	Reencryption *reencryption.Job
+	Metrics      *metrics.Metrics
+
+	router     *mux.Router
+	handler    http.Handler
+	httpServer *http.Server
+	// settings are reported in the diagnostics
+	settings  model.ApplicationSettings
+	startedAt time.Time
+
+	// cleanupMu guards Cancel and the cleanup fields, the readiness checks
+	// read them while the cleanup starts or stops
+	cleanupMu   sync.Mutex
+	cleanupDone chan struct{}
+	// cleanupStartedAt and cleanupFrequency tell how fresh the last cleanup
+	// run should be
+	cleanupStartedAt time.Time
+	cleanupFrequency time.Duration
 }
 
-func NewServer(settings model.ApplicationSettings) (*server, error) {
-	store, err := store.NewStore(settings.StoreSettings)
+// NewServer opens the store configured in settings and builds a server on it
+func NewServer(settings model.ApplicationSettings) (*Server, error) {
+	store, err := store.NewStore(settings.StoreSettings, time.Now)
 	if err != nil {
 		return nil, err
 	}
 
-	c, err := controller.NewController(settings, store)
+	return New(settings, store, time.Now)
+}
+
+// sqlStore is a store on a database/sql connection pool
+type sqlStore interface {
+	SqlDb() (*sql.DB, error)
+}
+
+// New builds a server on an already opened store. A nil clock means time.Now.
+// The controller works on the store through its metrics and traces, every
+// store operation under its configured deadline.
+func New(settings model.ApplicationSettings, st store.Store, clock model.Clock) (*Server, error) {
+	m := metrics.New()
+
+	if s, ok := st.(sqlStore); ok {
+		db, err := s.SqlDb()
+		if err != nil {
+			return nil, err
+		}
+		if err := m.RegisterDB(db, "payment_system"); err != nil {
+			return nil, err
+		}
+	}
+
+	instrumented := metrics.InstrumentStore(tracing.InstrumentStore(store.WithTimeouts(st, settings.StoreSettings)), m)
+
+	c, err := controller.NewController(settings, instrumented)
 	if err != nil {
 		return nil, err
 	}
 
-	return &server{
+	return newServer(settings, tracing.InstrumentController(c), st, clock, m), nil
+}
+
+// NewHandler returns the HTTP API on store without listening, e.g. for use
+// with net/http/httptest. A nil clock means time.Now.
+func NewHandler(settings model.ApplicationSettings, store store.Store, clock model.Clock) (http.Handler, error) {
+	return New(settings, store, clock)
+}
+
+func newServer(settings model.ApplicationSettings, c controller.Controller, st store.Store, clock model.Clock, m *metrics.Metrics) *Server {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	s := &Server{
 		Controller: c,
-	}, nil
+		Settings:   settings.ServerSettings,
+		Store:      st,
+		Clock:      clock,
+		Metrics:    m,
+		settings:   settings,
+		startedAt:  clock(),
+	}
+
+	// the archive encrypts personal data with the keyring of the store
+	if settings.CleanupSettings.ArchiveDir != "" {
+		s.Archive = archive.New(settings.CleanupSettings.ArchiveDir, store.Keyring(st), clock)
+	}
+	s.Retention = retention.NewEngine(settings.CleanupSettings, c, s.Archive, clock)
+
+	if r, ok := st.(reencryption.Store); ok && r.KeyVersion() != 0 {
+		s.Reencryption = reencryption.NewJob(r, clock)
+	}
+
+	s.router = s.Router()
+	s.handler = s.logRequests(s.router)
+
+	s.httpServer = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: settings.ServerSettings.ReadHeaderTimeout,
+		ReadTimeout:       settings.ServerSettings.ReadTimeout,
+		WriteTimeout:      settings.ServerSettings.WriteTimeout,
+		IdleTimeout:       settings.ServerSettings.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	return s
 }
 
-func (s *server) Start() error {
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) Router() *mux.Router {
 
 	r := mux.NewRouter()
+	r.Use(s.instrument, s.trace, s.recoverPanics, s.limitBody)
+
+	r.HandleFunc("/", s.root).Methods("GET")
+	r.Handle("/metrics", s.Metrics.Handler()).Methods("GET")
+	r.HandleFunc("/healthz", s.getHealth).Methods("GET")
+	r.HandleFunc("/readyz", s.getReadiness).Methods("GET")
+	r.HandleFunc("/debug/info", s.adminOnly(s.getDebugInfo)).Methods("GET")
 
-	r.HandleFunc("/", makeHandler(s.root)).Methods("GET")
-	r.HandleFunc("/admins", makeHandler(s.createAdmins)).Methods("POST")
-	r.HandleFunc("/merchants", makeHandler(s.getMerchants)).Methods("GET")
-	r.HandleFunc("/merchants", makeHandler(s.createMerchants)).Methods("POST")
-	r.HandleFunc("/merchants/{id}", makeHandler(s.updateMerchant)).Methods("POST")
-	r.HandleFunc("/merchants", makeHandler(s.deleteMerchants)).Methods("DELETE")
-	r.HandleFunc("/transactions", makeHandler(s.getTransactions)).Methods("GET")
-	r.HandleFunc("/transactions", makeHandler(s.postTransaction)).Methods("POST")
+	// legacy routes, superseded by /v1
+	r.HandleFunc("/admins", deprecated("POST", "/v1/admins", s.adminOnly(s.createAdmins))).Methods("POST")
+	r.HandleFunc("/merchants", deprecated("GET", "/v1/merchants", s.getMerchants)).Methods("GET")
+	r.HandleFunc("/merchants", deprecated("POST", "/v1/merchants", s.adminOnly(s.createMerchants))).Methods("POST")
+	r.HandleFunc("/merchants/{id}", deprecated("PATCH", "/v1/merchants/{id}", s.adminOnly(s.updateMerchant))).Methods("POST")
+	// the id of the merchant is in the body, deleteMerchants links the successor
+	r.HandleFunc("/merchants", deprecated("DELETE", "", s.adminOnly(s.deleteMerchants))).Methods("DELETE")
+	r.HandleFunc("/transactions", deprecated("GET", "/v1/transactions", s.getTransactions)).Methods("GET")
+	r.HandleFunc("/transactions", deprecated("POST", "/v1/transactions", s.postTransaction)).Methods("POST")
+
+	v1 := r.PathPrefix("/v1").Subrouter()
+
+	v1.HandleFunc("/admins", s.adminOnly(s.createAdmins)).Methods("POST")
+	v1.HandleFunc("/merchants", s.getMerchants).Methods("GET")
+	v1.HandleFunc("/merchants", s.adminOnly(s.createMerchants)).Methods("POST")
+	v1.HandleFunc("/merchants/{id}", s.getMerchantV1).Methods("GET")
+	v1.HandleFunc("/merchants/{id}", s.adminOnly(s.patchMerchantV1)).Methods("PATCH")
+	v1.HandleFunc("/merchants/{id}", s.adminOnly(s.deleteMerchantV1)).Methods("DELETE")
+	v1.HandleFunc("/transactions", s.getTransactions).Methods("GET")
+	v1.HandleFunc("/transactions", s.postTransaction).Methods("POST")
+	v1.HandleFunc("/transactions/{id}", s.getTransactionV1).Methods("GET")
+
+	v1.HandleFunc("/admin/retention", s.adminOnly(s.getRetentionRun)).Methods("GET")
+	v1.HandleFunc("/admin/retention/dry-run", s.adminOnly(s.postRetentionDryRun)).Methods("POST")
+	v1.HandleFunc("/admin/restore", s.adminOnly(s.postRestore)).Methods("POST")
+	v1.HandleFunc("/admin/reencryption", s.adminOnly(s.getReencryption)).Methods("GET")
+	v1.HandleFunc("/admin/reencryption", s.adminOnly(s.postReencryption)).Methods("POST")
+	v1.HandleFunc("/admin/erasures", s.adminOnly(s.getErasures)).Methods("GET")
+	v1.HandleFunc("/admin/erasures", s.adminOnly(s.postErasure)).Methods("POST")
+	v1.HandleFunc("/admin/log-level", s.adminOnly(s.getLogLevel)).Methods("GET")
+	v1.HandleFunc("/admin/log-level", s.adminOnly(s.putLogLevel)).Methods("PUT")
+	v1.HandleFunc("/audit", s.adminOnly(s.getAudit)).Methods("GET")
+
+	return r
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.Settings.ListenAddress)
+	if err != nil {
+		slog.Error("starting server failed", "err", err)
+		return err
+	}
+
+	return s.Serve(listener)
+}
 
-	err := http.ListenAndServe(":8080", r)
+// Serve handles requests on listener until Shutdown is called
+func (s *Server) Serve(listener net.Listener) error {
+	var err error
+	if s.Settings.TLS.CertFile != "" {
+		err = s.httpServer.ServeTLS(listener, s.Settings.TLS.CertFile, s.Settings.TLS.KeyFile)
+	} else {
+		err = s.httpServer.Serve(listener)
+	}
 	if errors.Is(err, http.ErrServerClosed) {
-		fmt.Printf("server closed\n")
+		slog.Info("server closed")
 		return nil
 	}
 
-	fmt.Printf("error starting server: %s\n", err)
+	slog.Error("serving failed", "err", err)
 
 	return err
 }
 
-func (s *server) StartTransactionsCleanup(interval time.Duration) error {
+// Shutdown stops accepting new requests and waits for the in-flight ones
+// to complete or for ctx to be done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// Close releases the store, it must be called after Shutdown. It stops
+// waiting for the store when ctx is done.
+func (s *Server) Close(ctx context.Context) error {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.Store.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartTransactionsCleanup runs the retention engine every settings.Frequency,
+// in a dry run only the report is logged
+func (s *Server) StartTransactionsCleanup(settings model.CleanupSettings) error {
+	interval := settings.Frequency
+
 	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
 
+	s.cleanupMu.Lock()
 	s.Cancel = cancel
+	s.cleanupDone = done
+	s.cleanupStartedAt = s.Clock()
+	s.cleanupFrequency = interval
+	s.cleanupMu.Unlock()
 
 	go func() {
+		defer close(done)
+
 		for {
 			select {
-			case <-time.After(interval * time.Minute):
-				now := time.Now()
-				err := s.Controller.DeleteTransactions(model.TransactionQuery{
-					OlderThan: &now,
-				})
+			case <-time.After(interval):
+				report, err := s.Retention.Run(ctx, settings.DryRun)
+				deleted := report.PurgedTransactions
+				if report.DryRun {
+					deleted = 0
+				}
+				s.Metrics.CleanupRun(deleted, err)
 				if err != nil {
-					log.Printf("Cleaning up transactions failed, %s", err.Error())
+					slog.Error("cleaning up transactions failed", "err", err)
+					continue
 				}
+				slog.Info("cleaned up transactions",
+					"dry_run", report.DryRun,
+					"purged_chains", report.PurgedChains,
+					"chains", report.Chains,
+					"purged_transactions", report.PurgedTransactions,
+					"archive", report.Archive)
 			case <-ctx.Done():
 				return
 			}
@@ -85,16 +289,77 @@ func (s *server) StartTransactionsCleanup(interval time.Duration) error {
 	return nil
 }
 
-func (s *server) StopTransactionsCleanup() error {
-	s.Cancel()
+func (s *Server) StopTransactionsCleanup(ctx context.Context) error {
+	s.cleanupMu.Lock()
+	cancel, done := s.Cancel, s.cleanupDone
+	s.cleanupMu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartReencryption moves the personal data not encrypted with the current
+// key of the keyring to it in the background
+func (s *Server) StartReencryption() error {
+	if s.Reencryption == nil {
+		return nil
+	}
+
+	progress, err := s.Reencryption.Start()
+	if err != nil {
+		return err
+	}
+	slog.Info("re-encrypting personal data", "key_version", progress.KeyVersion)
+
 	return nil
 }
 
-func makeHandler(fn func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) StopReencryption(ctx context.Context) error {
+	if s.Reencryption == nil {
+		return nil
+	}
 
-		// TODOO: Authentication
+	return s.Reencryption.Stop(ctx)
+}
+
+// requestActor is the actor the X-Actor header of a request claims. The
+// admin token does not tell who bears it, so the name stays a claim and the
+// audit log records apart whether the request bore the token.
+func requestActor(r *http.Request) model.Actor {
+	authenticated, _ := r.Context().Value(adminKey{}).(bool)
+	return model.Actor{
+		Name:          r.Header.Get("X-Actor"),
+		Authenticated: authenticated,
+		RequestId:     requestId(r),
+	}
+}
+
+// deprecated marks responses of legacy routes and links their /v1
+// successor, called with method. The {id} of the successor path is the
+// one of the request, an empty path is linked by the handler.
+func deprecated(method, successor string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if successor != "" {
+			linkSuccessor(w, method, strings.ReplaceAll(successor, "{id}", url.PathEscape(mux.Vars(r)["id"])))
+		}
 
 		fn(w, r)
 	}
 }
+
+// linkSuccessor points to the /v1 successor of a legacy route, the title
+// of the link is the method to call it with
+func linkSuccessor(w http.ResponseWriter, method, path string) {
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"; title=\"%s\"", path, method))
+}
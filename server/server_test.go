@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/metrics"
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/model/controller"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+// blockingController blocks StartTransaction until released
+type blockingController struct {
+	controller.Controller
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingController) StartTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
+	close(c.started)
+	<-c.release
+	t.Status = model.TransactionStatusApproved
+	return t, nil
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	c := &blockingController{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	mockStore, err := store.NewMockStore()
+	require.NoError(t, err)
+
+	s := newServer(model.ApplicationSettings{}, c, mockStore, nil, metrics.New())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(listener)
+	}()
+
+	url := "http://" + listener.Addr().String()
+
+	type result struct {
+		status int
+		err    error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		body := `{"transaction": {"merchant_uuid": "c15760c1-bb8d-4717-98f9-feb182950259", "type": "authorize", "amount": 1}}`
+		resp, err := http.Post(url+"/v1/transactions", ContentTypeJSON, strings.NewReader(body))
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		resp.Body.Close()
+		responses <- result{status: resp.StatusCode}
+	}()
+
+	<-c.started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the in-flight request completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// new connections are refused while draining
+	_, err = http.Get(url + "/")
+	assert.Error(t, err)
+
+	close(c.release)
+
+	r := <-responses
+	require.NoError(t, r.err)
+	assert.Equal(t, http.StatusOK, r.status)
+
+	require.NoError(t, <-shutdown)
+	require.NoError(t, <-served)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	c := &blockingController{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	defer close(c.release)
+
+	s := newServer(model.ApplicationSettings{}, c, nil, nil, metrics.New())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go s.Serve(listener)
+
+	go http.Post("http://"+listener.Addr().String()+"/v1/transactions", ContentTypeJSON,
+		strings.NewReader(`{"transaction": {}}`))
+
+	<-c.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+// blockingStore blocks Close until released
+type blockingStore struct {
+	store.Store
+
+	release chan struct{}
+}
+
+func (s *blockingStore) Close() error {
+	<-s.release
+	return nil
+}
+
+func TestCloseDeadline(t *testing.T) {
+	st := &blockingStore{release: make(chan struct{})}
+	defer close(st.release)
+
+	s := newServer(model.ApplicationSettings{}, &blockingController{}, st, nil, metrics.New())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, s.Close(ctx), context.DeadlineExceeded)
+}
@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 type Admin struct {
 	Id          string `json:"uuid"`
 	Name        string `json:"name"`
@@ -14,23 +16,37 @@ type AdminResponse struct {
 
 // TODO: omit empty for Name, Description, Email, Staatus in request
 type Merchant struct {
-	Id                 string `json:"uuid"`
-	Name               string `json:"name"`
-	Description        string `json:"description"`
-	Email              string `json:"email"`
-	Status             string `json:"status"`
-	TransactionsAmount int64  `json:"total_transaction_sum"`
+	Id                 string     `json:"uuid"`
+	Name               string     `json:"name"`
+	Description        string     `json:"description"`
+	Email              string     `json:"email"`
+	Status             string     `json:"status"`
+	StatusReason       string     `json:"status_reason,omitempty"`
+	StatusChangedAt    *time.Time `json:"status_changed_at,omitempty"`
+	TransactionsAmount int64      `json:"total_transaction_sum"`
+	Version            int64      `json:"version"`
 }
 
 type MerchantRequest struct {
 	Merchant Merchant `json:"merchant"`
 }
 
-type MerchantResponse struct {
-	Error     string     `json:"error"`
+type MerchantsRequest struct {
 	Merchants []Merchant `json:"merchants"`
 }
 
+type MerchantImportResult struct {
+	Row    int            `json:"row"`
+	Id     string         `json:"uuid,omitempty"`
+	Errors []ProblemError `json:"errors,omitempty"`
+}
+
+type MerchantResponse struct {
+	Error     string                 `json:"error"`
+	Merchants []Merchant             `json:"merchants"`
+	Results   []MerchantImportResult `json:"results,omitempty"`
+}
+
 // TODO: omit empty for ParentId
 type Transaction struct {
 	Id            string `json:"uuid"`
@@ -51,3 +67,131 @@ type TransactionResponse struct {
 	Error        string        `json:"error"`
 	Transactions []Transaction `json:"transactions"`
 }
+
+type RetentionReport struct {
+	StartedAt          time.Time      `json:"started_at"`
+	FinishedAt         time.Time      `json:"finished_at"`
+	DryRun             bool           `json:"dry_run"`
+	Transactions       int            `json:"transactions"`
+	Chains             int            `json:"chains"`
+	PurgedChains       int            `json:"purged_chains"`
+	PurgedTransactions int            `json:"purged_transactions"`
+	PurgedByStatus     map[string]int `json:"purged_by_status"`
+	Kept               map[string]int `json:"kept"`
+	Purged             []string       `json:"purged,omitempty"`
+	Archive            string         `json:"archive,omitempty"`
+	Error              string         `json:"error,omitempty"`
+}
+
+type RestoreRequest struct {
+	Manifest string `json:"manifest"`
+}
+
+type RestoreResponse struct {
+	Manifest     string `json:"manifest"`
+	Transactions int    `json:"transactions"`
+	Restored     int    `json:"restored"`
+}
+
+type ReencryptionProgress struct {
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Running     bool       `json:"running"`
+	KeyVersion  int        `json:"key_version"`
+	Total       int        `json:"total"`
+	Reencrypted int        `json:"reencrypted"`
+	Remaining   int        `json:"remaining"`
+	Error       string     `json:"error,omitempty"`
+}
+
+type Erasure struct {
+	Id           string    `json:"uuid"`
+	Subject      string    `json:"subject"`
+	Transactions int       `json:"transactions"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type ErasureRequest struct {
+	CustomerEmail string `json:"customer_email"`
+}
+
+type ErasureResponse struct {
+	Erasure Erasure `json:"erasure"`
+	// Erased is the number of transactions erased by the request
+	Erased int `json:"erased"`
+	// Archived is the number of archived transactions erased by the request
+	Archived int `json:"archived"`
+}
+
+type ErasuresResponse struct {
+	Erasures []Erasure `json:"erasures"`
+}
+
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type AuditEntry struct {
+	Sequence      int64         `json:"sequence"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Actor         string        `json:"actor"`
+	Authenticated bool          `json:"authenticated"`
+	RequestId     string        `json:"request_id,omitempty"`
+	Action        string        `json:"action"`
+	TargetType    string        `json:"target_type"`
+	TargetId      string        `json:"target_uuid"`
+	Changes       []AuditChange `json:"changes"`
+	PrevHash      string        `json:"prev_hash"`
+	Hash          string        `json:"hash"`
+}
+
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+const (
+	CheckStatusOk      = "ok"
+	CheckStatusFailed  = "failed"
+	CheckStatusSkipped = "skipped"
+)
+
+type Health struct {
+	Status string `json:"status"`
+}
+
+type ReadinessCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+type DebugInfo struct {
+	Build     BuildInfo      `json:"build"`
+	StartedAt time.Time      `json:"started_at"`
+	Uptime    string         `json:"uptime"`
+	Config    map[string]any `json:"config"`
+	// DbFileSize is the size in bytes of the database file of file stores
+	DbFileSize  int64            `json:"db_file_size,omitempty"`
+	TableRows   map[string]int64 `json:"table_rows,omitempty"`
+	LastCleanup *RetentionReport `json:"last_cleanup,omitempty"`
+}
@@ -0,0 +1,1022 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+const DefaultPath = "payment_system.bolt"
+
+// NewBolt opens a bolt database file. It is a pure Go alternative to the
+// SQLite store with the same semantics.
+func NewBolt(settings model.StoreSettings, clock model.Clock) (*boltStore, error) {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	path := settings.DbPath
+	if path == "" {
+		path = DefaultPath
+	}
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{
+		db:    db,
+		clock: clock,
+	}, nil
+}
+
+type boltStore struct {
+	db    *bbolt.DB
+	clock model.Clock
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// Path is the file of the database
+func (s *boltStore) Path() string {
+	return s.db.Path()
+}
+
+// TableRows counts the keys of each bucket
+func (s *boltStore) TableRows(ctx context.Context) (map[string]int64, error) {
+	rows := map[string]int64{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		for _, name := range buckets {
+			rows[string(name)] = int64(tx.Bucket(name).Stats().KeyN)
+		}
+		return nil
+	})
+	return rows, err
+}
+
+func (s *boltStore) CreateAdmin(ctx context.Context, a model.Admin) (model.Admin, error) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		id := uuid.New()
+
+		if err := createUser(tx, a.Email, User{Role: model.UserRoleAdmin, Id: id}); err != nil {
+			return err
+		}
+
+		admin := Admin{
+			Id:          id,
+			Name:        a.Name,
+			Description: a.Description,
+			Email:       a.Email,
+		}
+		if err := put(tx.Bucket(bucketAdmins), id[:], admin); err != nil {
+			return err
+		}
+
+		a.Id = id
+
+		if auditor := model.AuditorFrom(ctx); auditor != nil {
+			_, err := s.appendAudit(tx, auditor.AdminCreated(a))
+			return err
+		}
+		return nil
+	})
+
+	return a, err
+}
+
+func (s *boltStore) CreateMerchant(ctx context.Context, m model.Merchant) (model.Merchant, error) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		m, err = s.createMerchant(ctx, tx, m)
+		return err
+	})
+
+	return m, err
+}
+
+// CreateMerchants creates all merchants in a single transaction,
+// nothing is created if any of them fails
+func (s *boltStore) CreateMerchants(ctx context.Context, input []model.Merchant) ([]model.Merchant, error) {
+	result := []model.Merchant{}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for n, i := range input {
+			m, err := s.createMerchant(ctx, tx, i)
+			if err != nil {
+				return model.ErrorAtRow(err, n+1)
+			}
+			result = append(result, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return []model.Merchant{}, err
+	}
+
+	return result, nil
+}
+
+func (s *boltStore) createMerchant(ctx context.Context, tx *bbolt.Tx, m model.Merchant) (model.Merchant, error) {
+	merchant := Merchant{
+		Id:              uuid.New(),
+		Name:            m.Name,
+		Description:     m.Description,
+		Email:           m.Email,
+		Status:          m.Status,
+		StatusChangedAt: s.clock().UTC(),
+		Version:         1,
+	}
+
+	if err := createUser(tx, m.Email, User{Role: model.UserRoleMerchant, Id: merchant.Id}); err != nil {
+		return m, err
+	}
+
+	merchants := tx.Bucket(bucketMerchants)
+	seq, err := merchants.NextSequence()
+	if err != nil {
+		return m, err
+	}
+	key := sequenceKey(seq)
+
+	if err := put(merchants, key, merchant); err != nil {
+		return m, err
+	}
+	if err := tx.Bucket(bucketMerchantsByUuid).Put(merchant.Id[:], key); err != nil {
+		return m, err
+	}
+
+	m = merchant.toModel(0)
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		if _, err := s.appendAudit(tx, auditor.Merchant(model.AuditActionMerchantCreate, model.Merchant{}, m)); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+func (s *boltStore) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (model.Merchant, error) {
+	result := model.Merchant{}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		key, merchant, err := getMerchant(tx, p.Id)
+		if err != nil {
+			return err
+		}
+
+		// a stale version fails first, the status it was checked against
+		// may have changed since
+		if p.Version != 0 && p.Version != merchant.Version {
+			return model.ErrMerchantVersionMismatch
+		}
+
+		if merchant.Status == model.MerchantStatusClosed {
+			return model.ErrMerchantClosed
+		}
+		if p.Status != nil {
+			if err := model.CheckMerchantTransition(merchant.Status, *p.Status); err != nil {
+				return err
+			}
+		}
+
+		before, err := toModelMerchant(tx, merchant)
+		if err != nil {
+			return err
+		}
+
+		if p.Email != nil && *p.Email != merchant.Email {
+			if err := createUser(tx, *p.Email, User{Role: model.UserRoleMerchant, Id: merchant.Id}); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketUsers).Delete([]byte(merchant.Email)); err != nil {
+				return err
+			}
+			merchant.Email = *p.Email
+		}
+		if p.Name != nil {
+			merchant.Name = *p.Name
+		}
+		if p.Description != nil {
+			merchant.Description = *p.Description
+		}
+		if p.Status != nil {
+			if *p.Status != merchant.Status {
+				merchant.Status = *p.Status
+				merchant.StatusChangedAt = s.clock().UTC()
+			}
+			merchant.StatusReason = ""
+			if p.StatusReason != nil {
+				merchant.StatusReason = *p.StatusReason
+			}
+		}
+
+		// closing frees the email
+		if merchant.Status == model.MerchantStatusClosed {
+			anonymized := model.AnonymizedMerchant(merchant.toModel(0))
+			if err := createUser(tx, anonymized.Email, User{Role: model.UserRoleMerchant, Id: merchant.Id}); err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketUsers).Delete([]byte(merchant.Email)); err != nil {
+				return err
+			}
+			merchant.Name = anonymized.Name
+			merchant.Description = anonymized.Description
+			merchant.Email = anonymized.Email
+		}
+
+		// every update bumps the version
+		merchant.Version++
+
+		if err := put(tx.Bucket(bucketMerchants), key, merchant); err != nil {
+			return err
+		}
+
+		result, err = toModelMerchant(tx, merchant)
+		if err != nil {
+			return err
+		}
+
+		if auditor := model.AuditorFrom(ctx); auditor != nil {
+			_, err = s.appendAudit(tx, auditor.Merchant(model.AuditActionMerchantUpdate, before, result))
+		}
+		return err
+	})
+
+	return result, err
+}
+
+// Merchants are soft deleted and keep their email.
+// A non zero version must match the current merchant version.
+func (s *boltStore) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		key, merchant, err := getMerchant(tx, id)
+		if err != nil {
+			return err
+		}
+
+		hasTransactions := false
+		err = merchantTransactions(tx, id, func(t Transaction) error {
+			hasTransactions = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if hasTransactions {
+			return model.ErrMerchantHasTransactions
+		}
+
+		if version != 0 && version != merchant.Version {
+			return model.ErrMerchantVersionMismatch
+		}
+
+		if auditor := model.AuditorFrom(ctx); auditor != nil {
+			before, err := toModelMerchant(tx, merchant)
+			if err != nil {
+				return err
+			}
+			if _, err := s.appendAudit(tx, auditor.Merchant(model.AuditActionMerchantDelete, before, model.Merchant{})); err != nil {
+				return err
+			}
+		}
+
+		now := s.clock()
+		merchant.DeletedAt = &now
+
+		return put(tx.Bucket(bucketMerchants), key, merchant)
+	})
+}
+
+func (s *boltStore) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	result := model.Merchant{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		_, merchant, err := getMerchant(tx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err = toModelMerchant(tx, merchant)
+		return err
+	})
+
+	return result, err
+}
+
+func (s *boltStore) GetMerchants(ctx context.Context, query model.MerchantQuery) ([]model.Merchant, error) {
+	merchants := []model.Merchant{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketMerchants).ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			merchant := Merchant{}
+			if err := json.Unmarshal(v, &merchant); err != nil {
+				return err
+			}
+			if merchant.DeletedAt != nil {
+				return nil
+			}
+
+			m, err := toModelMerchant(tx, merchant)
+			if err != nil {
+				return err
+			}
+			merchants = append(merchants, m)
+			return nil
+		})
+	})
+
+	return merchants, err
+}
+
+func (s *boltStore) CreateTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		_, merchant, err := getMerchant(tx, t.MerchantId)
+		if err != nil {
+			return err
+		}
+
+		if err := model.CheckMerchantTransaction(merchant.Status, t.Type); err != nil {
+			return err
+		}
+		t.MerchantStatus = merchant.Status
+
+		transaction := Transaction{
+			Id:            uuid.New(),
+			ParentId:      t.ParentId,
+			MerchantId:    merchant.Id,
+			Type:          t.Type,
+			Amount:        t.Amount,
+			Status:        t.Status,
+			CustomerEmail: t.CustomerEmail,
+			CustomerPhone: t.CustomerPhone,
+			CreatedAt:     s.clock(),
+		}
+
+		var parentStatus string
+
+		switch t.Type {
+		case model.TransactionTypeAuthorize:
+			// authorizations have no reference transaction
+			transaction.ParentId = uuid.Nil
+		case model.TransactionTypeCharge:
+		case model.TransactionTypeRefund:
+			parentStatus = model.TransactionStatusRefunded
+		case model.TransactionTypeReversal:
+			parentStatus = model.TransactionStatusReversed
+		default:
+			return model.NewValidationError("type", "invalid", "invalid transaction type")
+		}
+
+		var parentKey []byte
+		var parent Transaction
+
+		if t.Type != model.TransactionTypeAuthorize {
+			parentKey, parent, err = getTransaction(tx, t.ParentId)
+			if err == model.ErrTransactionNotFound {
+				return model.ErrParentNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := createTransaction(tx, transaction); err != nil {
+			return err
+		}
+
+		t.Id = transaction.Id
+		t.CreatedAt = transaction.CreatedAt
+
+		// don't update reference transaction on errors
+		if parentStatus == "" || transaction.Status == model.TransactionStatusError {
+			return nil
+		}
+
+		parent.Status = parentStatus
+
+		return put(tx.Bucket(bucketTransactions), parentKey, parent)
+	})
+	if err != nil {
+		return model.Transaction{}, err
+	}
+
+	return t, nil
+}
+
+func createTransaction(tx *bbolt.Tx, t Transaction) error {
+	transactions := tx.Bucket(bucketTransactions)
+	seq, err := transactions.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := sequenceKey(seq)
+
+	if err := put(transactions, key, t); err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(bucketTransactionsByUuid).Put(t.Id[:], key); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketTransactionsByMerchant).Put(indexKey(t.MerchantId[:], key), nil); err != nil {
+		return err
+	}
+	if t.ParentId != uuid.Nil {
+		if err := tx.Bucket(bucketTransactionsByParent).Put(indexKey(t.ParentId[:], key), nil); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(bucketTransactionsByCreatedAt).Put(indexKey(timeKey(t.CreatedAt), key), nil)
+}
+
+// deleteTransaction removes the transaction under key and its index entries
+func deleteTransaction(tx *bbolt.Tx, key []byte, t Transaction) error {
+	if err := tx.Bucket(bucketTransactions).Delete(key); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketTransactionsByUuid).Delete(t.Id[:]); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketTransactionsByMerchant).Delete(indexKey(t.MerchantId[:], key)); err != nil {
+		return err
+	}
+	if t.ParentId != uuid.Nil {
+		if err := tx.Bucket(bucketTransactionsByParent).Delete(indexKey(t.ParentId[:], key)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(bucketTransactionsByCreatedAt).Delete(indexKey(timeKey(t.CreatedAt), key))
+}
+
+func (s *boltStore) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
+	result := model.Transaction{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		_, t, err := getTransaction(tx, id)
+		if err != nil {
+			return err
+		}
+
+		result = t.toModel()
+		return nil
+	})
+
+	return result, err
+}
+
+// GetTransactions returns a page of the transactions selected by query in
+// creation order. Listed ids or parent ids are looked up in their index,
+// else a query for all older ones scans the created at index. Pages and
+// the remaining queries read the bucket from the key of After.
+func (s *boltStore) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	transactions := []model.Transaction{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketTransactions)
+
+		var after []byte
+		if query.After != uuid.Nil {
+			if after = tx.Bucket(bucketTransactionsByUuid).Get(query.After[:]); after == nil {
+				return model.ErrTransactionNotFound
+			}
+		}
+
+		// collect adds the transaction when it is selected and reports
+		// whether the page is full
+		collect := func(v []byte) (bool, error) {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			t := Transaction{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return false, err
+			}
+			if query.Matches(t.toModel()) {
+				transactions = append(transactions, t.toModel())
+			}
+			return query.Limit > 0 && len(transactions) == query.Limit, nil
+		}
+
+		keys, indexed := transactionKeys(tx, query)
+		if !indexed {
+			c := bucket.Cursor()
+			k, v := c.First()
+			if after != nil {
+				if k, v = c.Seek(after); bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil; k, v = c.Next() {
+				if full, err := collect(v); err != nil || full {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for _, key := range keys {
+			if after != nil && bytes.Compare(key, after) <= 0 {
+				continue
+			}
+			v := bucket.Get(key)
+			if v == nil {
+				continue
+			}
+			if full, err := collect(v); err != nil || full {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return transactions, err
+}
+
+// transactionKeys returns the sorted keys of the transactions query may
+// select, found in an index. It returns false when no index narrows query
+// down, or for a page of older ones, reading the bucket from After is
+// cheaper then.
+func transactionKeys(tx *bbolt.Tx, query model.TransactionQuery) ([][]byte, bool) {
+	keys := [][]byte{}
+
+	switch {
+	case len(query.Ids) != 0:
+		byUuid := tx.Bucket(bucketTransactionsByUuid)
+		for _, id := range query.Ids {
+			if key := byUuid.Get(id[:]); key != nil {
+				keys = append(keys, append([]byte{}, key...))
+			}
+		}
+	case len(query.ParentIds) != 0:
+		c := tx.Bucket(bucketTransactionsByParent).Cursor()
+		for _, id := range query.ParentIds {
+			prefix := id[:]
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				keys = append(keys, append([]byte{}, k[len(prefix):]...))
+			}
+		}
+	case query.OlderThan != nil && query.After == uuid.Nil && query.Limit == 0:
+		limit := timeKey(*query.OlderThan)
+		c := tx.Bucket(bucketTransactionsByCreatedAt).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:timeKeySize], limit) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k[timeKeySize:]...))
+		}
+	default:
+		return nil, false
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	// listed twice, a key is returned once
+	unique := keys[:0]
+	for i, key := range keys {
+		if i == 0 || !bytes.Equal(key, keys[i-1]) {
+			unique = append(unique, key)
+		}
+	}
+	return unique, true
+}
+
+// DeleteTransactions deletes the transactions selected by query for good,
+// except those with a kept transaction below them in their chain
+func (s *boltStore) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	if !query.Selects() {
+		return nil
+	}
+	query.After, query.Limit = uuid.Nil, 0
+
+	selected := func(t Transaction) bool {
+		return query.Matches(t.toModel())
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		transactions := tx.Bucket(bucketTransactions)
+
+		// collect first, deleting while iterating a cursor skips keys
+		candidates, _ := transactionKeys(tx, query)
+
+		for _, key := range candidates {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			t := Transaction{}
+			found, err := get(transactions, key, &t)
+			if err != nil {
+				return err
+			}
+			if !found || !selected(t) {
+				continue
+			}
+
+			kept, err := keepsChildren(tx, t.Id, selected)
+			if err != nil {
+				return err
+			}
+			if kept {
+				continue
+			}
+
+			if err := deleteTransaction(tx, key, t); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// RestoreTransactions inserts archived transactions as they were, parents
+// before their children. Present transactions are skipped. Either all
+// transactions are restored or none.
+func (s *boltStore) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	restored := 0
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for _, t := range transactions {
+			if tx.Bucket(bucketTransactionsByUuid).Get(t.Id[:]) != nil {
+				continue
+			}
+
+			if _, _, err := getMerchant(tx, t.MerchantId); err != nil {
+				return err
+			}
+
+			if t.ParentId != uuid.Nil {
+				_, _, err := getTransaction(tx, t.ParentId)
+				if err == model.ErrTransactionNotFound {
+					return model.ErrParentNotFound
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			err := createTransaction(tx, Transaction{
+				Id:            t.Id,
+				ParentId:      t.ParentId,
+				MerchantId:    t.MerchantId,
+				Type:          t.Type,
+				Amount:        t.Amount,
+				Status:        t.Status,
+				CustomerEmail: t.CustomerEmail,
+				CustomerPhone: t.CustomerPhone,
+				CreatedAt:     t.CreatedAt,
+			})
+			if err != nil {
+				return err
+			}
+			restored++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return restored, nil
+}
+
+// EraseCustomer pseudonymizes every transaction of a customer and records
+// the erasure. Repeating it only pseudonymizes the
+// transactions created since.
+func (s *boltStore) EraseCustomer(ctx context.Context, e model.CustomerErasure) (model.Erasure, int, error) {
+	result := model.Erasure{}
+	erased := 0
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		email := model.NormalizeCustomerEmail(e.Email)
+		transactions := tx.Bucket(bucketTransactions)
+
+		// collect first, bolt does not allow changing a bucket while iterating it
+		keys := [][]byte{}
+		records := []Transaction{}
+		err := transactions.ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			t := Transaction{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			if !model.IsErasedEmail(t.CustomerEmail) && model.NormalizeCustomerEmail(t.CustomerEmail) == email {
+				keys = append(keys, append([]byte{}, k...))
+				records = append(records, t)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for n, t := range records {
+			pseudonymized := e.Pseudonymizer.Transaction(t.toModel())
+			t.CustomerEmail = pseudonymized.CustomerEmail
+			t.CustomerPhone = pseudonymized.CustomerPhone
+			if err := put(transactions, keys[n], t); err != nil {
+				return err
+			}
+		}
+		erased = len(records)
+
+		now := s.clock().UTC()
+		subject := e.Pseudonymizer.Subject(email)
+		erasures := tx.Bucket(bucketErasures)
+
+		record := Erasure{}
+		before := model.Erasure{}
+		key := tx.Bucket(bucketErasuresBySubject).Get([]byte(subject))
+		if key != nil {
+			key = append([]byte{}, key...)
+			if _, err := get(erasures, key, &record); err != nil {
+				return err
+			}
+			before = record.toModel()
+		} else {
+			seq, err := erasures.NextSequence()
+			if err != nil {
+				return err
+			}
+			key = sequenceKey(seq)
+			if err := tx.Bucket(bucketErasuresBySubject).Put([]byte(subject), key); err != nil {
+				return err
+			}
+
+			record = Erasure{
+				Id:        uuid.New(),
+				Subject:   subject,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+		}
+		if erased != 0 {
+			record.Transactions += erased
+			record.UpdatedAt = now
+		}
+
+		result = record.toModel()
+		if auditor := model.AuditorFrom(ctx); auditor != nil {
+			if _, err := s.appendAudit(tx, auditor.Erasure(before, result)); err != nil {
+				return err
+			}
+		}
+		return put(erasures, key, record)
+	})
+	if err != nil {
+		return model.Erasure{}, 0, err
+	}
+
+	return result, erased, nil
+}
+
+func (s *boltStore) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	erasures := []model.Erasure{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketErasures).ForEach(func(k, v []byte) error {
+			e := Erasure{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			erasures = append(erasures, e.toModel())
+			return nil
+		})
+	})
+
+	return erasures, err
+}
+
+// AppendAudit adds e to the end of the audit log
+func (s *boltStore) AppendAudit(ctx context.Context, e model.AuditEntry) (model.AuditEntry, error) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		e, err = s.appendAudit(tx, e)
+		return err
+	})
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+
+	return e, nil
+}
+
+// appendAudit adds e to the end of the audit log in tx, the transaction of
+// the change it records
+func (s *boltStore) appendAudit(tx *bbolt.Tx, e model.AuditEntry) (model.AuditEntry, error) {
+	audit := tx.Bucket(bucketAuditLog)
+
+	var prev *model.AuditEntry
+	if k, v := audit.Cursor().Last(); k != nil {
+		last := AuditEntry{}
+		if err := json.Unmarshal(v, &last); err != nil {
+			return e, err
+		}
+		l := last.toModel()
+		prev = &l
+	}
+
+	e.CreatedAt = s.clock().UTC()
+	e = e.Seal(prev)
+
+	return e, put(audit, sequenceKey(uint64(e.Sequence)), AuditEntry{
+		Sequence:      e.Sequence,
+		CreatedAt:     e.CreatedAt,
+		Actor:         e.Actor,
+		Authenticated: e.Authenticated,
+		RequestId:     e.RequestId,
+		Action:        e.Action,
+		TargetType:    e.TargetType,
+		TargetId:      e.TargetId,
+		Changes:       e.Changes,
+		PrevHash:      e.PrevHash,
+		Hash:          e.Hash,
+	})
+}
+
+func (s *boltStore) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	entries := []model.AuditEntry{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketAuditLog).ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if query.Matches(e.toModel()) {
+				entries = append(entries, e.toModel())
+			}
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+// keepsChildren reports whether a transaction below id in its chain is not
+// selected for deletion
+func keepsChildren(tx *bbolt.Tx, id uuid.UUID, selected func(Transaction) bool) (bool, error) {
+	transactions := tx.Bucket(bucketTransactions)
+	prefix := id[:]
+
+	c := tx.Bucket(bucketTransactionsByParent).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		child := Transaction{}
+		found, err := get(transactions, k[len(prefix):], &child)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			continue
+		}
+
+		if !selected(child) {
+			return true, nil
+		}
+
+		kept, err := keepsChildren(tx, child.Id, selected)
+		if err != nil || kept {
+			return kept, err
+		}
+	}
+
+	return false, nil
+}
+
+// createUser reserves the email of a new user
+func createUser(tx *bbolt.Tx, email string, user User) error {
+	users := tx.Bucket(bucketUsers)
+	if users.Get([]byte(email)) != nil {
+		return model.ErrEmailAlreadyExists
+	}
+	return put(users, []byte(email), user)
+}
+
+// getMerchant returns the key and record of a merchant that is not deleted
+func getMerchant(tx *bbolt.Tx, id uuid.UUID) ([]byte, Merchant, error) {
+	merchant := Merchant{}
+
+	key := tx.Bucket(bucketMerchantsByUuid).Get(id[:])
+	if key == nil {
+		return nil, merchant, model.ErrMerchantNotFound
+	}
+
+	found, err := get(tx.Bucket(bucketMerchants), key, &merchant)
+	if err != nil {
+		return nil, merchant, err
+	}
+	if !found || merchant.DeletedAt != nil {
+		return nil, merchant, model.ErrMerchantNotFound
+	}
+
+	return key, merchant, nil
+}
+
+// getTransaction returns the key and record of a transaction that is not deleted
+func getTransaction(tx *bbolt.Tx, id uuid.UUID) ([]byte, Transaction, error) {
+	t := Transaction{}
+
+	key := tx.Bucket(bucketTransactionsByUuid).Get(id[:])
+	if key == nil {
+		return nil, t, model.ErrTransactionNotFound
+	}
+
+	found, err := get(tx.Bucket(bucketTransactions), key, &t)
+	if err != nil {
+		return nil, t, err
+	}
+	if !found {
+		return nil, t, model.ErrTransactionNotFound
+	}
+
+	return key, t, nil
+}
+
+// merchantTransactions calls fn for every transaction of the merchant
+func merchantTransactions(tx *bbolt.Tx, merchantId uuid.UUID, fn func(Transaction) error) error {
+	transactions := tx.Bucket(bucketTransactions)
+	prefix := merchantId[:]
+
+	c := tx.Bucket(bucketTransactionsByMerchant).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		t := Transaction{}
+		found, err := get(transactions, k[len(prefix):], &t)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toModelMerchant adds the sum of the approved charges
+func toModelMerchant(tx *bbolt.Tx, merchant Merchant) (model.Merchant, error) {
+	var amount int64
+
+	err := merchantTransactions(tx, merchant.Id, func(t Transaction) error {
+		if t.Type == model.TransactionTypeCharge && t.Status == model.TransactionStatusApproved {
+			amount += t.Amount
+		}
+		return nil
+	})
+	if err != nil {
+		return model.Merchant{}, err
+	}
+
+	return merchant.toModel(amount), nil
+}
+
+func get(b *bbolt.Bucket, key []byte, v any) (bool, error) {
+	data := b.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func put(b *bbolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
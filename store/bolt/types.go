@@ -0,0 +1,193 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// Records are stored as JSON under an 8 byte big-endian sequence key, so
+// iterating a bucket returns them in creation order. The index buckets
+// map to those keys:
+//
+//	merchants_by_uuid           merchant uuid -> merchant key
+//	transactions_by_uuid        transaction uuid -> transaction key
+//	transactions_by_merchant    merchant uuid + transaction key -> nil
+//	transactions_by_parent      parent uuid + transaction key -> nil
+//	transactions_by_created_at  created at + transaction key -> nil
+//	erasures_by_subject         erasure subject -> erasure key
+//
+// users maps every user email, also of deleted merchants, to its owner.
+// audit_log holds the audit entries under their sequence.
+var (
+	bucketUsers        = []byte("users")
+	bucketAdmins       = []byte("admins")
+	bucketMerchants    = []byte("merchants")
+	bucketTransactions = []byte("transactions")
+	bucketErasures     = []byte("erasures")
+	bucketAuditLog     = []byte("audit_log")
+
+	bucketMerchantsByUuid         = []byte("merchants_by_uuid")
+	bucketTransactionsByUuid      = []byte("transactions_by_uuid")
+	bucketTransactionsByMerchant  = []byte("transactions_by_merchant")
+	bucketTransactionsByParent    = []byte("transactions_by_parent")
+	bucketTransactionsByCreatedAt = []byte("transactions_by_created_at")
+	bucketErasuresBySubject       = []byte("erasures_by_subject")
+
+	buckets = [][]byte{
+		bucketUsers,
+		bucketAdmins,
+		bucketMerchants,
+		bucketTransactions,
+		bucketMerchantsByUuid,
+		bucketTransactionsByUuid,
+		bucketTransactionsByMerchant,
+		bucketTransactionsByParent,
+		bucketTransactionsByCreatedAt,
+		bucketErasures,
+		bucketErasuresBySubject,
+		bucketAuditLog,
+	}
+)
+
+type User struct {
+	Role string    `json:"role"`
+	Id   uuid.UUID `json:"id"`
+}
+
+type Admin struct {
+	Id          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Email       string    `json:"email"`
+}
+
+type Merchant struct {
+	Id          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Email       string     `json:"email"`
+	Status      string     `json:"status"`
+	Version     int64      `json:"version"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+
+	StatusReason    string    `json:"status_reason,omitempty"`
+	StatusChangedAt time.Time `json:"status_changed_at"`
+}
+
+func (m Merchant) toModel(amount int64) model.Merchant {
+	return model.Merchant{
+		Id:                 m.Id,
+		Name:               m.Name,
+		Description:        m.Description,
+		Email:              m.Email,
+		Status:             m.Status,
+		StatusReason:       m.StatusReason,
+		StatusChangedAt:    m.StatusChangedAt,
+		TransactionsAmount: amount,
+		Version:            m.Version,
+	}
+}
+
+type Transaction struct {
+	Id            uuid.UUID `json:"id"`
+	ParentId      uuid.UUID `json:"parent_id"`
+	MerchantId    uuid.UUID `json:"merchant_id"`
+	Type          string    `json:"type"`
+	Amount        int64     `json:"amount"`
+	Status        string    `json:"status"`
+	CustomerEmail string    `json:"customer_email"`
+	CustomerPhone string    `json:"customer_phone"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (t Transaction) toModel() model.Transaction {
+	return model.Transaction{
+		Id:            t.Id,
+		ParentId:      t.ParentId,
+		MerchantId:    t.MerchantId,
+		Type:          t.Type,
+		Amount:        t.Amount,
+		Status:        t.Status,
+		CustomerEmail: t.CustomerEmail,
+		CustomerPhone: t.CustomerPhone,
+		CreatedAt:     t.CreatedAt,
+	}
+}
+
+type Erasure struct {
+	Id           uuid.UUID `json:"id"`
+	Subject      string    `json:"subject"`
+	Transactions int       `json:"transactions"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (e Erasure) toModel() model.Erasure {
+	return model.Erasure{
+		Id:           e.Id,
+		Subject:      e.Subject,
+		Transactions: e.Transactions,
+		CreatedAt:    e.CreatedAt,
+		UpdatedAt:    e.UpdatedAt,
+	}
+}
+
+type AuditEntry struct {
+	Sequence      int64               `json:"sequence"`
+	CreatedAt     time.Time           `json:"created_at"`
+	Actor         string              `json:"actor"`
+	Authenticated bool                `json:"authenticated"`
+	RequestId     string              `json:"request_id"`
+	Action        string              `json:"action"`
+	TargetType    string              `json:"target_type"`
+	TargetId      uuid.UUID           `json:"target_id"`
+	Changes       []model.AuditChange `json:"changes"`
+	PrevHash      string              `json:"prev_hash"`
+	Hash          string              `json:"hash"`
+}
+
+func (e AuditEntry) toModel() model.AuditEntry {
+	return model.AuditEntry{
+		Sequence:      e.Sequence,
+		CreatedAt:     e.CreatedAt,
+		Actor:         e.Actor,
+		Authenticated: e.Authenticated,
+		RequestId:     e.RequestId,
+		Action:        e.Action,
+		TargetType:    e.TargetType,
+		TargetId:      e.TargetId,
+		Changes:       e.Changes,
+		PrevHash:      e.PrevHash,
+		Hash:          e.Hash,
+	}
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// timeKeySize is the length of a timeKey
+const timeKeySize = 12
+
+// timeKey orders times as bytes: the Unix seconds with the sign bit flipped,
+// so times before 1970 come first, followed by the nanoseconds. Unlike
+// UnixNano it holds every time, also the zero one.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, timeKeySize)
+	binary.BigEndian.PutUint64(key, uint64(t.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(key[8:], uint32(t.Nanosecond()))
+	return key
+}
+
+// indexKey joins the indexed value and the record key
+func indexKey(prefix []byte, key []byte) []byte {
+	result := make([]byte, 0, len(prefix)+len(key))
+	result = append(result, prefix...)
+	return append(result, key...)
+}
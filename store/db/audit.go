@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// AppendAudit adds e to the end of the audit log. The sequence is the
+// primary key, so of two concurrent appends after the same entry one fails
+// instead of forking the chain.
+func (s *sqLiteDb) AppendAudit(ctx context.Context, e model.AuditEntry) (model.AuditEntry, error) {
+	s = s.withContext(ctx)
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		e, err = s.appendAudit(tx, e)
+		return err
+	})
+	if err != nil {
+		return model.AuditEntry{}, err
+	}
+
+	return e, nil
+}
+
+// transaction runs fn in a transaction. When the changes made in ctx are
+// audited fn gets the auditor and auditMu is held, so fn can append the
+// entries of its changes with appendAudit.
+func (s *sqLiteDb) transaction(ctx context.Context, fn func(tx *gorm.DB, auditor *model.Auditor) error) error {
+	auditor := model.AuditorFrom(ctx)
+	if auditor != nil {
+		s.auditMu.Lock()
+		defer s.auditMu.Unlock()
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(tx, auditor)
+	})
+}
+
+// appendAudit adds e to the end of the audit log in tx, auditMu must be held
+func (s *sqLiteDb) appendAudit(tx *gorm.DB, e model.AuditEntry) (model.AuditEntry, error) {
+	var prev *model.AuditEntry
+
+	last := []AuditEntry{}
+	if err := tx.Select("sequence", "hash").Order("sequence DESC").Limit(1).Find(&last).Error; err != nil {
+		return e, err
+	}
+	if len(last) != 0 {
+		prev = &model.AuditEntry{Sequence: last[0].Sequence, Hash: last[0].Hash}
+	}
+
+	e.CreatedAt = s.clock().UTC()
+	e = e.Seal(prev)
+
+	changes, err := s.encodeAuditChanges(e.Changes)
+	if err != nil {
+		return e, err
+	}
+
+	return e, tx.Create(&AuditEntry{
+		Sequence:      e.Sequence,
+		CreatedAt:     e.CreatedAt,
+		Actor:         e.Actor,
+		Authenticated: e.Authenticated,
+		RequestId:     e.RequestId,
+		Action:        e.Action,
+		TargetType:    e.TargetType,
+		TargetId:      e.TargetId,
+		Changes:       changes,
+		PrevHash:      e.PrevHash,
+		Hash:          e.Hash,
+	}).Error
+}
+
+func (s *sqLiteDb) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	s = s.withContext(ctx)
+
+	conditions := []string{}
+	args := []any{}
+
+	if query.Actor != "" {
+		conditions = append(conditions, "actor = @actor")
+		args = append(args, sql.Named("actor", query.Actor))
+	}
+	if query.Action != "" {
+		conditions = append(conditions, "action = @action")
+		args = append(args, sql.Named("action", query.Action))
+	}
+	if query.TargetId != uuid.Nil {
+		conditions = append(conditions, "target_id = @target_id")
+		args = append(args, sql.Named("target_id", query.TargetId))
+	}
+	if query.Since != nil {
+		conditions = append(conditions, "created_at >= @since")
+		args = append(args, sql.Named("since", query.Since.UTC()))
+	}
+	if query.Until != nil {
+		conditions = append(conditions, "created_at < @until")
+		args = append(args, sql.Named("until", query.Until.UTC()))
+	}
+
+	db := s.db
+	if len(conditions) != 0 {
+		db = db.Where(strings.Join(conditions, " AND "), args...)
+	}
+
+	records := []AuditEntry{}
+	if err := db.Order("sequence").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	entries := []model.AuditEntry{}
+	for _, r := range records {
+		changes, err := s.decodeAuditChanges(r.Changes)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, model.AuditEntry{
+			Sequence:      r.Sequence,
+			CreatedAt:     r.CreatedAt,
+			Actor:         r.Actor,
+			Authenticated: r.Authenticated,
+			RequestId:     r.RequestId,
+			Action:        r.Action,
+			TargetType:    r.TargetType,
+			TargetId:      r.TargetId,
+			Changes:       changes,
+			PrevHash:      r.PrevHash,
+			Hash:          r.Hash,
+		})
+	}
+
+	return entries, nil
+}
+
+// the personal values of the changes are HMAC tokens already, but free text
+// such as a status reason may still name a person, so the changes are
+// encrypted like the other personal data. The hash covers the plain changes,
+// so entries written with an older key stay valid and are not re-encrypted.
+func (s *sqLiteDb) encodeAuditChanges(changes []model.AuditChange) (string, error) {
+	if changes == nil {
+		changes = []model.AuditChange{}
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return "", err
+	}
+	return s.encrypt(string(data))
+}
+
+func (s *sqLiteDb) decodeAuditChanges(value string) ([]model.AuditChange, error) {
+	data, err := s.decrypt(value)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []model.AuditChange{}
+	return changes, json.Unmarshal([]byte(data), &changes)
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func appendAudit(t *testing.T, s *sqLiteDb, n int) {
+	for i := 0; i < n; i++ {
+		_, err := s.AppendAudit(ctx, model.AuditEntry{
+			Actor:      "ops",
+			Action:     model.AuditActionMerchantUpdate,
+			TargetType: model.AuditTargetMerchant,
+			TargetId:   uuid.New(),
+			Changes:    []model.AuditChange{{Field: "email", Before: "old@example.com", After: "new@example.com"}},
+		})
+		require.NoError(t, err)
+	}
+}
+
+func verifyAudit(t *testing.T, s *sqLiteDb) model.AuditVerification {
+	entries, err := s.GetAuditEntries(ctx, model.AuditQuery{})
+	require.NoError(t, err)
+	return model.VerifyAuditChain(entries)
+}
+
+func TestAuditLogAppendOnly(t *testing.T) {
+	s, err := NewDb(tempSettings(t), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	appendAudit(t, s, 3)
+	assert.True(t, verifyAudit(t, s).Valid())
+
+	err = s.Db().Exec("UPDATE `audit_log` SET `actor` = 'someone else' WHERE `sequence` = 2").Error
+	assert.ErrorContains(t, err, "append-only")
+	err = s.Db().Exec("DELETE FROM `audit_log` WHERE `sequence` = 2").Error
+	assert.ErrorContains(t, err, "append-only")
+
+	// tampering past the triggers breaks the chain
+	require.NoError(t, s.Db().Exec("DROP TRIGGER `audit_log_no_update`").Error)
+	require.NoError(t, s.Db().Exec("DROP TRIGGER `audit_log_no_delete`").Error)
+
+	require.NoError(t, s.Db().Exec("UPDATE `audit_log` SET `actor` = 'someone else' WHERE `sequence` = 2").Error)
+	v := verifyAudit(t, s)
+	require.Len(t, v.Breaks, 1)
+	assert.Equal(t, int64(2), v.Breaks[0].Sequence)
+
+	require.NoError(t, s.Db().Exec("DELETE FROM `audit_log` WHERE `sequence` = 2").Error)
+	v = verifyAudit(t, s)
+	require.Len(t, v.Breaks, 1)
+	assert.Equal(t, int64(3), v.Breaks[0].Sequence)
+}
+
+func TestAuditLogEncrypted(t *testing.T) {
+	settings := tempSettings(t)
+	settings.KeyringFile = filepath.Join(t.TempDir(), "keyring.json")
+
+	k, err := keyring.New()
+	require.NoError(t, err)
+	require.NoError(t, k.Save(settings.KeyringFile))
+
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+	appendAudit(t, s, 1)
+	require.NoError(t, s.Close())
+
+	// entries of an older key stay valid after a rotation
+	_, err = k.Rotate()
+	require.NoError(t, err)
+	require.NoError(t, k.Save(settings.KeyringFile))
+
+	s, err = NewDb(settings, nil)
+	require.NoError(t, err)
+	defer s.Close()
+	appendAudit(t, s, 1)
+
+	records := []AuditEntry{}
+	require.NoError(t, s.Db().Order("sequence").Find(&records).Error)
+	require.Len(t, records, 2)
+	assert.True(t, strings.HasPrefix(records[0].Changes, "enc:v1:"), records[0].Changes)
+	assert.True(t, strings.HasPrefix(records[1].Changes, "enc:v2:"), records[1].Changes)
+
+	v := verifyAudit(t, s)
+	assert.True(t, v.Valid(), v.Breaks)
+	assert.Equal(t, 2, v.Entries)
+}
+
+func TestAuditedChangeRolledBack(t *testing.T) {
+	s, err := NewDb(tempSettings(t), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	m, err := s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Db().Exec("CREATE TRIGGER `audit_log_down` BEFORE INSERT ON `audit_log` BEGIN SELECT RAISE(ABORT, 'audit log down'); END").Error)
+
+	audited := model.WithAuditor(ctx, model.Auditor{Actor: model.Actor{Name: "ops"}})
+
+	name := "renamed"
+	_, err = s.UpdateMerchant(audited, model.MerchantPatch{Id: m.Id, Name: &name})
+	assert.ErrorContains(t, err, "audit log down")
+
+	_, err = s.CreateMerchant(audited, model.Merchant{Name: "name", Email: "other@example.com", Status: model.MerchantStatusActive})
+	assert.ErrorContains(t, err, "audit log down")
+
+	// the changes are not committed without their entries
+	actual, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, m.Name, actual.Name)
+	assert.Equal(t, m.Version, actual.Version)
+
+	merchants, err := s.GetMerchants(ctx, model.MerchantQuery{})
+	require.NoError(t, err)
+	assert.Len(t, merchants, 1)
+}
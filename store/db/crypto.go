@@ -0,0 +1,222 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// ErrNoKeyring is returned when encrypted data is read without a keyring
+var ErrNoKeyring = errors.New("personal data is encrypted but no keyring is configured")
+
+// With a keyring the user emails and the customer emails and phones are
+// stored encrypted, together with blind indexes of the emails for lookups.
+// Without one they are stored in plain text and the indexes are empty.
+// Values in plain text are always readable, the re-encryption job encrypts
+// them and those encrypted with an older key.
+
+func (s *sqLiteDb) encrypt(value string) (string, error) {
+	if s.keyring == nil {
+		return value, nil
+	}
+	return s.keyring.Encrypt(value)
+}
+
+func (s *sqLiteDb) decrypt(value string) (string, error) {
+	if s.keyring == nil {
+		if keyring.Version(value) != 0 {
+			return "", ErrNoKeyring
+		}
+		return value, nil
+	}
+	return s.keyring.Decrypt(value)
+}
+
+// blindIndex is nil without a keyring
+func (s *sqLiteDb) blindIndex(value string) *string {
+	if s.keyring == nil {
+		return nil
+	}
+	index := s.keyring.Index(value)
+	return &index
+}
+
+// encryptUser encrypts the user email and sets its index
+func (s *sqLiteDb) encryptUser(u *User) error {
+	email := u.Email
+
+	var err error
+	if u.Email, err = s.encrypt(email); err != nil {
+		return err
+	}
+	u.EmailIndex = s.blindIndex(email)
+
+	return nil
+}
+
+// encryptTransaction encrypts the customer data of t and sets the index of
+// the normalized customer email
+func (s *sqLiteDb) encryptTransaction(t *Transaction) error {
+	email := t.CustomerEmail
+
+	var err error
+	if t.CustomerEmail, err = s.encrypt(email); err != nil {
+		return err
+	}
+	if t.CustomerPhone, err = s.encrypt(t.CustomerPhone); err != nil {
+		return err
+	}
+	t.CustomerEmailIndex = s.blindIndex(model.NormalizeCustomerEmail(email))
+
+	return nil
+}
+
+// decryptTransaction decrypts the customer data of t in place
+func (s *sqLiteDb) decryptTransaction(t *Transaction) error {
+	var err error
+	if t.CustomerEmail, err = s.decrypt(t.CustomerEmail); err != nil {
+		return err
+	}
+	t.CustomerPhone, err = s.decrypt(t.CustomerPhone)
+	return err
+}
+
+// indexUsers fills the missing email indexes, they keep the emails unique
+// while some are still in plain text
+func (s *sqLiteDb) indexUsers() error {
+	if s.keyring == nil {
+		return nil
+	}
+
+	users := []User{}
+	if err := s.db.Unscoped().Select("id", "email").Where("email_index IS NULL").Find(&users).Error; err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, u := range users {
+			email, err := s.decrypt(u.Email)
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&User{}).Where("id = ?", u.ID).UpdateColumn("email_index", s.blindIndex(email)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keyring is the keyring personal data is encrypted with, nil without one
+func (s *sqLiteDb) Keyring() *keyring.Keyring {
+	return s.keyring
+}
+
+// KeyVersion is the version of the key new personal data is encrypted
+// with, zero without a keyring
+func (s *sqLiteDb) KeyVersion() int {
+	if s.keyring == nil {
+		return 0
+	}
+	return s.keyring.Current()
+}
+
+const (
+	pendingUsers        = "email NOT LIKE @prefix OR email_index IS NULL"
+	pendingTransactions = "customer_email NOT LIKE @prefix OR customer_phone NOT LIKE @prefix OR customer_email_index IS NULL"
+)
+
+// PendingReencryption counts the users and transactions, also deleted
+// ones, whose personal data is not encrypted with the current key
+func (s *sqLiteDb) PendingReencryption() (int, error) {
+	if s.keyring == nil {
+		return 0, nil
+	}
+
+	prefix := map[string]any{"prefix": keyring.Prefix(s.keyring.Current()) + "%"}
+
+	var users, transactions int64
+	if err := s.db.Unscoped().Model(&User{}).Where(pendingUsers, prefix).Count(&users).Error; err != nil {
+		return 0, err
+	}
+	if err := s.db.Unscoped().Model(&Transaction{}).Where(pendingTransactions, prefix).Count(&transactions).Error; err != nil {
+		return 0, err
+	}
+
+	return int(users + transactions), nil
+}
+
+// ReencryptBatch encrypts the personal data of up to limit pending users
+// and transactions with the current key and returns how many it changed.
+// The updated at times are left as they are.
+func (s *sqLiteDb) ReencryptBatch(limit int) (int, error) {
+	if s.keyring == nil {
+		return 0, nil
+	}
+
+	prefix := map[string]any{"prefix": keyring.Prefix(s.keyring.Current()) + "%"}
+	done := 0
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		users := []User{}
+		err := tx.Unscoped().Select("id", "email").Where(pendingUsers, prefix).Order("id").Limit(limit).Find(&users).Error
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			if u.Email, err = s.decrypt(u.Email); err != nil {
+				return err
+			}
+			if err := s.encryptUser(&u); err != nil {
+				return err
+			}
+			err := tx.Unscoped().Model(&User{}).Where("id = ?", u.ID).UpdateColumns(map[string]any{
+				"email":       u.Email,
+				"email_index": u.EmailIndex,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		done = len(users)
+
+		if done == limit {
+			return nil
+		}
+
+		transactions := []Transaction{}
+		err = tx.Unscoped().Select("id", "customer_email", "customer_phone").Where(pendingTransactions, prefix).Order("id").Limit(limit - done).Find(&transactions).Error
+		if err != nil {
+			return err
+		}
+
+		for _, t := range transactions {
+			if err := s.decryptTransaction(&t); err != nil {
+				return err
+			}
+			if err := s.encryptTransaction(&t); err != nil {
+				return err
+			}
+			err := tx.Unscoped().Model(&Transaction{}).Where("id = ?", t.ID).UpdateColumns(map[string]any{
+				"customer_email":       t.CustomerEmail,
+				"customer_phone":       t.CustomerPhone,
+				"customer_email_index": t.CustomerEmailIndex,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		done += len(transactions)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return done, nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// rawPersonalData returns the stored user emails and customer data
+func rawPersonalData(t *testing.T, s *sqLiteDb) []string {
+	values := []string{}
+
+	users := []User{}
+	require.NoError(t, s.Db().Unscoped().Find(&users).Error)
+	for _, u := range users {
+		values = append(values, u.Email)
+	}
+
+	transactions := []Transaction{}
+	require.NoError(t, s.Db().Unscoped().Find(&transactions).Error)
+	for _, tr := range transactions {
+		values = append(values, tr.CustomerEmail, tr.CustomerPhone)
+	}
+
+	return values
+}
+
+func reencryptAll(t *testing.T, s *sqLiteDb) int {
+	total := 0
+	for {
+		done, err := s.ReencryptBatch(2)
+		require.NoError(t, err)
+		if done == 0 {
+			return total
+		}
+		total += done
+	}
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	settings := tempSettings(t)
+	settings.KeyringFile = filepath.Join(t.TempDir(), "keyring.json")
+
+	// data written before encryption was enabled
+	plain, err := NewDb(model.StoreSettings{DbPath: settings.DbPath}, nil)
+	require.NoError(t, err)
+
+	merchant, err := plain.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+
+	authorize, err := plain.CreateTransaction(ctx, model.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@example.com",
+		CustomerPhone: "+359888123456",
+	})
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+
+	k, err := keyring.New()
+	require.NoError(t, err)
+	require.NoError(t, k.Save(settings.KeyringFile))
+
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+
+	// plain text stays readable and keeps the email unique
+	_, err = s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	assert.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+
+	actual, err := s.GetTransaction(ctx, authorize.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "customer@example.com", actual.CustomerEmail)
+
+	pending, err := s.PendingReencryption()
+	require.NoError(t, err)
+	assert.Equal(t, 2, pending)
+
+	assert.Equal(t, 2, reencryptAll(t, s))
+
+	for _, value := range rawPersonalData(t, s) {
+		assert.True(t, strings.HasPrefix(value, "enc:v1:"), value)
+	}
+
+	actual, err = s.GetTransaction(ctx, authorize.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "customer@example.com", actual.CustomerEmail)
+	assert.Equal(t, "+359888123456", actual.CustomerPhone)
+
+	m, err := s.GetMerchant(ctx, merchant.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "merchant@example.com", m.Email)
+
+	// new data is encrypted right away
+	_, err = s.CreateTransaction(ctx, model.Transaction{
+		MerchantId:    merchant.Id,
+		ParentId:      authorize.Id,
+		Type:          model.TransactionTypeCharge,
+		Amount:        100,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@example.com",
+	})
+	require.NoError(t, err)
+
+	pending, err = s.PendingReencryption()
+	require.NoError(t, err)
+	assert.Zero(t, pending)
+	require.NoError(t, s.Close())
+
+	// the data cannot be read without the keyring
+	plain, err = NewDb(model.StoreSettings{DbPath: settings.DbPath}, nil)
+	require.NoError(t, err)
+	_, err = plain.GetTransaction(ctx, authorize.Id)
+	assert.ErrorIs(t, err, ErrNoKeyring)
+	require.NoError(t, plain.Close())
+}
+
+func TestKeyRotation(t *testing.T) {
+	settings := tempSettings(t)
+	settings.KeyringFile = filepath.Join(t.TempDir(), "keyring.json")
+
+	k, err := keyring.New()
+	require.NoError(t, err)
+	require.NoError(t, k.Save(settings.KeyringFile))
+
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+
+	merchant, err := s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := s.CreateTransaction(ctx, model.Transaction{
+			MerchantId:    merchant.Id,
+			Type:          model.TransactionTypeAuthorize,
+			Amount:        100,
+			Status:        model.TransactionStatusApproved,
+			CustomerEmail: "customer@example.com",
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+
+	version, err := k.Rotate()
+	require.NoError(t, err)
+	require.NoError(t, k.Save(settings.KeyringFile))
+
+	s, err = NewDb(settings, nil)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, version, s.KeyVersion())
+
+	pending, err := s.PendingReencryption()
+	require.NoError(t, err)
+	assert.Equal(t, 4, pending)
+
+	// lookups by email keep working during the rotation
+	done, err := s.ReencryptBatch(2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, done)
+
+	erasure, erased, err := s.EraseCustomer(ctx, model.CustomerErasure{
+		Email:         "customer@example.com",
+		Pseudonymizer: model.NewPseudonymizer(strings.Repeat("k", 32)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, erased)
+	assert.NotEqual(t, uuid.Nil, erasure.Id)
+
+	reencryptAll(t, s)
+
+	for _, value := range rawPersonalData(t, s) {
+		assert.True(t, strings.HasPrefix(value, "enc:v2:"), value)
+	}
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	for _, tr := range transactions {
+		assert.True(t, model.IsErasedEmail(tr.CustomerEmail), tr.CustomerEmail)
+	}
+}
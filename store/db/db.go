@@ -1,54 +1,162 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"errors"
-	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
+	"github.com/ivaylo-todorov/payment-system/keyring"
 	"github.com/ivaylo-todorov/payment-system/model"
 )
 
-func NewDb(settings model.StoreSettings) (*sqLiteDb, error) {
+const DefaultPath = "payment_system.db"
+
+// Open opens the database without touching its schema
+func Open(settings model.StoreSettings) (*gorm.DB, error) {
 	gormConfig := &gorm.Config{}
 
 	if settings.ShowSQLQueries {
 		gormConfig.Logger = logger.Default.LogMode(logger.Info)
 	}
 
-	db, err := gorm.Open(sqlite.Open("payment_system.db"), gormConfig)
+	path := settings.DbPath
+	if path == "" {
+		path = DefaultPath
+	}
+
+	// the driver enables the foreign keys on every connection of the pool,
+	// a PRAGMA would reach only the one it runs on
+	dsn := path + "?_foreign_keys=on"
+	if strings.Contains(path, "?") {
+		dsn = path + "&_foreign_keys=on"
+	}
+
+	db, err := gorm.Open(sqlite.Open(dsn), gormConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(Admin{}, &Merchant{}, &User{}, &Transaction{})
+	if err := registerTracing(db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// NewDb opens the database and applies pending migrations. It refuses
+// a schema migrated by a newer binary. With a keyring file personal data
+// is encrypted. The timestamps it writes come from clock, nil means
+// time.Now.
+func NewDb(settings model.StoreSettings, clock model.Clock) (*sqLiteDb, error) {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	var k *keyring.Keyring
+	if settings.KeyringFile != "" {
+		var err error
+		if k, err = keyring.Load(settings.KeyringFile); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := Open(settings)
 	if err != nil {
 		return nil, err
 	}
+	// gorm sets created_at, updated_at and deleted_at with NowFunc, in UTC
+	// so that the times compared as text keep their order across offsets
+	db.NowFunc = func() time.Time {
+		return clock().UTC()
+	}
+
+	path := settings.DbPath
+	if path == "" {
+		path = DefaultPath
+	}
+
+	s := &sqLiteDb{
+		db:      db,
+		path:    path,
+		clock:   clock,
+		keyring: k,
+		auditMu: &sync.Mutex{},
+	}
 
-	res := db.Exec("PRAGMA foreign_keys = ON", nil)
-	if res.Error != nil {
+	version, err := SchemaVersion(db)
+	if err == nil && version > LatestVersion() {
+		err = ErrSchemaAhead{Schema: version, Latest: LatestVersion()}
+	}
+	if err == nil {
+		err = MigrateUp(db)
+	}
+	if err == nil {
+		err = s.indexUsers()
+	}
+	if err != nil {
+		s.Close()
 		return nil, err
 	}
 
-	return &sqLiteDb{
-		db: db,
-	}, nil
+	return s, nil
 }
 
 type sqLiteDb struct {
-	db *gorm.DB
+	db    *gorm.DB
+	path  string
+	clock model.Clock
+	// keyring is nil when personal data is stored in plain text
+	keyring *keyring.Keyring
+
+	// auditMu serializes the appends to the audit log, it is shared by
+	// the copies of withContext
+	auditMu *sync.Mutex
+}
+
+// withContext returns a copy of s running its statements in ctx, so they
+// are canceled with it and traced as part of it
+func (s *sqLiteDb) withContext(ctx context.Context) *sqLiteDb {
+	c := *s
+	c.db = s.db.WithContext(ctx)
+	return &c
+}
+
+// withTx returns a copy of s running its statements in tx
+func (s *sqLiteDb) withTx(tx *gorm.DB) *sqLiteDb {
+	c := *s
+	c.db = tx
+	return &c
 }
 
 func (s *sqLiteDb) Db() *gorm.DB {
 	return s.db
 }
 
-func (s *sqLiteDb) CreateAdmin(a model.Admin) (model.Admin, error) {
-	txFunc := func(tx *gorm.DB) error {
+// SqlDb is the connection pool of the database
+func (s *sqLiteDb) SqlDb() (*sql.DB, error) {
+	return s.db.DB()
+}
+
+func (s *sqLiteDb) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func (s *sqLiteDb) CreateAdmin(ctx context.Context, a model.Admin) (model.Admin, error) {
+	s = s.withContext(ctx)
+
+	txFunc := func(tx *gorm.DB, auditor *model.Auditor) error {
 
 		user := User{
 			Role:        model.UserRoleAdmin,
@@ -57,10 +165,14 @@ func (s *sqLiteDb) CreateAdmin(a model.Admin) (model.Admin, error) {
 			Email:       a.Email,
 		}
 
-		if err := tx.Create(&user).Error; err != nil {
+		if err := s.encryptUser(&user); err != nil {
 			return err
 		}
 
+		if err := tx.Create(&user).Error; err != nil {
+			return translateError(err)
+		}
+
 		admin := Admin{
 			UserID: user.ID,
 		}
@@ -71,48 +183,103 @@ func (s *sqLiteDb) CreateAdmin(a model.Admin) (model.Admin, error) {
 
 		a.Id = admin.AdminId
 
+		if auditor != nil {
+			if _, err := s.appendAudit(tx, auditor.AdminCreated(a)); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	}
 
-	return a, s.db.Transaction(txFunc)
+	return a, s.transaction(ctx, txFunc)
 }
 
-func (s *sqLiteDb) CreateMerchant(m model.Merchant) (model.Merchant, error) {
-	txFunc := func(tx *gorm.DB) error {
+func (s *sqLiteDb) CreateMerchant(ctx context.Context, m model.Merchant) (model.Merchant, error) {
+	s = s.withContext(ctx)
 
-		user := User{
-			Role:        model.UserRoleMerchant,
-			Name:        m.Name,
-			Description: m.Description,
-			Email:       m.Email,
-		}
+	txFunc := func(tx *gorm.DB, auditor *model.Auditor) error {
+		var err error
+		m, err = s.createMerchant(tx, auditor, m)
+		return err
+	}
 
-		if err := tx.Create(&user).Error; err != nil {
-			return err
-		}
+	return m, s.transaction(ctx, txFunc)
+}
 
-		merchant := Merchant{
-			UserID: user.ID,
-			Status: m.Status,
-		}
+// CreateMerchants creates all merchants in a single transaction,
+// nothing is created if any of them fails
+func (s *sqLiteDb) CreateMerchants(ctx context.Context, input []model.Merchant) ([]model.Merchant, error) {
+	s = s.withContext(ctx)
 
-		if err := tx.Create(&merchant).Error; err != nil {
-			return err
+	result := []model.Merchant{}
+
+	txFunc := func(tx *gorm.DB, auditor *model.Auditor) error {
+		for n, i := range input {
+			m, err := s.createMerchant(tx, auditor, i)
+			if err != nil {
+				return model.ErrorAtRow(err, n+1)
+			}
+			result = append(result, m)
 		}
+		return nil
+	}
+
+	if err := s.transaction(ctx, txFunc); err != nil {
+		return []model.Merchant{}, err
+	}
 
-		m.Id = merchant.MerchantId
-		m.Status = merchant.Status
+	return result, nil
+}
 
-		return nil
+func (s *sqLiteDb) createMerchant(tx *gorm.DB, auditor *model.Auditor, m model.Merchant) (model.Merchant, error) {
+	user := User{
+		Role:        model.UserRoleMerchant,
+		Name:        m.Name,
+		Description: m.Description,
+		Email:       m.Email,
+	}
+
+	if err := s.encryptUser(&user); err != nil {
+		return m, err
+	}
+
+	if err := tx.Create(&user).Error; err != nil {
+		return m, translateError(err)
+	}
+
+	merchant := Merchant{
+		UserID:          user.ID,
+		Status:          m.Status,
+		StatusChangedAt: tx.NowFunc(),
+		Version:         1,
+	}
+
+	if err := tx.Create(&merchant).Error; err != nil {
+		return m, err
+	}
+
+	m.Id = merchant.MerchantId
+	m.Status = merchant.Status
+	m.StatusReason = ""
+	m.StatusChangedAt = merchant.StatusChangedAt
+	m.Version = merchant.Version
+
+	if auditor != nil {
+		if _, err := s.appendAudit(tx, auditor.Merchant(model.AuditActionMerchantCreate, model.Merchant{}, m)); err != nil {
+			return m, err
+		}
 	}
 
-	return m, s.db.Transaction(txFunc)
+	return m, nil
 }
 
-func (s *sqLiteDb) UpdateMerchant(m model.Merchant) (model.Merchant, error) {
+func (s *sqLiteDb) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (model.Merchant, error) {
+	s = s.withContext(ctx)
+
 	merchant := Merchant{}
 
-	result := s.db.Where("merchant_id = ?", m.Id.String()).First(&merchant)
+	result := s.db.Where("merchant_id = ?", p.Id.String()).First(&merchant)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return model.Merchant{}, model.ErrMerchantNotFound
@@ -120,56 +287,124 @@ func (s *sqLiteDb) UpdateMerchant(m model.Merchant) (model.Merchant, error) {
 		return model.Merchant{}, result.Error
 	}
 
-	txFunc := func(tx *gorm.DB) error {
+	after := model.Merchant{}
+
+	txFunc := func(tx *gorm.DB, auditor *model.Auditor) error {
+		// the status is checked against the committed row
+		if err := tx.First(&merchant, merchant.ID).Error; err != nil {
+			return err
+		}
+
+		before, err := s.withTx(tx).getMerchant(merchant.ID)
+		if err != nil {
+			return err
+		}
+
+		// a stale version fails first, the status it was checked against
+		// may have changed since
+		if p.Version != 0 && p.Version != merchant.Version {
+			return model.ErrMerchantVersionMismatch
+		}
+
+		if merchant.Status == model.MerchantStatusClosed {
+			return model.ErrMerchantClosed
+		}
+		if p.Status != nil {
+			if err := model.CheckMerchantTransition(merchant.Status, *p.Status); err != nil {
+				return err
+			}
+		}
+
+		// every update bumps the version, also when only user columns change
+		version := tx.Model(&merchant)
+		if p.Version != 0 {
+			version = version.Where("version = ?", p.Version)
+		}
+		version = version.UpdateColumn("version", gorm.Expr("version + 1"))
+		if version.Error != nil {
+			return version.Error
+		}
+		if version.RowsAffected == 0 {
+			return model.ErrMerchantVersionMismatch
+		}
 
 		user := User{
-			Model:       gorm.Model{ID: merchant.UserID},
-			Name:        m.Name,
-			Description: m.Description,
-			Email:       m.Email,
+			Model: gorm.Model{ID: merchant.UserID},
 		}
 
 		userColumns := []string{}
-		if m.Name != "" {
+		if p.Name != nil {
+			user.Name = *p.Name
 			userColumns = append(userColumns, "Name")
 		}
-		if m.Description != "" {
+		if p.Description != nil {
+			user.Description = *p.Description
 			userColumns = append(userColumns, "Description")
 		}
-		if m.Email != "" {
-			userColumns = append(userColumns, "Email")
+		if p.Email != nil {
+			user.Email = *p.Email
+			userColumns = append(userColumns, "Email", "EmailIndex")
 		}
 
-		if err := tx.Model(&user).Select(userColumns).Updates(user).Error; err != nil {
-			return err
+		// closing frees the email
+		if p.Status != nil && *p.Status == model.MerchantStatusClosed {
+			anonymized := model.AnonymizedMerchant(model.Merchant{Id: merchant.MerchantId})
+			user.Name = anonymized.Name
+			user.Description = anonymized.Description
+			user.Email = anonymized.Email
+			userColumns = []string{"Name", "Description", "Email", "EmailIndex"}
 		}
 
-		merchant.Status = m.Status
+		if len(userColumns) != 0 {
+			if err := s.encryptUser(&user); err != nil {
+				return err
+			}
+			if err := tx.Model(&user).Select(userColumns).Updates(user).Error; err != nil {
+				return translateError(err)
+			}
+		}
 
-		merchantColumns := []string{}
-		if m.Status != "" {
-			merchantColumns = append(merchantColumns, "Status")
+		if p.Status != nil {
+			columns := []string{"StatusReason"}
+			if *p.Status != merchant.Status {
+				merchant.Status = *p.Status
+				merchant.StatusChangedAt = tx.NowFunc()
+				columns = append(columns, "Status", "StatusChangedAt")
+			}
+			merchant.StatusReason = ""
+			if p.StatusReason != nil {
+				merchant.StatusReason = *p.StatusReason
+			}
+
+			if err := tx.Model(&merchant).Select(columns).Updates(&merchant).Error; err != nil {
+				return err
+			}
 		}
 
-		if err := tx.Model(&merchant).Select(merchantColumns).Updates(&merchant).Error; err != nil {
+		after, err = s.withTx(tx).getMerchant(merchant.ID)
+		if err != nil {
 			return err
 		}
 
-		return nil
+		if auditor != nil {
+			_, err = s.appendAudit(tx, auditor.Merchant(model.AuditActionMerchantUpdate, before, after))
+		}
+		return err
 	}
 
-	if err := s.db.Transaction(txFunc); err != nil {
+	if err := s.transaction(ctx, txFunc); err != nil {
 		return model.Merchant{}, err
 	}
 
-	return s.getMerchant(merchant.ID)
+	return after, nil
 }
 
-// Merchants are soft deleted. This might cause email conflict
-// TODO:
-//
-//	hard delete or change deleted record email
-func (s *sqLiteDb) DeleteMerchant(id uuid.UUID) error {
+// Merchants are soft deleted and keep their email, closing a merchant
+// instead frees it.
+// A non zero version must match the current merchant version.
+func (s *sqLiteDb) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) error {
+	s = s.withContext(ctx)
+
 	merchant := Merchant{}
 
 	result := s.db.Where("merchant_id = ?", id).First(&merchant)
@@ -180,7 +415,7 @@ func (s *sqLiteDb) DeleteMerchant(id uuid.UUID) error {
 		return result.Error
 	}
 
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	return s.transaction(ctx, func(tx *gorm.DB, auditor *model.Auditor) error {
 
 		var count int64
 		if err := tx.Model(&Transaction{}).Where("merchant_id = ?", merchant.ID).Count(&count).Error; err != nil {
@@ -188,7 +423,17 @@ func (s *sqLiteDb) DeleteMerchant(id uuid.UUID) error {
 		}
 
 		if count != 0 {
-			return fmt.Errorf("cannot delete user with transactions")
+			return model.ErrMerchantHasTransactions
+		}
+
+		if auditor != nil {
+			before, err := s.withTx(tx).getMerchant(merchant.ID)
+			if err != nil {
+				return err
+			}
+			if _, err := s.appendAudit(tx, auditor.Merchant(model.AuditActionMerchantDelete, before, model.Merchant{})); err != nil {
+				return err
+			}
 		}
 
 		user := User{
@@ -199,14 +444,34 @@ func (s *sqLiteDb) DeleteMerchant(id uuid.UUID) error {
 			return err
 		}
 
-		if err := tx.Delete(&merchant).Error; err != nil {
-			return err
+		result := tx.Where("version = ? or ? = 0", version, version).Delete(&merchant)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return model.ErrMerchantVersionMismatch
 		}
 
 		return nil
 	})
 }
 
+func (s *sqLiteDb) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	s = s.withContext(ctx)
+
+	merchant := Merchant{}
+
+	result := s.db.Where("merchant_id = ?", id.String()).First(&merchant)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return model.Merchant{}, model.ErrMerchantNotFound
+		}
+		return model.Merchant{}, result.Error
+	}
+
+	return s.getMerchant(merchant.ID)
+}
+
 func (s *sqLiteDb) getMerchant(id uint) (model.Merchant, error) {
 	var m struct {
 		Merchant
@@ -215,27 +480,36 @@ func (s *sqLiteDb) getMerchant(id uint) (model.Merchant, error) {
 
 	err := s.db.Model(&Merchant{}).Joins("User").
 		Joins(`left join (select merchant_id, sum(amount) as total_transaction_sum from transactions where transactions.type = "charge" and transactions.status = "approved" group by merchant_id) t on merchants.id = t.merchant_id`).
-		Select("merchants.merchant_id, merchants.status, t.total_transaction_sum").First(&m, id).Error
+		Select("merchants.merchant_id, merchants.status, merchants.status_reason, merchants.status_changed_at, merchants.version, t.total_transaction_sum").First(&m, id).Error
 
 	if err != nil {
 		return model.Merchant{}, err
 	}
 
+	email, err := s.decrypt(m.User.Email)
+	if err != nil {
+		return model.Merchant{}, err
+	}
+
 	return model.Merchant{
 		Id:                 m.MerchantId,
 		Name:               m.User.Name,
 		Description:        m.User.Description,
-		Email:              m.User.Email,
+		Email:              email,
 		Status:             m.Status,
+		StatusReason:       m.StatusReason,
+		StatusChangedAt:    m.StatusChangedAt,
 		TransactionsAmount: m.TotalTransactionSum,
+		Version:            m.Version,
 	}, nil
 }
 
-func (s *sqLiteDb) GetMerchants(query model.MerchantQuery) ([]model.Merchant, error) {
+func (s *sqLiteDb) GetMerchants(ctx context.Context, query model.MerchantQuery) ([]model.Merchant, error) {
+	s = s.withContext(ctx)
 
 	rows, err := s.db.Model(&Merchant{}).Joins("User").
 		Joins(`left join (select merchant_id, sum(amount) as total_transaction_sum from transactions where transactions.type = "charge" and transactions.status = "approved" group by merchant_id) t on merchants.id = t.merchant_id`).
-		Select("merchants.merchant_id, merchants.status, t.total_transaction_sum").Rows()
+		Select("merchants.merchant_id, merchants.status, merchants.status_reason, merchants.status_changed_at, merchants.version, t.total_transaction_sum").Rows()
 
 	if err != nil {
 		return nil, err
@@ -255,20 +529,29 @@ func (s *sqLiteDb) GetMerchants(query model.MerchantQuery) ([]model.Merchant, er
 			return merchants, err
 		}
 
+		email, err := s.decrypt(m.User.Email)
+		if err != nil {
+			return merchants, err
+		}
+
 		merchants = append(merchants, model.Merchant{
 			Id:                 m.MerchantId,
 			Name:               m.User.Name,
 			Description:        m.User.Description,
-			Email:              m.User.Email,
+			Email:              email,
 			Status:             m.Status,
+			StatusReason:       m.StatusReason,
+			StatusChangedAt:    m.StatusChangedAt,
 			TransactionsAmount: m.TotalTransactionSum,
+			Version:            m.Version,
 		})
 	}
 
 	return merchants, nil
 }
 
-func (s *sqLiteDb) CreateTransaction(t model.Transaction) (model.Transaction, error) {
+func (s *sqLiteDb) CreateTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
+	s = s.withContext(ctx)
 
 	merchant := Merchant{}
 
@@ -280,27 +563,49 @@ func (s *sqLiteDb) CreateTransaction(t model.Transaction) (model.Transaction, er
 		return model.Transaction{}, result.Error
 	}
 
-	if merchant.Status != model.MerchantStatusActive {
-		return model.Transaction{}, fmt.Errorf("merchant is not active")
+	if err := model.CheckMerchantTransaction(merchant.Status, t.Type); err != nil {
+		return model.Transaction{}, err
 	}
+	t.MerchantStatus = merchant.Status
 
 	if t.Type == model.TransactionTypeAuthorize {
 		return s.createAuthorizeTransaction(merchant.ID, t)
-	} else if t.Type == model.TransactionTypeCharge {
-		return s.createChargeTransaction(merchant.ID, t)
+	}
+
+	if t.Type != model.TransactionTypeCharge && t.Type != model.TransactionTypeRefund && t.Type != model.TransactionTypeReversal {
+		return model.Transaction{}, model.NewValidationError("type", "invalid", "invalid transaction type")
+	}
+
+	parent := Transaction{}
+
+	result = s.db.Select("id").Where("transaction_id = ?", t.ParentId.String()).First(&parent)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return model.Transaction{}, model.ErrParentNotFound
+		}
+		return model.Transaction{}, result.Error
+	}
+
+	if t.Type == model.TransactionTypeCharge {
+		return s.createChargeTransaction(merchant.ID, parent.ID, t)
 	} else if t.Type == model.TransactionTypeRefund {
-		return s.createRefundTransaction(merchant.ID, t)
-	} else if t.Type == model.TransactionTypeReversal {
-		return s.createReversalTransaction(merchant.ID, t)
+		return s.createRefundTransaction(merchant.ID, parent.ID, t)
 	}
+	return s.createReversalTransaction(merchant.ID, parent.ID, t)
+}
 
-	return model.Transaction{}, fmt.Errorf("invalid transaction type")
+// preloadParent loads the parent also when it is deleted
+func preloadParent(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
 }
 
-func (s *sqLiteDb) GetTransaction(id uuid.UUID) (model.Transaction, error) {
+func (s *sqLiteDb) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
+	s = s.withContext(ctx)
+
 	t := Transaction{}
 
-	result := s.db.Where("transaction_id = ?", id.String()).First(&t)
+	result := s.db.Joins("Merchant").Preload("Parent", preloadParent).
+		Where("transactions.transaction_id = ?", id.String()).First(&t)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return model.Transaction{}, model.ErrTransactionNotFound
@@ -308,59 +613,301 @@ func (s *sqLiteDb) GetTransaction(id uuid.UUID) (model.Transaction, error) {
 		return model.Transaction{}, result.Error
 	}
 
+	return s.toModelTransaction(t)
+}
+
+func (s *sqLiteDb) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	s = s.withContext(ctx)
+
+	db := s.db.Joins("Merchant").Preload("Parent", preloadParent)
+	// created_at is kept in UTC like NowFunc sets it and compared as text
+	if query.OlderThan != nil {
+		db = db.Where("transactions.created_at < ?", query.OlderThan.UTC())
+	}
+	if len(query.Ids) != 0 {
+		db = db.Where("transactions.transaction_id IN ?", uuidStrings(query.Ids))
+	}
+	if len(query.ParentIds) != 0 {
+		db = db.Where("transactions.parent_id IN (SELECT id FROM transactions WHERE transaction_id IN ?)", uuidStrings(query.ParentIds))
+	}
+	if query.After != uuid.Nil {
+		after := Transaction{}
+		result := s.db.Unscoped().Select("id").Where("transaction_id = ?", query.After.String()).Limit(1).Find(&after)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, model.ErrTransactionNotFound
+		}
+		db = db.Where("transactions.id > ?", after.ID)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	result := []Transaction{}
+
+	err := db.Order("transactions.id").Find(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []model.Transaction{}
+	for _, t := range result {
+		transaction, err := s.toModelTransaction(t)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, nil
+}
+
+// uuidStrings is ids as they are stored
+func uuidStrings(ids []uuid.UUID) []string {
+	result := []string{}
+	for _, id := range ids {
+		result = append(result, id.String())
+	}
+	return result
+}
+
+func (s *sqLiteDb) toModelTransaction(t Transaction) (model.Transaction, error) {
+	if err := s.decryptTransaction(&t); err != nil {
+		return model.Transaction{}, err
+	}
+
 	return model.Transaction{
 		Id:            t.TransactionId,
-		ParentId:      t.ParentId,
+		ParentId:      t.parentUuid(),
 		MerchantId:    t.Merchant.MerchantId,
 		Type:          t.Type,
 		Amount:        t.Amount,
 		Status:        t.Status,
 		CustomerEmail: t.CustomerEmail,
 		CustomerPhone: t.CustomerPhone,
+		CreatedAt:     t.CreatedAt,
 	}, nil
 }
 
-func (s *sqLiteDb) GetTransactions(query model.TransactionQuery) ([]model.Transaction, error) {
+// DeleteTransactions deletes the transactions selected by query for good.
+// Like the foreign key requires, a transaction is kept while a transaction
+// below it in its chain is kept.
+func (s *sqLiteDb) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	s = s.withContext(ctx)
 
-	rows, err := s.db.Joins("Merchant").Model(&Transaction{}).Rows()
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	conditions := []string{}
+	args := []any{}
 
-	transactions := []model.Transaction{}
+	if query.OlderThan != nil {
+		conditions = append(conditions, "created_at < @older_than")
+		args = append(args, sql.Named("older_than", query.OlderThan.UTC()))
+	}
+	if len(query.Ids) != 0 {
+		conditions = append(conditions, "transaction_id IN @ids")
+		args = append(args, sql.Named("ids", uuidStrings(query.Ids)))
+	}
+	if len(query.ParentIds) != 0 {
+		conditions = append(conditions, "parent_id IN (SELECT id FROM transactions WHERE transaction_id IN @parent_ids)")
+		args = append(args, sql.Named("parent_ids", uuidStrings(query.ParentIds)))
+	}
 
-	var t Transaction
-	for rows.Next() {
-		err = s.db.ScanRows(rows, &t)
-		if err != nil {
-			return transactions, err
-		}
-
-		transactions = append(transactions, model.Transaction{
-			Id:            t.TransactionId,
-			ParentId:      t.ParentId,
-			MerchantId:    t.Merchant.MerchantId,
-			Type:          t.Type,
-			Amount:        t.Amount,
-			Status:        t.Status,
-			CustomerEmail: t.CustomerEmail,
-			CustomerPhone: t.CustomerPhone,
-		})
+	if len(conditions) == 0 {
+		return nil
 	}
+	selected := "(" + strings.Join(conditions, " AND ") + ")"
 
-	return transactions, nil
+	// the foreign key restricts deleting a parent before its children, so
+	// every round deletes the transactions left without children
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for {
+			result := tx.Exec(`WITH RECURSIVE kept(id, parent_id) AS (
+					SELECT id, parent_id FROM transactions WHERE NOT `+selected+`
+					UNION
+					SELECT t.id, t.parent_id FROM transactions t JOIN kept ON t.id = kept.parent_id
+				)
+				DELETE FROM transactions
+				WHERE `+selected+` AND id NOT IN (SELECT id FROM kept)
+				AND id NOT IN (SELECT parent_id FROM transactions WHERE parent_id IS NOT NULL)`, args...)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+		}
+	})
 }
 
-func (s *sqLiteDb) DeleteTransactions(query model.TransactionQuery) error {
-	if query.OlderThan == nil {
+// RestoreTransactions inserts archived transactions as they were, parents
+// before their children. Present transactions are skipped. Either all
+// transactions are restored or none.
+func (s *sqLiteDb) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	s = s.withContext(ctx)
+
+	restored := 0
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, t := range transactions {
+			var present int64
+			if err := tx.Model(&Transaction{}).Where("transaction_id = ?", t.Id.String()).Count(&present).Error; err != nil {
+				return err
+			}
+			if present != 0 {
+				continue
+			}
+
+			merchant := Merchant{}
+			result := tx.Select("id").Where("merchant_id = ?", t.MerchantId.String()).First(&merchant)
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return model.ErrMerchantNotFound
+			}
+			if result.Error != nil {
+				return result.Error
+			}
+
+			transaction := Transaction{
+				Model:         gorm.Model{CreatedAt: t.CreatedAt.UTC()},
+				MerchantID:    merchant.ID,
+				TransactionId: t.Id,
+				Type:          t.Type,
+				Amount:        t.Amount,
+				Status:        t.Status,
+				CustomerEmail: t.CustomerEmail,
+				CustomerPhone: t.CustomerPhone,
+			}
+
+			if err := s.encryptTransaction(&transaction); err != nil {
+				return err
+			}
+
+			if t.ParentId != uuid.Nil {
+				parent := Transaction{}
+				result = tx.Select("id").Where("transaction_id = ?", t.ParentId.String()).First(&parent)
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return model.ErrParentNotFound
+				}
+				if result.Error != nil {
+					return result.Error
+				}
+				transaction.ParentID = &parent.ID
+			}
+
+			if err := tx.Create(&transaction).Error; err != nil {
+				return err
+			}
+			restored++
+		}
+
 		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	err := s.db.Where("created_at < ?", query.OlderThan).Delete(&Transaction{}).Error
+
+	return restored, nil
+}
+
+// EraseCustomer pseudonymizes every transaction of a customer and records
+// the erasure. Repeating it only pseudonymizes the transactions created
+// since.
+func (s *sqLiteDb) EraseCustomer(ctx context.Context, e model.CustomerErasure) (model.Erasure, int, error) {
+	s = s.withContext(ctx)
+
+	result := model.Erasure{}
+	erased := 0
+
+	err := s.transaction(ctx, func(tx *gorm.DB, auditor *model.Auditor) error {
+		email := model.NormalizeCustomerEmail(e.Email)
+
+		// transactions still in plain text have no index
+		matches := tx.Unscoped().Select("id", "customer_email", "customer_phone").
+			Where("customer_email_index IS NULL AND lower(trim(customer_email)) = ? AND customer_email NOT LIKE ?", email, "erased-%@erased.invalid")
+		if index := s.blindIndex(email); index != nil {
+			matches = matches.Or("customer_email_index = ?", *index)
+		}
+
+		transactions := []Transaction{}
+		if err := matches.Find(&transactions).Error; err != nil {
+			return err
+		}
+
+		for _, t := range transactions {
+			if err := s.decryptTransaction(&t); err != nil {
+				return err
+			}
+
+			t.CustomerEmail = e.Pseudonymizer.Email(t.CustomerEmail)
+			t.CustomerPhone = e.Pseudonymizer.Phone(t.CustomerPhone)
+			if err := s.encryptTransaction(&t); err != nil {
+				return err
+			}
+
+			err := tx.Unscoped().Model(&Transaction{}).Where("id = ?", t.ID).Updates(map[string]any{
+				"customer_email":       t.CustomerEmail,
+				"customer_phone":       t.CustomerPhone,
+				"customer_email_index": t.CustomerEmailIndex,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		erased = len(transactions)
+
+		record := Erasure{}
+		subject := e.Pseudonymizer.Subject(email)
+		found := tx.Where("subject = ?", subject).Limit(1).Find(&record)
+		if found.Error != nil {
+			return found.Error
+		}
+
+		before := model.Erasure{}
+		if found.RowsAffected != 0 {
+			before = record.toModel()
+		}
+
+		if found.RowsAffected == 0 {
+			record = Erasure{
+				Subject:      subject,
+				Transactions: erased,
+			}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+		} else if erased != 0 {
+			record.Transactions += erased
+			if err := tx.Save(&record).Error; err != nil {
+				return err
+			}
+		}
+
+		result = record.toModel()
+		if auditor != nil {
+			if _, err := s.appendAudit(tx, auditor.Erasure(before, result)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return model.Erasure{}, 0, err
 	}
-	return nil
+
+	return result, erased, nil
+}
+
+func (s *sqLiteDb) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	s = s.withContext(ctx)
+
+	records := []Erasure{}
+	if err := s.db.Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	erasures := []model.Erasure{}
+	for _, e := range records {
+		erasures = append(erasures, e.toModel())
+	}
+
+	return erasures, nil
 }
 
 func (s *sqLiteDb) createAuthorizeTransaction(merchantId uint, t model.Transaction) (model.Transaction, error) {
@@ -374,21 +921,26 @@ func (s *sqLiteDb) createAuthorizeTransaction(merchantId uint, t model.Transacti
 		CustomerPhone: t.CustomerPhone,
 	}
 
+	if err := s.encryptTransaction(&transaction); err != nil {
+		return model.Transaction{}, err
+	}
+
 	err := s.db.Create(&transaction).Error
 	if err != nil {
 		return model.Transaction{}, err
 	}
 
 	t.Id = transaction.TransactionId
+	t.CreatedAt = transaction.CreatedAt
 
 	return t, nil
 }
 
-func (s *sqLiteDb) createChargeTransaction(merchantId uint, t model.Transaction) (model.Transaction, error) {
+func (s *sqLiteDb) createChargeTransaction(merchantId uint, parentId uint, t model.Transaction) (model.Transaction, error) {
 	transaction := Transaction{
 		MerchantID: merchantId,
+		ParentID:   &parentId,
 
-		ParentId:      t.ParentId,
 		Type:          t.Type,
 		Amount:        t.Amount,
 		Status:        t.Status,
@@ -396,21 +948,26 @@ func (s *sqLiteDb) createChargeTransaction(merchantId uint, t model.Transaction)
 		CustomerPhone: t.CustomerPhone,
 	}
 
+	if err := s.encryptTransaction(&transaction); err != nil {
+		return model.Transaction{}, err
+	}
+
 	err := s.db.Create(&transaction).Error
 	if err != nil {
 		return model.Transaction{}, err
 	}
 
 	t.Id = transaction.TransactionId
+	t.CreatedAt = transaction.CreatedAt
 
 	return t, nil
 }
 
-func (s *sqLiteDb) createRefundTransaction(merchantId uint, t model.Transaction) (model.Transaction, error) {
+func (s *sqLiteDb) createRefundTransaction(merchantId uint, parentId uint, t model.Transaction) (model.Transaction, error) {
 	transaction := Transaction{
 		MerchantID: merchantId,
+		ParentID:   &parentId,
 
-		ParentId:      t.ParentId,
 		Type:          t.Type,
 		Amount:        t.Amount,
 		Status:        t.Status,
@@ -418,6 +975,10 @@ func (s *sqLiteDb) createRefundTransaction(merchantId uint, t model.Transaction)
 		CustomerPhone: t.CustomerPhone,
 	}
 
+	if err := s.encryptTransaction(&transaction); err != nil {
+		return model.Transaction{}, err
+	}
+
 	txFunc := func(tx *gorm.DB) error {
 
 		err := tx.Create(&transaction).Error
@@ -426,13 +987,14 @@ func (s *sqLiteDb) createRefundTransaction(merchantId uint, t model.Transaction)
 		}
 
 		t.Id = transaction.TransactionId
+		t.CreatedAt = transaction.CreatedAt
 
 		// don't update reference transaction on errors
 		if transaction.Status == model.TransactionStatusError {
 			return nil
 		}
 
-		if err := tx.Model(&Transaction{}).Where("transaction_id = ?", t.ParentId).Update("Status", model.TransactionStatusRefunded).Error; err != nil {
+		if err := tx.Model(&Transaction{}).Where("id = ?", parentId).Update("Status", model.TransactionStatusRefunded).Error; err != nil {
 			return err
 		}
 
@@ -442,11 +1004,11 @@ func (s *sqLiteDb) createRefundTransaction(merchantId uint, t model.Transaction)
 	return t, s.db.Transaction(txFunc)
 }
 
-func (s *sqLiteDb) createReversalTransaction(merchantId uint, t model.Transaction) (model.Transaction, error) {
+func (s *sqLiteDb) createReversalTransaction(merchantId uint, parentId uint, t model.Transaction) (model.Transaction, error) {
 	transaction := Transaction{
 		MerchantID: merchantId,
+		ParentID:   &parentId,
 
-		ParentId:      t.ParentId,
 		Type:          t.Type,
 		Amount:        t.Amount,
 		Status:        t.Status,
@@ -454,6 +1016,10 @@ func (s *sqLiteDb) createReversalTransaction(merchantId uint, t model.Transactio
 		CustomerPhone: t.CustomerPhone,
 	}
 
+	if err := s.encryptTransaction(&transaction); err != nil {
+		return model.Transaction{}, err
+	}
+
 	txFunc := func(tx *gorm.DB) error {
 
 		err := tx.Create(&transaction).Error
@@ -462,13 +1028,14 @@ func (s *sqLiteDb) createReversalTransaction(merchantId uint, t model.Transactio
 		}
 
 		t.Id = transaction.TransactionId
+		t.CreatedAt = transaction.CreatedAt
 
 		// don't update reference transaction on errors
 		if transaction.Status == model.TransactionStatusError {
 			return nil
 		}
 
-		if err := tx.Model(&Transaction{}).Where("transaction_id = ?", t.ParentId).Update("Status", model.TransactionStatusReversed).Error; err != nil {
+		if err := tx.Model(&Transaction{}).Where("id = ?", parentId).Update("Status", model.TransactionStatusReversed).Error; err != nil {
 			return err
 		}
 
@@ -477,3 +1044,11 @@ func (s *sqLiteDb) createReversalTransaction(merchantId uint, t model.Transactio
 
 	return t, s.db.Transaction(txFunc)
 }
+
+// translateError maps driver errors to domain errors where possible
+func translateError(err error) error {
+	if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+		return model.ErrEmailAlreadyExists
+	}
+	return err
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"math/rand"
 	"os"
@@ -15,6 +17,8 @@ import (
 
 var db *sqLiteDb
 
+var ctx = context.Background()
+
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func setup() {
@@ -26,7 +30,7 @@ func setup() {
 		}
 	}
 
-	db, err = NewDb(model.StoreSettings{})
+	db, err = NewDb(model.StoreSettings{}, nil)
 	if err != nil {
 		log.Fatalf("Cannot create new db: %s", err.Error())
 	}
@@ -50,7 +54,7 @@ func TestCreateAdmin(t *testing.T) {
 		Email:       RandomString(8),
 	}
 
-	a, err := db.CreateAdmin(expected)
+	a, err := db.CreateAdmin(ctx, expected)
 	require.NoError(t, err)
 
 	assert.NotZero(t, a.Id)
@@ -78,10 +82,10 @@ func TestCreateMerchant(t *testing.T) {
 		Name:        "name",
 		Description: "description",
 		Email:       RandomString(8),
-		Status:      "status",
+		Status:      model.MerchantStatusPending,
 	}
 
-	m, err := db.CreateMerchant(expected)
+	m, err := db.CreateMerchant(ctx, expected)
 	require.NoError(t, err)
 
 	assert.NotZero(t, m.Id)
@@ -106,15 +110,45 @@ func TestCreateMerchant(t *testing.T) {
 	assert.Equal(t, model.UserRoleMerchant, user.Role)
 }
 
+func TestCreateMerchantsAtomic(t *testing.T) {
+	email := RandomString(8)
+
+	input := []model.Merchant{
+		{Name: "one", Email: RandomString(8), Status: model.MerchantStatusActive},
+		{Name: "two", Email: email, Status: model.MerchantStatusActive},
+		{Name: "three", Email: email, Status: model.MerchantStatusActive},
+	}
+
+	_, err := db.CreateMerchants(ctx, input)
+	require.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+
+	var e *model.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, 3, e.Row)
+
+	var count int64
+	err = db.Db().Raw("select count(*) from users where email in (?, ?)", input[0].Email, email).
+		Scan(&count).Error
+
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	result, err := db.CreateMerchants(ctx, input[:2])
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	assert.NotZero(t, result[0].Id)
+	assert.NotZero(t, result[1].Id)
+}
+
 func TestUpdateMerchant(t *testing.T) {
 	expected := model.Merchant{
 		Name:        "name",
 		Description: "description",
 		Email:       RandomString(8),
-		Status:      "status",
+		Status:      model.MerchantStatusPending,
 	}
 
-	m, err := db.CreateMerchant(expected)
+	m, err := db.CreateMerchant(ctx, expected)
 	require.NoError(t, err)
 
 	assert.NotZero(t, m.Id)
@@ -123,9 +157,15 @@ func TestUpdateMerchant(t *testing.T) {
 	expected.Name = "new name"
 	expected.Description = "new description"
 	expected.Email = RandomString(8)
-	expected.Status = "new status"
-
-	m, err = db.UpdateMerchant(expected)
+	expected.Status = model.MerchantStatusActive
+
+	m, err = db.UpdateMerchant(ctx, model.MerchantPatch{
+		Id:          expected.Id,
+		Name:        &expected.Name,
+		Description: &expected.Description,
+		Email:       &expected.Email,
+		Status:      &expected.Status,
+	})
 	require.NoError(t, err)
 
 	actual := Merchant{}
@@ -148,20 +188,81 @@ func TestUpdateMerchant(t *testing.T) {
 	assert.Equal(t, model.UserRoleMerchant, user.Role)
 }
 
+func TestUpdateMerchantClearDescription(t *testing.T) {
+	m, err := db.CreateMerchant(ctx, model.Merchant{
+		Name:        "name",
+		Description: "description",
+		Email:       RandomString(8),
+		Status:      "status",
+	})
+	require.NoError(t, err)
+
+	empty := ""
+	m, err = db.UpdateMerchant(ctx, model.MerchantPatch{
+		Id:          m.Id,
+		Description: &empty,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "", m.Description)
+	assert.Equal(t, "name", m.Name)
+
+	actual, err := db.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, m, actual)
+}
+
+func TestUpdateMerchantVersion(t *testing.T) {
+	m, err := db.CreateMerchant(ctx, model.Merchant{
+		Name:   "name",
+		Email:  RandomString(8),
+		Status: "status",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), m.Version)
+
+	name := "first"
+	updated, err := db.UpdateMerchant(ctx, model.MerchantPatch{
+		Id:      m.Id,
+		Version: m.Version,
+		Name:    &name,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+
+	name = "second"
+	_, err = db.UpdateMerchant(ctx, model.MerchantPatch{
+		Id:      m.Id,
+		Version: m.Version,
+		Name:    &name,
+	})
+	require.ErrorIs(t, err, model.ErrMerchantVersionMismatch)
+
+	err = db.DeleteMerchant(ctx, m.Id, m.Version)
+	require.ErrorIs(t, err, model.ErrMerchantVersionMismatch)
+
+	actual, err := db.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "first", actual.Name)
+
+	err = db.DeleteMerchant(ctx, m.Id, updated.Version)
+	require.NoError(t, err)
+}
+
 func TestDeleteMerchant(t *testing.T) {
 	expected := model.Merchant{
 		Name:        "name",
 		Description: "description",
 		Email:       RandomString(8),
-		Status:      "status",
+		Status:      model.MerchantStatusPending,
 	}
 
-	m, err := db.CreateMerchant(expected)
+	m, err := db.CreateMerchant(ctx, expected)
 	require.NoError(t, err)
 
 	assert.NotZero(t, m.Id)
 
-	err = db.DeleteMerchant(m.Id)
+	err = db.DeleteMerchant(ctx, m.Id, m.Version)
 	require.NoError(t, err)
 
 	actual := Merchant{}
@@ -181,7 +282,150 @@ func TestDeleteMerchant(t *testing.T) {
 	assert.NotEqual(t, nil, user.DeletedAt)
 }
 
-// TODO: add tests for transactions
+func TestTransactionParentRelations(t *testing.T) {
+	m, err := db.CreateMerchant(ctx, model.Merchant{
+		Name:   "name",
+		Email:  RandomString(8),
+		Status: model.MerchantStatusActive,
+	})
+	require.NoError(t, err)
+
+	authorize, err := db.CreateTransaction(ctx, model.Transaction{
+		MerchantId: m.Id,
+		Type:       model.TransactionTypeAuthorize,
+		Amount:     100,
+		Status:     model.TransactionStatusApproved,
+	})
+	require.NoError(t, err)
+
+	charge, err := db.CreateTransaction(ctx, model.Transaction{
+		MerchantId: m.Id,
+		ParentId:   authorize.Id,
+		Type:       model.TransactionTypeCharge,
+		Amount:     100,
+		Status:     model.TransactionStatusApproved,
+	})
+	require.NoError(t, err)
+
+	actual := Transaction{}
+	err = db.Db().Preload("Parent").Preload("Children").
+		Where("transaction_id = ?", authorize.Id.String()).First(&actual).Error
+	require.NoError(t, err)
+
+	assert.Nil(t, actual.Parent)
+	require.Len(t, actual.Children, 1)
+	assert.Equal(t, charge.Id, actual.Children[0].TransactionId)
+
+	actual = Transaction{}
+	err = db.Db().Preload("Parent").Where("transaction_id = ?", charge.Id.String()).First(&actual).Error
+	require.NoError(t, err)
+
+	require.NotNil(t, actual.Parent)
+	assert.Equal(t, authorize.Id, actual.Parent.TransactionId)
+
+	// the foreign key restricts deleting parents
+	assert.Error(t, db.Db().Unscoped().Delete(&Transaction{}, actual.Parent.ID).Error)
+}
+
+func TestForeignKeysOnEveryConnection(t *testing.T) {
+	s, err := NewDb(tempSettings(t), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	m, err := s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+	_, err = s.CreateTransaction(ctx, model.Transaction{
+		MerchantId: m.Id,
+		Type:       model.TransactionTypeAuthorize,
+		Amount:     100,
+		Status:     model.TransactionStatusApproved,
+	})
+	require.NoError(t, err)
+
+	sqlDb, err := s.Db().DB()
+	require.NoError(t, err)
+
+	// hold several connections at once, so each is a distinct one of the pool
+	conns := []*sql.Conn{}
+	for i := 0; i < 4; i++ {
+		conn, err := sqlDb.Conn(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	for _, conn := range conns {
+		_, err := conn.ExecContext(ctx, "DELETE FROM `merchants` WHERE `merchant_id` = ?", m.Id)
+		assert.ErrorContains(t, err, "FOREIGN KEY constraint failed")
+	}
+}
+
+func TestDeleteTransactionsForGood(t *testing.T) {
+	s, err := NewDb(tempSettings(t), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	m, err := s.CreateMerchant(ctx, model.Merchant{
+		Name:   "name",
+		Email:  RandomString(8),
+		Status: model.MerchantStatusActive,
+	})
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, model.Transaction{
+		MerchantId:    m.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@example.com",
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, model.Transaction{
+		MerchantId: m.Id,
+		ParentId:   authorize.Id,
+		Type:       model.TransactionTypeReversal,
+		Status:     model.TransactionStatusApproved,
+	})
+	require.NoError(t, err)
+
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &future}))
+
+	// no row is left with the personal data
+	var rows int64
+	require.NoError(t, s.Db().Unscoped().Model(&Transaction{}).Count(&rows).Error)
+	assert.Zero(t, rows)
+}
+
+func TestClock(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s, err := NewDb(tempSettings(t), func() time.Time { return now })
+	require.NoError(t, err)
+	defer s.Close()
+
+	audited := model.WithAuditor(ctx, model.Auditor{Actor: model.Actor{Name: "ops"}})
+	m, err := s.CreateMerchant(audited, model.Merchant{
+		Name:   "name",
+		Email:  RandomString(8),
+		Status: model.MerchantStatusActive,
+	})
+	require.NoError(t, err)
+
+	tx, err := s.CreateTransaction(ctx, model.Transaction{
+		MerchantId: m.Id,
+		Type:       model.TransactionTypeAuthorize,
+		Amount:     100,
+		Status:     model.TransactionStatusApproved,
+	})
+	require.NoError(t, err)
+	assert.True(t, now.Equal(tx.CreatedAt))
+
+	entries, err := s.GetAuditEntries(ctx, model.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, now.Equal(entries[0].CreatedAt))
+}
 
 func RandomString(n int) string {
 	rand.Seed(time.Now().UnixMicro())
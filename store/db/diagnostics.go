@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// Path is the file of the database
+func (s *sqLiteDb) Path() string {
+	return s.path
+}
+
+// SchemaVersions returns the version the database schema is migrated to
+// and the latest version known to this binary
+func (s *sqLiteDb) SchemaVersions(ctx context.Context) (int, int, error) {
+	s = s.withContext(ctx)
+
+	version, err := SchemaVersion(s.db)
+	if err != nil {
+		return 0, 0, err
+	}
+	return version, LatestVersion(), nil
+}
+
+// TableRows counts the rows of each table, soft deleted ones included
+func (s *sqLiteDb) TableRows(ctx context.Context) (map[string]int64, error) {
+	s = s.withContext(ctx)
+
+	tables := []string{}
+	err := s.db.Raw("SELECT `name` FROM `sqlite_master` WHERE `type` = 'table' AND `name` NOT LIKE 'sqlite_%'").
+		Scan(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rows := map[string]int64{}
+	for _, table := range tables {
+		var count int64
+		if err := s.db.Table(table).Unscoped().Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("counting %s: %w", table, err)
+		}
+		rows[table] = count
+	}
+	return rows, nil
+}
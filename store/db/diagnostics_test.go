@@ -0,0 +1,35 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func TestDiagnostics(t *testing.T) {
+	settings := tempSettings(t)
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, settings.DbPath, s.Path())
+
+	version, latest, err := s.SchemaVersions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, LatestVersion(), version)
+	assert.Equal(t, LatestVersion(), latest)
+
+	m, err := s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteMerchant(ctx, m.Id, m.Version))
+
+	rows, err := s.TableRows(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows["merchants"])
+	assert.Equal(t, int64(1), rows["users"])
+	assert.Zero(t, rows["transactions"])
+	assert.Contains(t, rows, "schema_migrations")
+}
@@ -0,0 +1,444 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration changes the schema from Version-1 to Version. Every migration
+// runs in its own database transaction together with its bookkeeping in
+// the schema_migrations table. Migrations use plain SQL so they keep
+// working after the gorm models change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// ErrSchemaAhead is returned when the database was migrated by a newer binary
+type ErrSchemaAhead struct {
+	Schema int
+	Latest int
+}
+
+func (e ErrSchemaAhead) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the latest known version %d", e.Schema, e.Latest)
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      initialSchemaUp,
+		Down:    initialSchemaDown,
+	},
+	{
+		Version: 2,
+		Name:    "transactions parent foreign key",
+		Up:      parentForeignKeyUp,
+		Down:    parentForeignKeyDown,
+	},
+	{
+		Version: 3,
+		Name:    "merchant lifecycle",
+		Up:      merchantLifecycleUp,
+		Down:    merchantLifecycleDown,
+	},
+	{
+		Version: 4,
+		Name:    "customer erasures",
+		Up:      customerErasuresUp,
+		Down:    customerErasuresDown,
+	},
+	{
+		Version: 5,
+		Name:    "personal data blind indexes",
+		Up:      blindIndexesUp,
+		Down:    blindIndexesDown,
+	},
+	{
+		Version: 6,
+		Name:    "audit log",
+		Up:      auditLogUp,
+		Down:    auditLogDown,
+	},
+	{
+		Version: 7,
+		Name:    "undelete transactions",
+		Up:      undeleteTransactionsUp,
+		Down:    undeleteTransactionsDown,
+	},
+	{
+		Version: 8,
+		Name:    "transaction times in UTC",
+		Up:      transactionTimesUTCUp,
+		Down:    transactionTimesUTCDown,
+	},
+}
+
+// LatestVersion is the schema version this binary expects
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func initialSchemaUp(tx *gorm.DB) error {
+	// databases created by AutoMigrate already have the tables
+	statements := []string{
+		"CREATE TABLE IF NOT EXISTS `users` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`username` text,`password` text,`role` text,`name` text,`description` text,`email` text NOT NULL UNIQUE,PRIMARY KEY (`id`))",
+		"CREATE INDEX IF NOT EXISTS `idx_users_deleted_at` ON `users`(`deleted_at`)",
+		"CREATE TABLE IF NOT EXISTS `admins` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`user_id` integer,`admin_id` uuid,PRIMARY KEY (`id`),CONSTRAINT `fk_admins_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`))",
+		"CREATE INDEX IF NOT EXISTS `idx_admins_deleted_at` ON `admins`(`deleted_at`)",
+		"CREATE TABLE IF NOT EXISTS `merchants` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`user_id` integer,`merchant_id` uuid,`status` text,`version` integer NOT NULL DEFAULT 1,PRIMARY KEY (`id`),CONSTRAINT `fk_merchants_user` FOREIGN KEY (`user_id`) REFERENCES `users`(`id`))",
+		"CREATE INDEX IF NOT EXISTS `idx_merchants_deleted_at` ON `merchants`(`deleted_at`)",
+		"CREATE TABLE IF NOT EXISTS `transactions` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`merchant_id` integer,`transaction_id` uuid,`parent_id` uuid,`type` text,`amount` integer,`status` text,`customer_email` text,`customer_phone` text,PRIMARY KEY (`id`),CONSTRAINT `fk_transactions_merchant` FOREIGN KEY (`merchant_id`) REFERENCES `merchants`(`id`))",
+		"CREATE INDEX IF NOT EXISTS `idx_transactions_deleted_at` ON `transactions`(`deleted_at`)",
+	}
+
+	if err := execAll(tx, statements); err != nil {
+		return err
+	}
+
+	// merchant versions were added before migrations existed
+	if !tx.Migrator().HasColumn("merchants", "version") {
+		return tx.Exec("ALTER TABLE `merchants` ADD `version` integer NOT NULL DEFAULT 1").Error
+	}
+
+	return nil
+}
+
+func initialSchemaDown(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"DROP TABLE `transactions`",
+		"DROP TABLE `merchants`",
+		"DROP TABLE `admins`",
+		"DROP TABLE `users`",
+	})
+}
+
+// SQLite cannot add a foreign key to an existing table, so the
+// transactions table is rebuilt. The new table references itself by its
+// temporary name, renaming it updates the reference. Foreign keys are
+// checked on commit, so rows may be copied in any order.
+
+func parentForeignKeyUp(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"PRAGMA defer_foreign_keys = ON",
+		"CREATE TABLE `transactions_new` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`merchant_id` integer,`parent_id` integer,`transaction_id` uuid,`type` text,`amount` integer,`status` text,`customer_email` text,`customer_phone` text,PRIMARY KEY (`id`),CONSTRAINT `fk_transactions_merchant` FOREIGN KEY (`merchant_id`) REFERENCES `merchants`(`id`),CONSTRAINT `fk_transactions_parent` FOREIGN KEY (`parent_id`) REFERENCES `transactions_new`(`id`) ON DELETE RESTRICT)",
+		// parents are matched by uuid, references to missing rows are dropped
+		"INSERT INTO `transactions_new` (`id`, `created_at`, `updated_at`, `deleted_at`, `merchant_id`, `parent_id`, `transaction_id`, `type`, `amount`, `status`, `customer_email`, `customer_phone`) " +
+			"SELECT t.`id`, t.`created_at`, t.`updated_at`, t.`deleted_at`, t.`merchant_id`, p.`id`, t.`transaction_id`, t.`type`, t.`amount`, t.`status`, t.`customer_email`, t.`customer_phone` " +
+			"FROM `transactions` t LEFT JOIN `transactions` p ON p.`transaction_id` = t.`parent_id` AND p.`id` <> t.`id`",
+		"DROP TABLE `transactions`",
+		"ALTER TABLE `transactions_new` RENAME TO `transactions`",
+		"CREATE INDEX `idx_transactions_deleted_at` ON `transactions`(`deleted_at`)",
+		"CREATE INDEX `idx_transactions_parent_id` ON `transactions`(`parent_id`)",
+		"CREATE INDEX `idx_transactions_transaction_id` ON `transactions`(`transaction_id`)",
+	})
+}
+
+func parentForeignKeyDown(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"PRAGMA defer_foreign_keys = ON",
+		"CREATE TABLE `transactions_old` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`merchant_id` integer,`transaction_id` uuid,`parent_id` uuid,`type` text,`amount` integer,`status` text,`customer_email` text,`customer_phone` text,PRIMARY KEY (`id`),CONSTRAINT `fk_transactions_merchant` FOREIGN KEY (`merchant_id`) REFERENCES `merchants`(`id`))",
+		"INSERT INTO `transactions_old` (`id`, `created_at`, `updated_at`, `deleted_at`, `merchant_id`, `transaction_id`, `parent_id`, `type`, `amount`, `status`, `customer_email`, `customer_phone`) " +
+			"SELECT t.`id`, t.`created_at`, t.`updated_at`, t.`deleted_at`, t.`merchant_id`, t.`transaction_id`, p.`transaction_id`, t.`type`, t.`amount`, t.`status`, t.`customer_email`, t.`customer_phone` " +
+			"FROM `transactions` t LEFT JOIN `transactions` p ON p.`id` = t.`parent_id`",
+		"DROP TABLE `transactions`",
+		"ALTER TABLE `transactions_old` RENAME TO `transactions`",
+		"CREATE INDEX `idx_transactions_deleted_at` ON `transactions`(`deleted_at`)",
+	})
+}
+
+// existing merchants count as changed when they were created
+func merchantLifecycleUp(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"ALTER TABLE `merchants` ADD `status_reason` text NOT NULL DEFAULT ''",
+		"ALTER TABLE `merchants` ADD `status_changed_at` datetime",
+		"UPDATE `merchants` SET `status_changed_at` = `created_at`",
+	})
+}
+
+// statuses unknown to older binaries become inactive, closed merchants
+// stay anonymized
+func merchantLifecycleDown(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"UPDATE `merchants` SET `status` = 'inactive' WHERE `status` NOT IN ('active', 'inactive')",
+		"ALTER TABLE `merchants` DROP COLUMN `status_changed_at`",
+		"ALTER TABLE `merchants` DROP COLUMN `status_reason`",
+	})
+}
+
+func customerErasuresUp(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"CREATE TABLE `erasures` (`id` integer,`created_at` datetime,`updated_at` datetime,`erasure_id` uuid,`subject` text NOT NULL UNIQUE,`transactions` integer NOT NULL DEFAULT 0,PRIMARY KEY (`id`))",
+	})
+}
+
+// the pseudonymized transactions stay pseudonymized
+func customerErasuresDown(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"DROP TABLE `erasures`",
+	})
+}
+
+// the indexes are filled when a keyring is configured
+func blindIndexesUp(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"ALTER TABLE `users` ADD `email_index` text",
+		"CREATE UNIQUE INDEX `idx_users_email_index` ON `users`(`email_index`)",
+		"ALTER TABLE `transactions` ADD `customer_email_index` text",
+		"CREATE INDEX `idx_transactions_customer_email_index` ON `transactions`(`customer_email_index`)",
+	})
+}
+
+// older binaries cannot read encrypted data, it has to be decrypted first
+func blindIndexesDown(tx *gorm.DB) error {
+	var count int64
+	err := tx.Raw("SELECT (SELECT count(*) FROM `users` WHERE `email` LIKE 'enc:v%') + " +
+		"(SELECT count(*) FROM `transactions` WHERE `customer_email` LIKE 'enc:v%' OR `customer_phone` LIKE 'enc:v%')").Scan(&count).Error
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("%d rows hold encrypted personal data", count)
+	}
+
+	return execAll(tx, []string{
+		"DROP INDEX `idx_transactions_customer_email_index`",
+		"ALTER TABLE `transactions` DROP COLUMN `customer_email_index`",
+		"DROP INDEX `idx_users_email_index`",
+		"ALTER TABLE `users` DROP COLUMN `email_index`",
+	})
+}
+
+// the triggers keep the audit log append-only, the hash chain shows when
+// they were bypassed
+func auditLogUp(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"CREATE TABLE `audit_log` (`sequence` integer,`created_at` datetime NOT NULL,`actor` text NOT NULL,`authenticated` numeric NOT NULL DEFAULT false,`request_id` text NOT NULL DEFAULT '',`action` text NOT NULL,`target_type` text NOT NULL,`target_id` uuid,`changes` text NOT NULL,`prev_hash` text NOT NULL,`hash` text NOT NULL,PRIMARY KEY (`sequence`))",
+		"CREATE INDEX `idx_audit_log_target_id` ON `audit_log`(`target_id`)",
+		"CREATE TRIGGER `audit_log_no_update` BEFORE UPDATE ON `audit_log` BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END",
+		"CREATE TRIGGER `audit_log_no_delete` BEFORE DELETE ON `audit_log` BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END",
+	})
+}
+
+// the audit log is lost, dropping the table drops its triggers
+func auditLogDown(tx *gorm.DB) error {
+	return execAll(tx, []string{
+		"DROP TABLE `audit_log`",
+	})
+}
+
+// transactions are deleted for good since, the retention engine archives
+// them first. The soft deleted ones are undeleted, so the next retention run
+// archives them under its policy instead of them being lost.
+func undeleteTransactionsUp(tx *gorm.DB) error {
+	return tx.Exec("UPDATE `transactions` SET `deleted_at` = NULL WHERE `deleted_at` IS NOT NULL").Error
+}
+
+// the undeleted transactions stay, older binaries list them like any other
+func undeleteTransactionsDown(tx *gorm.DB) error {
+	return nil
+}
+
+// the times were written in local time with its offset and are compared as
+// text, so a period across a change of the offset compared wrong
+func transactionTimesUTCUp(tx *gorm.DB) error {
+	return transactionTimesIn(tx, time.UTC)
+}
+
+// older binaries compare the times with local ones
+func transactionTimesUTCDown(tx *gorm.DB) error {
+	return transactionTimesIn(tx, time.Local)
+}
+
+// transactionTimesIn rewrites the times of the transactions in loc, the
+// driver parses them with their offset. SQLite's own time functions drop the
+// nanoseconds, so the rows are rewritten one by one.
+func transactionTimesIn(tx *gorm.DB, loc *time.Location) error {
+	const limit = 1000
+
+	type times struct {
+		Id        uint
+		CreatedAt *time.Time
+		UpdatedAt *time.Time
+		DeletedAt *time.Time
+	}
+
+	in := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		l := t.In(loc)
+		return &l
+	}
+
+	last := uint(0)
+	for {
+		rows := []times{}
+		err := tx.Raw("SELECT `id`, `created_at`, `updated_at`, `deleted_at` FROM `transactions` WHERE `id` > ? ORDER BY `id` LIMIT ?", last, limit).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		for _, r := range rows {
+			err := tx.Exec("UPDATE `transactions` SET `created_at` = ?, `updated_at` = ?, `deleted_at` = ? WHERE `id` = ?",
+				in(r.CreatedAt), in(r.UpdatedAt), in(r.DeletedAt), r.Id).Error
+			if err != nil {
+				return err
+			}
+			last = r.Id
+		}
+
+		if len(rows) < limit {
+			return nil
+		}
+	}
+}
+
+func execAll(tx *gorm.DB, statements []string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createMigrationsTable(db *gorm.DB) error {
+	return db.Exec("CREATE TABLE IF NOT EXISTS `schema_migrations` (`version` integer,`name` text NOT NULL,`applied_at` datetime NOT NULL,PRIMARY KEY (`version`))").Error
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for
+// an empty database
+func SchemaVersion(db *gorm.DB) (int, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Raw("SELECT COALESCE(MAX(`version`), 0) FROM `schema_migrations`").Scan(&version).Error
+	return version, err
+}
+
+// MigrationsStatus lists all known migrations and when they were applied.
+// Applied versions unknown to this binary are listed without a name.
+func MigrationsStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	var applied []struct {
+		Version   int
+		AppliedAt time.Time
+	}
+	if err := db.Raw("SELECT `version`, `applied_at` FROM `schema_migrations`").Scan(&applied).Error; err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	result := []MigrationStatus{}
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := appliedAt[m.Version]; ok {
+			at := at
+			status.AppliedAt = &at
+			delete(appliedAt, m.Version)
+		}
+		result = append(result, status)
+	}
+
+	for version, at := range appliedAt {
+		at := at
+		result = append(result, MigrationStatus{Version: version, AppliedAt: &at})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at version
+func MigrateTo(db *gorm.DB, version int) error {
+	if version < 0 || version > LatestVersion() {
+		return fmt.Errorf("unknown schema version %d, latest is %d", version, LatestVersion())
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current > LatestVersion() {
+		return ErrSchemaAhead{Schema: current, Latest: LatestVersion()}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > version {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO `schema_migrations` (`version`, `name`, `applied_at`) VALUES (?, ?, ?)",
+				m.Version, m.Name, tx.NowFunc()).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= version {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM `schema_migrations` WHERE `version` = ?", m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rolling back migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrateUp applies all pending migrations
+func MigrateUp(db *gorm.DB) error {
+	return MigrateTo(db, LatestVersion())
+}
+
+// MigrateDown rolls back the last applied migration
+func MigrateDown(db *gorm.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return nil
+	}
+	return MigrateTo(db, current-1)
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func tempSettings(t *testing.T) model.StoreSettings {
+	return model.StoreSettings{DbPath: filepath.Join(t.TempDir(), "payment_system.db")}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	database, err := Open(tempSettings(t))
+	require.NoError(t, err)
+
+	version, err := SchemaVersion(database)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	require.NoError(t, MigrateUp(database))
+
+	version, err = SchemaVersion(database)
+	require.NoError(t, err)
+	assert.Equal(t, LatestVersion(), version)
+	assert.True(t, database.Migrator().HasTable("transactions"))
+
+	statuses, err := MigrationsStatus(database)
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrations))
+	for _, s := range statuses {
+		assert.NotNil(t, s.AppliedAt)
+	}
+
+	require.NoError(t, MigrateTo(database, 0))
+
+	version, err = SchemaVersion(database)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+	assert.False(t, database.Migrator().HasTable("users"))
+
+	assert.Error(t, MigrateTo(database, LatestVersion()+1))
+}
+
+func TestNewDbRefusesNewerSchema(t *testing.T) {
+	settings := tempSettings(t)
+
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+
+	err = s.Db().Exec("INSERT INTO `schema_migrations` (`version`, `name`, `applied_at`) VALUES (?, 'future', CURRENT_TIMESTAMP)", LatestVersion()+1).Error
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	_, err = NewDb(settings, nil)
+
+	var ahead ErrSchemaAhead
+	require.True(t, errors.As(err, &ahead))
+	assert.Equal(t, LatestVersion()+1, ahead.Schema)
+}
+
+func TestNewDbMigratesAutoMigratedDatabase(t *testing.T) {
+	settings := tempSettings(t)
+
+	// databases from before the migrations were created by AutoMigrate,
+	// the oldest ones without merchant versions
+	database, err := Open(settings)
+	require.NoError(t, err)
+	require.NoError(t, initialSchemaUp(database))
+	require.NoError(t, database.Exec("ALTER TABLE `merchants` DROP COLUMN `version`").Error)
+
+	id := uuid.New()
+	require.NoError(t, database.Exec("INSERT INTO `users` (`id`, `role`, `name`, `email`) VALUES (1, 'merchant', 'name', 'merchant@example.com')").Error)
+	require.NoError(t, database.Exec("INSERT INTO `merchants` (`id`, `user_id`, `merchant_id`, `status`) VALUES (1, 1, ?, 'active')", id.String()).Error)
+
+	sqlDb, err := database.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDb.Close())
+
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	actual, err := s.GetMerchant(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "merchant@example.com", actual.Email)
+	assert.Equal(t, int64(1), actual.Version)
+
+	version, err := SchemaVersion(s.Db())
+	require.NoError(t, err)
+	assert.Equal(t, LatestVersion(), version)
+}
+
+func TestMigrateParentForeignKey(t *testing.T) {
+	database, err := Open(tempSettings(t))
+	require.NoError(t, err)
+	require.NoError(t, MigrateTo(database, 1))
+
+	authorize := uuid.New()
+	charge := uuid.New()
+	orphan := uuid.New()
+
+	statements := []string{
+		"INSERT INTO `users` (`id`, `email`) VALUES (1, 'merchant@example.com')",
+		"INSERT INTO `merchants` (`id`, `user_id`, `merchant_id`, `status`) VALUES (1, 1, '" + uuid.NewString() + "', 'active')",
+		"INSERT INTO `transactions` (`id`, `merchant_id`, `transaction_id`, `parent_id`, `type`) VALUES (1, 1, '" + authorize.String() + "', '" + uuid.Nil.String() + "', 'authorize')",
+		"INSERT INTO `transactions` (`id`, `merchant_id`, `transaction_id`, `parent_id`, `type`) VALUES (2, 1, '" + charge.String() + "', '" + authorize.String() + "', 'charge')",
+		"INSERT INTO `transactions` (`id`, `merchant_id`, `transaction_id`, `parent_id`, `type`) VALUES (3, 1, '" + orphan.String() + "', '" + uuid.NewString() + "', 'refund')",
+	}
+	require.NoError(t, execAll(database, statements))
+
+	require.NoError(t, MigrateTo(database, 2))
+
+	var parents []struct {
+		Id       uint
+		ParentId *uint
+	}
+	require.NoError(t, database.Raw("SELECT `id`, `parent_id` FROM `transactions` ORDER BY `id`").Scan(&parents).Error)
+	require.Len(t, parents, 3)
+	assert.Nil(t, parents[0].ParentId)
+	require.NotNil(t, parents[1].ParentId)
+	assert.Equal(t, uint(1), *parents[1].ParentId)
+	assert.Nil(t, parents[2].ParentId)
+
+	// parents with children cannot be deleted
+	assert.Error(t, database.Exec("DELETE FROM `transactions` WHERE `id` = 1").Error)
+
+	require.NoError(t, MigrateTo(database, 1))
+
+	var parentUuid string
+	require.NoError(t, database.Raw("SELECT `parent_id` FROM `transactions` WHERE `id` = 2").Scan(&parentUuid).Error)
+	assert.Equal(t, authorize.String(), parentUuid)
+}
+
+func TestMigrateMerchantLifecycle(t *testing.T) {
+	database, err := Open(tempSettings(t))
+	require.NoError(t, err)
+	require.NoError(t, MigrateTo(database, 2))
+
+	statements := []string{
+		"INSERT INTO `users` (`id`, `email`) VALUES (1, 'merchant@example.com')",
+		"INSERT INTO `merchants` (`id`, `created_at`, `user_id`, `merchant_id`, `status`) VALUES (1, '2023-01-02 03:04:05', 1, '" + uuid.NewString() + "', 'active')",
+	}
+	require.NoError(t, execAll(database, statements))
+
+	require.NoError(t, MigrateTo(database, 3))
+
+	var changedAt time.Time
+	require.NoError(t, database.Raw("SELECT `status_changed_at` FROM `merchants` WHERE `id` = 1").Scan(&changedAt).Error)
+	assert.Equal(t, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC), changedAt)
+
+	require.NoError(t, database.Exec("UPDATE `merchants` SET `status` = 'suspended', `status_reason` = 'fraud'").Error)
+
+	// older binaries only know active and inactive merchants
+	require.NoError(t, MigrateTo(database, 2))
+
+	var status string
+	require.NoError(t, database.Raw("SELECT `status` FROM `merchants` WHERE `id` = 1").Scan(&status).Error)
+	assert.Equal(t, model.MerchantStatusInactive, status)
+	assert.False(t, database.Migrator().HasColumn("merchants", "status_reason"))
+}
+
+func TestMigrateUndeleteTransactions(t *testing.T) {
+	database, err := Open(tempSettings(t))
+	require.NoError(t, err)
+	require.NoError(t, MigrateTo(database, 6))
+
+	// a deleted chain and a kept transaction
+	statements := []string{
+		"INSERT INTO `users` (`id`, `email`) VALUES (1, 'merchant@example.com')",
+		"INSERT INTO `merchants` (`id`, `created_at`, `user_id`, `merchant_id`, `status`) VALUES (1, '2023-01-02 03:04:05', 1, '" + uuid.NewString() + "', 'active')",
+		"INSERT INTO `transactions` (`id`, `deleted_at`, `merchant_id`, `transaction_id`, `type`) VALUES (1, '2023-01-02 03:04:05', 1, '" + uuid.NewString() + "', 'authorize')",
+		"INSERT INTO `transactions` (`id`, `deleted_at`, `merchant_id`, `parent_id`, `transaction_id`, `type`) VALUES (2, '2023-01-02 03:04:05', 1, 1, '" + uuid.NewString() + "', 'charge')",
+		"INSERT INTO `transactions` (`id`, `merchant_id`, `transaction_id`, `type`) VALUES (3, 1, '" + uuid.NewString() + "', 'authorize')",
+	}
+	require.NoError(t, execAll(database, statements))
+
+	require.NoError(t, MigrateTo(database, 7))
+
+	var transactions []struct {
+		Id        uint
+		DeletedAt *time.Time
+	}
+	require.NoError(t, database.Raw("SELECT `id`, `deleted_at` FROM `transactions` ORDER BY `id`").Scan(&transactions).Error)
+	require.Len(t, transactions, 3)
+	for _, transaction := range transactions {
+		assert.Nil(t, transaction.DeletedAt)
+	}
+
+	// the undeleted transactions stay
+	require.NoError(t, MigrateTo(database, 6))
+	var count int64
+	require.NoError(t, database.Raw("SELECT COUNT(*) FROM `transactions` WHERE `deleted_at` IS NULL").Scan(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestMigrateTransactionTimesUTC(t *testing.T) {
+	settings := tempSettings(t)
+
+	database, err := Open(settings)
+	require.NoError(t, err)
+	require.NoError(t, MigrateTo(database, 7))
+
+	// written in local time on both sides of a change to summer time, the
+	// second one is the later although its text sorts first
+	before, after := uuid.New(), uuid.New()
+	statements := []string{
+		"INSERT INTO `users` (`id`, `role`, `name`, `email`) VALUES (1, 'merchant', 'name', 'merchant@example.com')",
+		"INSERT INTO `merchants` (`id`, `user_id`, `merchant_id`, `status`) VALUES (1, 1, '" + uuid.NewString() + "', 'active')",
+		"INSERT INTO `transactions` (`id`, `created_at`, `merchant_id`, `transaction_id`, `type`, `customer_email`, `customer_phone`) VALUES (1, '2023-03-26 01:30:00+01:00', 1, '" + before.String() + "', 'authorize', '', '')",
+		"INSERT INTO `transactions` (`id`, `created_at`, `merchant_id`, `transaction_id`, `type`, `customer_email`, `customer_phone`) VALUES (2, '2023-03-26 03:10:00.5+02:00', 1, '" + after.String() + "', 'authorize', '', '')",
+	}
+	require.NoError(t, execAll(database, statements))
+
+	sqlDb, err := database.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDb.Close())
+
+	s, err := NewDb(settings, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var createdAt string
+	require.NoError(t, s.Db().Raw("SELECT CAST(`created_at` AS TEXT) FROM `transactions` WHERE `id` = 2").Scan(&createdAt).Error)
+	assert.Equal(t, "2023-03-26 01:10:00.5+00:00", createdAt)
+
+	olderThan := time.Date(2023, 3, 26, 1, 0, 0, 0, time.UTC)
+	older, err := s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &olderThan})
+	require.NoError(t, err)
+	require.Len(t, older, 1)
+	assert.Equal(t, before, older[0].Id)
+
+	// the same instants in local time for older binaries
+	require.NoError(t, MigrateTo(s.Db(), 7))
+	var times []time.Time
+	require.NoError(t, s.Db().Raw("SELECT `created_at` FROM `transactions` ORDER BY `id`").Scan(&times).Error)
+	require.Len(t, times, 2)
+	assert.True(t, times[0].Equal(time.Date(2023, 3, 26, 0, 30, 0, 0, time.UTC)))
+	assert.True(t, times[1].Equal(time.Date(2023, 3, 26, 1, 10, 0, 500000000, time.UTC)))
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+)
+
+const (
+	tracerName = "github.com/ivaylo-todorov/payment-system/store/db"
+
+	spanKey = "tracing:span"
+)
+
+// registerTracing records a span for every SQL statement run in the context
+// of a span, as its child. Statements outside of a trace, e.g. migrations,
+// are not recorded. Only the SQL with its placeholders is recorded, not the
+// values. Statements of a request carry its ID.
+func registerTracing(db *gorm.DB) error {
+	c := db.Callback()
+
+	return errors.Join(
+		c.Create().Before("*").Register("tracing:before_create", startSpan("create")),
+		c.Create().After("*").Register("tracing:after_create", endSpan),
+		c.Query().Before("*").Register("tracing:before_query", startSpan("query")),
+		c.Query().After("*").Register("tracing:after_query", endSpan),
+		c.Update().Before("*").Register("tracing:before_update", startSpan("update")),
+		c.Update().After("*").Register("tracing:after_update", endSpan),
+		c.Delete().Before("*").Register("tracing:before_delete", startSpan("delete")),
+		c.Delete().After("*").Register("tracing:after_delete", endSpan),
+		c.Row().Before("*").Register("tracing:before_row", startSpan("row")),
+		c.Row().After("*").Register("tracing:after_row", endSpan),
+		c.Raw().Before("*").Register("tracing:before_raw", startSpan("raw")),
+		c.Raw().After("*").Register("tracing:after_raw", endSpan),
+	)
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if !trace.SpanContextFromContext(tx.Statement.Context).IsValid() {
+			return
+		}
+
+		ctx, span := otel.Tracer(tracerName).Start(tx.Statement.Context, "sql."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "sqlite"),
+				attribute.String("db.operation", operation),
+			))
+		if id := logging.RequestId(ctx); id != "" {
+			span.SetAttributes(attribute.String("request_id", id))
+		}
+		tx.Statement.Context = ctx
+		tx.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	span.SetAttributes(
+		attribute.String("db.statement", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.RowsAffected),
+	)
+	if tx.Statement.Table != "" {
+		span.SetAttributes(attribute.String("db.sql.table", tx.Statement.Table))
+	}
+	if err := tx.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+func TestTracingStatements(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	s, err := NewDb(tempSettings(t), nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx, parent := otel.Tracer("test").Start(logging.WithRequestId(context.Background(), "request-1"), "parent")
+	_, err = s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "traced@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+	parent.End()
+
+	statements := []string{}
+	for _, span := range recorder.Ended() {
+		if !strings.HasPrefix(span.Name(), "sql.") {
+			continue
+		}
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Contains(t, span.Attributes(), attribute.String("request_id", "request-1"))
+		for _, a := range span.Attributes() {
+			if a.Key == attribute.Key("db.statement") {
+				statements = append(statements, a.Value.AsString())
+			}
+		}
+	}
+
+	// the migrations of NewDb ran outside of a trace
+	require.NotEmpty(t, statements)
+	assert.Contains(t, strings.Join(statements, "\n"), "INSERT INTO `merchants`")
+	// values are not recorded
+	assert.NotContains(t, strings.Join(statements, "\n"), "traced@example.com")
+}
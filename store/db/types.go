@@ -1,8 +1,12 @@
 package db
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+
+	"github.com/ivaylo-todorov/payment-system/model"
 )
 
 type User struct {
@@ -14,7 +18,9 @@ type User struct {
 
 	Name        string
 	Description string
-	Email       string `gorm:"unique;not null"`
+	// Email is encrypted with a keyring, EmailIndex is then its blind index
+	Email      string  `gorm:"unique;not null"`
+	EmailIndex *string `gorm:"uniqueIndex"`
 }
 
 type Admin struct {
@@ -41,8 +47,11 @@ type Merchant struct {
 	UserID uint
 	User   User
 
-	MerchantId uuid.UUID `gorm:"type:uuid"`
-	Status     string
+	MerchantId      uuid.UUID `gorm:"type:uuid"`
+	Status          string
+	StatusReason    string
+	StatusChangedAt time.Time
+	Version         int64 `gorm:"not null;default:1"`
 }
 
 func (m *Merchant) BeforeCreate(tx *gorm.DB) error {
@@ -59,17 +68,20 @@ type Transaction struct {
 	MerchantID uint
 	Merchant   Merchant
 
-	// TODO: Self-Referential Has One
-	// ParentId *uint
-	// Parent   *Transaction
+	// reference transaction, it cannot be deleted while it has children
+	ParentID *uint
+	Parent   *Transaction  `gorm:"constraint:OnDelete:RESTRICT"`
+	Children []Transaction `gorm:"foreignKey:ParentID"`
 
 	TransactionId uuid.UUID `gorm:"type:uuid"`
-	ParentId      uuid.UUID `gorm:"type:uuid"`
 	Type          string
 	Amount        int64
 	Status        string
-	CustomerEmail string
-	CustomerPhone string
+	// the customer data is encrypted with a keyring, CustomerEmailIndex is
+	// then the blind index of the normalized email
+	CustomerEmail      string
+	CustomerPhone      string
+	CustomerEmailIndex *string `gorm:"index"`
 }
 
 func (t *Transaction) BeforeCreate(tx *gorm.DB) error {
@@ -78,3 +90,59 @@ func (t *Transaction) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// parentUuid returns the uuid of the preloaded parent
+func (t *Transaction) parentUuid() uuid.UUID {
+	if t.Parent == nil {
+		return uuid.Nil
+	}
+	return t.Parent.TransactionId
+}
+
+// Erasure is an audit record, it is never deleted
+type Erasure struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	ErasureId    uuid.UUID `gorm:"type:uuid"`
+	Subject      string    `gorm:"unique;not null"`
+	Transactions int       `gorm:"not null;default:0"`
+}
+
+func (e *Erasure) BeforeCreate(tx *gorm.DB) error {
+	if e.ErasureId == uuid.Nil {
+		e.ErasureId = uuid.New()
+	}
+	return nil
+}
+
+func (e Erasure) toModel() model.Erasure {
+	return model.Erasure{
+		Id:           e.ErasureId,
+		Subject:      e.Subject,
+		Transactions: e.Transactions,
+		CreatedAt:    e.CreatedAt,
+		UpdatedAt:    e.UpdatedAt,
+	}
+}
+
+// AuditEntry is append-only, triggers reject changing or deleting it. The
+// changes are stored as JSON, encrypted when a keyring is configured.
+type AuditEntry struct {
+	Sequence      int64 `gorm:"primarykey;autoIncrement:false"`
+	CreatedAt     time.Time
+	Actor         string
+	Authenticated bool
+	RequestId     string
+	Action        string
+	TargetType    string
+	TargetId      uuid.UUID `gorm:"type:uuid"`
+	Changes       string
+	PrevHash      string
+	Hash          string
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
@@ -0,0 +1,608 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// The in-memory store follows the semantics of the SQLite store: merchants
+// are soft deleted and transactions deleted for good, user emails stay
+// unique also for deleted merchants, transactions reference an existing
+// parent and a refund or reversal updates its parent status.
+
+type merchant struct {
+	model.Merchant
+
+	deleted bool
+}
+
+type transaction struct {
+	model.Transaction
+}
+
+func NewMemory(clock model.Clock) (*memoryStore, error) {
+	if clock == nil {
+		clock = time.Now
+	}
+
+	return &memoryStore{
+		clock:             clock,
+		emails:            map[string]bool{},
+		merchantsById:     map[uuid.UUID]*merchant{},
+		transactionsById:  map[uuid.UUID]*transaction{},
+		children:          map[uuid.UUID][]*transaction{},
+		erasuresBySubject: map[string]*model.Erasure{},
+	}, nil
+}
+
+type memoryStore struct {
+	mu    sync.RWMutex
+	clock model.Clock
+
+	// emails of all users, also of deleted ones
+	emails map[string]bool
+
+	admins []model.Admin
+
+	// merchants and transactions are kept in creation order
+	merchants        []*merchant
+	merchantsById    map[uuid.UUID]*merchant
+	transactions     []*transaction
+	transactionsById map[uuid.UUID]*transaction
+	children         map[uuid.UUID][]*transaction
+
+	// erasures are kept in creation order
+	erasures          []*model.Erasure
+	erasuresBySubject map[string]*model.Erasure
+
+	// audit entries are kept in sequence order
+	audit []model.AuditEntry
+}
+
+func (s *memoryStore) CreateAdmin(ctx context.Context, a model.Admin) (model.Admin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emails[a.Email] {
+		return a, model.ErrEmailAlreadyExists
+	}
+	s.emails[a.Email] = true
+
+	a.Id = uuid.New()
+	s.admins = append(s.admins, a)
+
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.appendAudit(auditor.AdminCreated(a))
+	}
+
+	return a, nil
+}
+
+func (s *memoryStore) CreateMerchant(ctx context.Context, m model.Merchant) (model.Merchant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emails[m.Email] {
+		return m, model.ErrEmailAlreadyExists
+	}
+
+	return s.createMerchant(ctx, m), nil
+}
+
+// CreateMerchants creates all merchants or none of them
+func (s *memoryStore) CreateMerchants(ctx context.Context, input []model.Merchant) ([]model.Merchant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	emails := map[string]bool{}
+	for n, m := range input {
+		if s.emails[m.Email] || emails[m.Email] {
+			return []model.Merchant{}, model.ErrorAtRow(model.ErrEmailAlreadyExists, n+1)
+		}
+		emails[m.Email] = true
+	}
+
+	result := []model.Merchant{}
+	for _, m := range input {
+		result = append(result, s.createMerchant(ctx, m))
+	}
+
+	return result, nil
+}
+
+func (s *memoryStore) createMerchant(ctx context.Context, m model.Merchant) model.Merchant {
+	m.Id = uuid.New()
+	m.Version = 1
+	m.TransactionsAmount = 0
+	m.StatusReason = ""
+	m.StatusChangedAt = s.clock()
+
+	s.emails[m.Email] = true
+
+	stored := &merchant{Merchant: m}
+	s.merchants = append(s.merchants, stored)
+	s.merchantsById[m.Id] = stored
+
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.appendAudit(auditor.Merchant(model.AuditActionMerchantCreate, model.Merchant{}, m))
+	}
+
+	return m
+}
+
+func (s *memoryStore) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (model.Merchant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.merchantsById[p.Id]
+	if !ok || m.deleted {
+		return model.Merchant{}, model.ErrMerchantNotFound
+	}
+
+	// a stale version fails first, the status it was checked against may
+	// have changed since
+	if p.Version != 0 && p.Version != m.Version {
+		return model.Merchant{}, model.ErrMerchantVersionMismatch
+	}
+
+	if m.Status == model.MerchantStatusClosed {
+		return model.Merchant{}, model.ErrMerchantClosed
+	}
+	if p.Status != nil {
+		if err := model.CheckMerchantTransition(m.Status, *p.Status); err != nil {
+			return model.Merchant{}, err
+		}
+	}
+
+	before := s.getMerchant(m)
+
+	if p.Email != nil && *p.Email != m.Email {
+		if s.emails[*p.Email] {
+			return model.Merchant{}, model.ErrEmailAlreadyExists
+		}
+		delete(s.emails, m.Email)
+		s.emails[*p.Email] = true
+		m.Email = *p.Email
+	}
+	if p.Name != nil {
+		m.Name = *p.Name
+	}
+	if p.Description != nil {
+		m.Description = *p.Description
+	}
+	if p.Status != nil {
+		if *p.Status != m.Status {
+			m.Status = *p.Status
+			m.StatusChangedAt = s.clock()
+		}
+		m.StatusReason = ""
+		if p.StatusReason != nil {
+			m.StatusReason = *p.StatusReason
+		}
+	}
+
+	// closing frees the email
+	if m.Status == model.MerchantStatusClosed {
+		delete(s.emails, m.Email)
+		m.Merchant = model.AnonymizedMerchant(m.Merchant)
+		s.emails[m.Email] = true
+	}
+	m.Version++
+
+	after := s.getMerchant(m)
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.appendAudit(auditor.Merchant(model.AuditActionMerchantUpdate, before, after))
+	}
+
+	return after, nil
+}
+
+// DeleteMerchant soft deletes the merchant, its email stays taken.
+// A non zero version must match the current merchant version.
+func (s *memoryStore) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.merchantsById[id]
+	if !ok || m.deleted {
+		return model.ErrMerchantNotFound
+	}
+
+	for _, t := range s.transactions {
+		if t.MerchantId == id {
+			return model.ErrMerchantHasTransactions
+		}
+	}
+
+	if version != 0 && version != m.Version {
+		return model.ErrMerchantVersionMismatch
+	}
+
+	m.deleted = true
+
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.appendAudit(auditor.Merchant(model.AuditActionMerchantDelete, s.getMerchant(m), model.Merchant{}))
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.merchantsById[id]
+	if !ok || m.deleted {
+		return model.Merchant{}, model.ErrMerchantNotFound
+	}
+
+	return s.getMerchant(m), nil
+}
+
+func (s *memoryStore) GetMerchants(ctx context.Context, query model.MerchantQuery) ([]model.Merchant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	merchants := []model.Merchant{}
+	for _, m := range s.merchants {
+		if m.deleted {
+			continue
+		}
+		merchants = append(merchants, s.getMerchant(m))
+	}
+
+	return merchants, nil
+}
+
+// getMerchant adds the sum of the approved charges
+func (s *memoryStore) getMerchant(m *merchant) model.Merchant {
+	result := m.Merchant
+	result.TransactionsAmount = 0
+
+	for _, t := range s.transactions {
+		if t.MerchantId == m.Id && t.Type == model.TransactionTypeCharge && t.Status == model.TransactionStatusApproved {
+			result.TransactionsAmount += t.Amount
+		}
+	}
+
+	return result
+}
+
+func (s *memoryStore) CreateTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.merchantsById[t.MerchantId]
+	if !ok || m.deleted {
+		return model.Transaction{}, model.ErrMerchantNotFound
+	}
+
+	if err := model.CheckMerchantTransaction(m.Status, t.Type); err != nil {
+		return model.Transaction{}, err
+	}
+
+	t.CreatedAt = s.clock()
+
+	stored := &transaction{
+		Transaction: t,
+	}
+
+	var parentStatus string
+
+	switch t.Type {
+	case model.TransactionTypeAuthorize:
+		// authorizations have no reference transaction
+		stored.ParentId = uuid.Nil
+	case model.TransactionTypeCharge:
+	case model.TransactionTypeRefund:
+		parentStatus = model.TransactionStatusRefunded
+	case model.TransactionTypeReversal:
+		parentStatus = model.TransactionStatusReversed
+	default:
+		return model.Transaction{}, model.NewValidationError("type", "invalid", "invalid transaction type")
+	}
+
+	var parent *transaction
+	if t.Type != model.TransactionTypeAuthorize {
+		parent, ok = s.transactionsById[t.ParentId]
+		if !ok {
+			return model.Transaction{}, model.ErrParentNotFound
+		}
+	}
+
+	t.Id = uuid.New()
+	stored.Id = t.Id
+
+	s.transactions = append(s.transactions, stored)
+	s.transactionsById[t.Id] = stored
+
+	if parent != nil {
+		s.children[parent.Id] = append(s.children[parent.Id], stored)
+
+		// don't update reference transaction on errors
+		if parentStatus != "" && t.Status != model.TransactionStatusError {
+			parent.Status = parentStatus
+		}
+	}
+
+	t.MerchantStatus = m.Status
+	return t, nil
+}
+
+func (s *memoryStore) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.transactionsById[id]
+	if !ok {
+		return model.Transaction{}, model.ErrTransactionNotFound
+	}
+
+	return t.Transaction, nil
+}
+
+func (s *memoryStore) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if query.After != uuid.Nil {
+		after, ok := s.transactionsById[query.After]
+		if !ok {
+			return nil, model.ErrTransactionNotFound
+		}
+		for i, t := range s.transactions {
+			if t == after {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	transactions := []model.Transaction{}
+	for _, t := range s.transactions[start:] {
+		if query.Limit > 0 && len(transactions) == query.Limit {
+			break
+		}
+		if !query.Matches(t.Transaction) {
+			continue
+		}
+		transactions = append(transactions, t.Transaction)
+	}
+
+	return transactions, nil
+}
+
+// DeleteTransactions deletes the transactions selected by query for good,
+// except those with a kept transaction below them in their chain
+func (s *memoryStore) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	if !query.Selects() {
+		return nil
+	}
+
+	selected := func(t *transaction) bool {
+		return query.Matches(t.Transaction)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deleted := map[uuid.UUID]bool{}
+	for _, t := range s.transactions {
+		if selected(t) && !s.keepsChildren(t, selected) {
+			deleted[t.Id] = true
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	kept := []*transaction{}
+	for _, t := range s.transactions {
+		if !deleted[t.Id] {
+			kept = append(kept, t)
+			continue
+		}
+
+		delete(s.transactionsById, t.Id)
+		delete(s.children, t.Id)
+		if t.ParentId == uuid.Nil || deleted[t.ParentId] {
+			continue
+		}
+		siblings := []*transaction{}
+		for _, child := range s.children[t.ParentId] {
+			if child != t {
+				siblings = append(siblings, child)
+			}
+		}
+		s.children[t.ParentId] = siblings
+	}
+	s.transactions = kept
+
+	return nil
+}
+
+// keepsChildren reports whether a transaction below t in its chain is not
+// selected for deletion
+func (s *memoryStore) keepsChildren(t *transaction, selected func(*transaction) bool) bool {
+	for _, child := range s.children[t.Id] {
+		if !selected(child) {
+			return true
+		}
+		if s.keepsChildren(child, selected) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreTransactions inserts archived transactions as they were, parents
+// before their children. Present transactions are skipped. Either all
+// transactions are restored or none.
+func (s *memoryStore) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// check everything before changing anything
+	available := map[uuid.UUID]bool{}
+	for _, t := range transactions {
+		if _, ok := s.transactionsById[t.Id]; ok {
+			available[t.Id] = true
+			continue
+		}
+
+		m, ok := s.merchantsById[t.MerchantId]
+		if !ok || m.deleted {
+			return 0, model.ErrMerchantNotFound
+		}
+
+		if t.ParentId != uuid.Nil && !available[t.ParentId] {
+			if _, ok := s.transactionsById[t.ParentId]; !ok {
+				return 0, model.ErrParentNotFound
+			}
+		}
+
+		available[t.Id] = true
+	}
+
+	restored := 0
+	for _, t := range transactions {
+		if _, ok := s.transactionsById[t.Id]; ok {
+			continue
+		}
+
+		stored := &transaction{
+			Transaction: t,
+		}
+
+		s.transactions = append(s.transactions, stored)
+		s.transactionsById[t.Id] = stored
+		if t.ParentId != uuid.Nil {
+			s.children[t.ParentId] = append(s.children[t.ParentId], stored)
+		}
+		restored++
+	}
+
+	return restored, nil
+}
+
+// EraseCustomer pseudonymizes every transaction of a customer and records
+// the erasure. Repeating it only pseudonymizes the
+// transactions created since.
+func (s *memoryStore) EraseCustomer(ctx context.Context, e model.CustomerErasure) (model.Erasure, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email := model.NormalizeCustomerEmail(e.Email)
+
+	erased := 0
+	for _, t := range s.transactions {
+		if model.IsErasedEmail(t.CustomerEmail) || model.NormalizeCustomerEmail(t.CustomerEmail) != email {
+			continue
+		}
+		t.Transaction = e.Pseudonymizer.Transaction(t.Transaction)
+		erased++
+	}
+
+	now := s.clock()
+	subject := e.Pseudonymizer.Subject(email)
+
+	before := model.Erasure{}
+	record, ok := s.erasuresBySubject[subject]
+	if ok {
+		before = *record
+	} else {
+		record = &model.Erasure{
+			Id:        uuid.New(),
+			Subject:   subject,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		s.erasures = append(s.erasures, record)
+		s.erasuresBySubject[subject] = record
+	}
+	if erased != 0 {
+		record.Transactions += erased
+		record.UpdatedAt = now
+	}
+
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.appendAudit(auditor.Erasure(before, *record))
+	}
+
+	return *record, erased, nil
+}
+
+func (s *memoryStore) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	erasures := []model.Erasure{}
+	for _, e := range s.erasures {
+		erasures = append(erasures, *e)
+	}
+
+	return erasures, nil
+}
+
+// AppendAudit adds e to the end of the audit log
+func (s *memoryStore) AppendAudit(ctx context.Context, e model.AuditEntry) (model.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.appendAudit(e), nil
+}
+
+// appendAudit adds e to the end of the audit log, s.mu must be held
+func (s *memoryStore) appendAudit(e model.AuditEntry) model.AuditEntry {
+	var prev *model.AuditEntry
+	if len(s.audit) != 0 {
+		prev = &s.audit[len(s.audit)-1]
+	}
+
+	e.CreatedAt = s.clock().UTC()
+	e.Changes = append([]model.AuditChange{}, e.Changes...)
+	e = e.Seal(prev)
+	s.audit = append(s.audit, e)
+
+	return e
+}
+
+func (s *memoryStore) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := []model.AuditEntry{}
+	for _, e := range s.audit {
+		if query.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -1,26 +1,68 @@
 package store
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/google/uuid"
 
+	"github.com/ivaylo-todorov/payment-system/keyring"
 	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store/bolt"
 	"github.com/ivaylo-todorov/payment-system/store/db"
+	"github.com/ivaylo-todorov/payment-system/store/memory"
 )
 
 type Store interface {
-	CreateAdmin(model.Admin) (model.Admin, error)
+	CreateAdmin(context.Context, model.Admin) (model.Admin, error)
+
+	CreateMerchant(context.Context, model.Merchant) (model.Merchant, error)
+	CreateMerchants(context.Context, []model.Merchant) ([]model.Merchant, error)
+	UpdateMerchant(context.Context, model.MerchantPatch) (model.Merchant, error)
+	DeleteMerchant(context.Context, uuid.UUID, int64) error
+	GetMerchant(context.Context, uuid.UUID) (model.Merchant, error)
+	GetMerchants(context.Context, model.MerchantQuery) ([]model.Merchant, error)
+
+	CreateTransaction(context.Context, model.Transaction) (model.Transaction, error)
+	GetTransaction(context.Context, uuid.UUID) (model.Transaction, error)
+	GetTransactions(context.Context, model.TransactionQuery) ([]model.Transaction, error)
+	DeleteTransactions(context.Context, model.TransactionQuery) error
+	RestoreTransactions(context.Context, []model.Transaction) (int, error)
+
+	EraseCustomer(context.Context, model.CustomerErasure) (model.Erasure, int, error)
+	GetErasures(context.Context) ([]model.Erasure, error)
+
+	AppendAudit(context.Context, model.AuditEntry) (model.AuditEntry, error)
+	GetAuditEntries(context.Context, model.AuditQuery) ([]model.AuditEntry, error)
+
+	Close() error
+}
+
+// NewStore opens the store selected by the backend setting. DummyDb
+// selects the in-memory store regardless of the backend. The store takes
+// its timestamps from clock, nil means time.Now.
+func NewStore(settingss model.StoreSettings, clock model.Clock) (Store, error) {
+	if settingss.DummyDb {
+		return memory.NewMemory(clock)
+	}
 
-	CreateMerchant(model.Merchant) (model.Merchant, error)
-	UpdateMerchant(model.Merchant) (model.Merchant, error)
-	DeleteMerchant(uuid.UUID) error
-	GetMerchants(model.MerchantQuery) ([]model.Merchant, error)
+	switch settingss.Backend {
+	case "", model.StoreBackendSQLite:
+		return db.NewDb(settingss, clock)
+	case model.StoreBackendBolt:
+		return bolt.NewBolt(settingss, clock)
+	case model.StoreBackendMemory:
+		return memory.NewMemory(clock)
+	}
 
-	CreateTransaction(model.Transaction) (model.Transaction, error)
-	GetTransaction(uuid.UUID) (model.Transaction, error)
-	GetTransactions(model.TransactionQuery) ([]model.Transaction, error)
-	DeleteTransactions(model.TransactionQuery) error
+	return nil, fmt.Errorf("unknown store backend %q", settingss.Backend)
 }
 
-func NewStore(settingss model.StoreSettings) (Store, error) {
-	return db.NewDb(settingss)
+// Keyring returns the keyring store encrypts personal data with, nil when
+// it keeps personal data in plain text
+func Keyring(store Store) *keyring.Keyring {
+	if s, ok := store.(interface{ Keyring() *keyring.Keyring }); ok {
+		return s.Keyring()
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -54,6 +55,7 @@ var transactionMock = map[uuid.UUID]model.Transaction{
 
 var createdMerchants = []model.Merchant{}
 var createdTransactions = []model.Transaction{}
+var auditEntries = []model.AuditEntry{}
 
 // TODO: use github.com/stretchr/testify/mock
 
@@ -64,14 +66,18 @@ func NewMockStore() (*mockStore, error) {
 type mockStore struct {
 }
 
-func (s *mockStore) CreateAdmin(a model.Admin) (model.Admin, error) {
+func (s *mockStore) CreateAdmin(ctx context.Context, a model.Admin) (model.Admin, error) {
 	adminMock.Name = a.Name
 	adminMock.Description = a.Description
 	adminMock.Email = a.Email
+
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.AppendAudit(ctx, auditor.AdminCreated(adminMock))
+	}
 	return adminMock, nil
 }
 
-func (s *mockStore) CreateMerchant(m model.Merchant) (model.Merchant, error) {
+func (s *mockStore) CreateMerchant(ctx context.Context, m model.Merchant) (model.Merchant, error) {
 	if m.Id == uuid.Nil {
 		return model.Merchant{}, fmt.Errorf("merchant id is required for testing")
 	}
@@ -90,48 +96,75 @@ func (s *mockStore) CreateMerchant(m model.Merchant) (model.Merchant, error) {
 
 	createdMerchants = append(createdMerchants, merchantMock[m.Id])
 
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.AppendAudit(ctx, auditor.Merchant(model.AuditActionMerchantCreate, model.Merchant{}, merchantMock[m.Id]))
+	}
 	return merchantMock[m.Id], nil
 }
 
-func (s *mockStore) UpdateMerchant(m model.Merchant) (model.Merchant, error) {
+func (s *mockStore) CreateMerchants(ctx context.Context, input []model.Merchant) ([]model.Merchant, error) {
+	result := []model.Merchant{}
+	for n, i := range input {
+		m, err := s.CreateMerchant(ctx, i)
+		if err != nil {
+			return result, model.ErrorAtRow(err, n+1)
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
 
-	if _, ok := merchantMock[m.Id]; !ok {
+func (s *mockStore) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (model.Merchant, error) {
+
+	m, ok := merchantMock[p.Id]
+	if !ok {
 		return model.Merchant{}, fmt.Errorf("only mock merchant ids allowed")
 	}
 
-	name := merchantMock[m.Id].Name
-	if m.Name != "" {
-		name = m.Name
+	if p.Version != 0 && p.Version != m.Version {
+		return model.Merchant{}, model.ErrMerchantVersionMismatch
 	}
-	description := merchantMock[m.Id].Description
-	if m.Description != "" {
-		description = m.Description
+	before := m
+	m.Version++
+
+	if p.Name != nil {
+		m.Name = *p.Name
 	}
-	email := merchantMock[m.Id].Email
-	if m.Email != "" {
-		email = m.Email
+	if p.Description != nil {
+		m.Description = *p.Description
 	}
-	status := merchantMock[m.Id].Status
-	if m.Status != "" {
-		status = m.Status
+	if p.Email != nil {
+		m.Email = *p.Email
 	}
-
-	merchantMock[m.Id] = model.Merchant{
-		Id:          m.Id,
-		Name:        name,
-		Description: description,
-		Email:       email,
-		Status:      status,
+	if p.Status != nil {
+		m.Status = *p.Status
 	}
 
-	return merchantMock[m.Id], nil
+	merchantMock[p.Id] = m
+
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.AppendAudit(ctx, auditor.Merchant(model.AuditActionMerchantUpdate, before, m))
+	}
+	return merchantMock[p.Id], nil
 }
 
-func (s *mockStore) DeleteMerchant(uuid.UUID) error {
+func (s *mockStore) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) error {
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.AppendAudit(ctx, auditor.Merchant(model.AuditActionMerchantDelete, merchantMock[id], model.Merchant{}))
+	}
 	return nil
 }
 
-func (s *mockStore) GetMerchants(model.MerchantQuery) ([]model.Merchant, error) {
+func (s *mockStore) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	for _, m := range createdMerchants {
+		if m.Id == id {
+			return merchantMock[id], nil
+		}
+	}
+	return model.Merchant{}, model.ErrMerchantNotFound
+}
+
+func (s *mockStore) GetMerchants(context.Context, model.MerchantQuery) ([]model.Merchant, error) {
 	result := []model.Merchant{}
 
 	for _, m := range createdMerchants {
@@ -141,7 +174,7 @@ func (s *mockStore) GetMerchants(model.MerchantQuery) ([]model.Merchant, error)
 	return result, nil
 }
 
-func (s *mockStore) CreateTransaction(t model.Transaction) (model.Transaction, error) {
+func (s *mockStore) CreateTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
 	if t.Id == uuid.Nil {
 		return model.Transaction{}, fmt.Errorf("transaction id is required for testing")
 	}
@@ -166,25 +199,67 @@ func (s *mockStore) CreateTransaction(t model.Transaction) (model.Transaction, e
 	return transactionMock[t.Id], nil
 }
 
-func (s *mockStore) GetTransaction(id uuid.UUID) (model.Transaction, error) {
+func (s *mockStore) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
 	for _, t := range createdTransactions {
 		if t.Id == id {
 			return t, nil
 		}
 	}
-	return model.Transaction{}, fmt.Errorf("transaction not found")
+	return model.Transaction{}, model.ErrTransactionNotFound
 }
 
-func (s *mockStore) GetTransactions(model.TransactionQuery) ([]model.Transaction, error) {
+func (s *mockStore) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
 	result := []model.Transaction{}
 
 	for _, t := range createdTransactions {
-		result = append(result, t)
+		if query.Matches(t) {
+			result = append(result, t)
+		}
 	}
 
 	return result, nil
 }
 
-func (s *mockStore) DeleteTransactions(query model.TransactionQuery) error {
+func (s *mockStore) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	return nil
+}
+
+func (s *mockStore) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	return 0, nil
+}
+
+func (s *mockStore) EraseCustomer(ctx context.Context, e model.CustomerErasure) (model.Erasure, int, error) {
+	erasure := model.Erasure{Id: uuid.New(), Subject: e.Pseudonymizer.Subject(e.Email)}
+	if auditor := model.AuditorFrom(ctx); auditor != nil {
+		s.AppendAudit(ctx, auditor.Erasure(model.Erasure{}, erasure))
+	}
+	return erasure, 0, nil
+}
+
+func (s *mockStore) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	return []model.Erasure{}, nil
+}
+
+func (s *mockStore) AppendAudit(ctx context.Context, e model.AuditEntry) (model.AuditEntry, error) {
+	var prev *model.AuditEntry
+	if len(auditEntries) != 0 {
+		prev = &auditEntries[len(auditEntries)-1]
+	}
+	e = e.Seal(prev)
+	auditEntries = append(auditEntries, e)
+	return e, nil
+}
+
+func (s *mockStore) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	entries := []model.AuditEntry{}
+	for _, e := range auditEntries {
+		if query.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *mockStore) Close() error {
 	return nil
 }
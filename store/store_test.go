@@ -0,0 +1,61 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+	"github.com/ivaylo-todorov/payment-system/store/storetest"
+)
+
+// Every backend selectable with model.StoreSettings runs the conformance tests
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock model.Clock) store.Store {
+		s, err := store.NewStore(model.StoreSettings{
+			DbPath: filepath.Join(t.TempDir(), "payment_system.db"),
+		}, clock)
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestEncryptedSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock model.Clock) store.Store {
+		dir := t.TempDir()
+
+		k, err := keyring.New()
+		require.NoError(t, err)
+		require.NoError(t, k.Save(filepath.Join(dir, "keyring.json")))
+
+		s, err := store.NewStore(model.StoreSettings{
+			DbPath:      filepath.Join(dir, "payment_system.db"),
+			KeyringFile: filepath.Join(dir, "keyring.json"),
+		}, clock)
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock model.Clock) store.Store {
+		s, err := store.NewStore(model.StoreSettings{DummyDb: true}, clock)
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock model.Clock) store.Store {
+		s, err := store.NewStore(model.StoreSettings{
+			Backend: model.StoreBackendBolt,
+			DbPath:  filepath.Join(t.TempDir(), "payment_system.bolt"),
+		}, clock)
+		require.NoError(t, err)
+		return s
+	})
+}
@@ -0,0 +1,182 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+func newAuditEntry(actor, action string, target uuid.UUID) model.AuditEntry {
+	return model.AuditEntry{
+		Actor:      actor,
+		RequestId:  uuid.NewString(),
+		Action:     action,
+		TargetType: model.AuditTargetMerchant,
+		TargetId:   target,
+		Changes: []model.AuditChange{
+			{Field: "email", Before: "", After: "merchant@example.com"},
+		},
+	}
+}
+
+func testAppendAudit(t *testing.T, s store.Store, clock *Clock) {
+	entries, err := s.GetAuditEntries(ctx, model.AuditQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	target := uuid.New()
+
+	first, err := s.AppendAudit(ctx, newAuditEntry("ops", model.AuditActionMerchantCreate, target))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.Sequence)
+	assert.Empty(t, first.PrevHash)
+	assert.NotEmpty(t, first.Hash)
+	assert.False(t, first.CreatedAt.IsZero())
+
+	second, err := s.AppendAudit(ctx, newAuditEntry("ops", model.AuditActionMerchantUpdate, target))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), second.Sequence)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	_, err = s.AppendAudit(ctx, newAuditEntry("root", model.AuditActionMerchantDelete, uuid.New()))
+	require.NoError(t, err)
+
+	// stored entries keep their hashes valid
+	entries, err = s.GetAuditEntries(ctx, model.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, first.Changes, entries[0].Changes)
+	assert.Equal(t, first.RequestId, entries[0].RequestId)
+
+	v := model.VerifyAuditChain(entries)
+	assert.True(t, v.Valid(), v.Breaks)
+	assert.Equal(t, entries[2].Hash, v.Head)
+}
+
+func testGetAuditEntriesFilters(t *testing.T, s store.Store, clock *Clock) {
+	target := uuid.New()
+
+	for _, e := range []model.AuditEntry{
+		newAuditEntry("ops", model.AuditActionMerchantCreate, target),
+		newAuditEntry("root", model.AuditActionMerchantUpdate, target),
+		newAuditEntry("ops", model.AuditActionMerchantUpdate, uuid.New()),
+	} {
+		_, err := s.AppendAudit(ctx, e)
+		require.NoError(t, err)
+	}
+
+	sequences := func(query model.AuditQuery) []int64 {
+		entries, err := s.GetAuditEntries(ctx, query)
+		require.NoError(t, err)
+
+		result := []int64{}
+		for _, e := range entries {
+			result = append(result, e.Sequence)
+		}
+		return result
+	}
+
+	assert.Equal(t, []int64{1, 3}, sequences(model.AuditQuery{Actor: "ops"}))
+	assert.Equal(t, []int64{2, 3}, sequences(model.AuditQuery{Action: model.AuditActionMerchantUpdate}))
+	assert.Equal(t, []int64{1, 2}, sequences(model.AuditQuery{TargetId: target}))
+	assert.Equal(t, []int64{3}, sequences(model.AuditQuery{Actor: "ops", Action: model.AuditActionMerchantUpdate}))
+
+	past := clock.Now().Add(-time.Hour)
+	future := clock.Now().Add(time.Hour)
+	assert.Equal(t, []int64{1, 2, 3}, sequences(model.AuditQuery{Since: &past, Until: &future}))
+	assert.Empty(t, sequences(model.AuditQuery{Since: &future}))
+	assert.Empty(t, sequences(model.AuditQuery{Until: &past}))
+}
+
+func testAuditedChanges(t *testing.T, s store.Store, clock *Clock) {
+	actor := model.Actor{Name: "ops", Authenticated: true, RequestId: "request-1"}
+	audited := model.WithAuditor(ctx, model.Auditor{Actor: actor})
+
+	// changes made outside an audited context are not recorded
+	_, err := s.CreateMerchant(ctx, newMerchant("unaudited@example.com"))
+	require.NoError(t, err)
+
+	a, err := s.CreateAdmin(audited, model.Admin{Name: "admin", Email: "admin@example.com"})
+	require.NoError(t, err)
+
+	created, err := s.CreateMerchants(audited, []model.Merchant{newMerchant("first@example.com"), newMerchant("second@example.com")})
+	require.NoError(t, err)
+	require.Len(t, created, 2)
+
+	m, err := s.CreateMerchant(audited, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	name := "renamed"
+	updated, err := s.UpdateMerchant(audited, model.MerchantPatch{Id: m.Id, Version: m.Version, Name: &name})
+	require.NoError(t, err)
+
+	// a failed change records nothing
+	_, err = s.UpdateMerchant(audited, model.MerchantPatch{Id: m.Id, Version: m.Version, Name: &name})
+	require.ErrorIs(t, err, model.ErrMerchantVersionMismatch)
+
+	require.NoError(t, s.DeleteMerchant(audited, m.Id, updated.Version))
+
+	entries, err := s.GetAuditEntries(ctx, model.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+
+	for _, e := range entries {
+		assert.Equal(t, actor.Name, e.Actor)
+		assert.Equal(t, actor.Authenticated, e.Authenticated)
+		assert.Equal(t, actor.RequestId, e.RequestId)
+	}
+
+	assert.Equal(t, model.AuditActionAdminCreate, entries[0].Action)
+	assert.Equal(t, a.Id, entries[0].TargetId)
+	assert.Equal(t, created[0].Id, entries[1].TargetId)
+	assert.Equal(t, created[1].Id, entries[2].TargetId)
+
+	assert.Equal(t, model.AuditActionMerchantCreate, entries[3].Action)
+	assert.Equal(t, m.Id, entries[3].TargetId)
+
+	assert.Equal(t, model.AuditActionMerchantUpdate, entries[4].Action)
+	assert.Contains(t, entries[4].Changes, model.AuditChange{Field: "version", Before: "1", After: "2"})
+
+	assert.Equal(t, model.AuditActionMerchantDelete, entries[5].Action)
+	assert.Equal(t, m.Id, entries[5].TargetId)
+	assert.Contains(t, entries[5].Changes, model.AuditChange{Field: "version", Before: "2"})
+
+	v := model.VerifyAuditChain(entries)
+	assert.True(t, v.Valid(), v.Breaks)
+}
+
+func testAuditedErasure(t *testing.T, s store.Store, clock *Clock) {
+	audited := model.WithAuditor(ctx, model.Auditor{Actor: model.Actor{Name: "ops"}})
+
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	erasure := model.CustomerErasure{Email: "customer@example.com", Pseudonymizer: pseudonymizer}
+	first, _, err := s.EraseCustomer(audited, erasure)
+	require.NoError(t, err)
+	_, _, err = s.EraseCustomer(audited, erasure)
+	require.NoError(t, err)
+
+	entries, err := s.GetAuditEntries(ctx, model.AuditQuery{Action: model.AuditActionCustomerErase})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, model.AuditTargetErasure, entries[0].TargetType)
+	assert.Equal(t, first.Id, entries[0].TargetId)
+	assert.Equal(t, []model.AuditChange{
+		{Field: "subject", After: first.Subject},
+		{Field: "transactions", Before: "0", After: "1"},
+	}, entries[0].Changes)
+
+	// a repeated erasure with nothing left to erase changes nothing
+	assert.Equal(t, first.Id, entries[1].TargetId)
+	assert.Empty(t, entries[1].Changes)
+}
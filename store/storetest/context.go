@@ -0,0 +1,38 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+func testCanceledContext(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = s.GetMerchants(canceled, model.MerchantQuery{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.GetTransactions(canceled, model.TransactionQuery{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	later := clock.Now().Add(time.Hour)
+	err = s.DeleteTransactions(canceled, model.TransactionQuery{OlderThan: &later})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.GetTransaction(ctx, authorize.Id)
+	assert.NoError(t, err)
+}
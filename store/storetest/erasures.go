@@ -0,0 +1,139 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+var pseudonymizer = model.NewPseudonymizer("0123456789abcdef0123456789abcdef")
+
+func testEraseCustomer(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize := newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100)
+	authorize.CustomerEmail = "Customer@Example.com"
+	authorize.CustomerPhone = "+359 888 123456"
+	authorize, err = s.CreateTransaction(ctx, authorize)
+	require.NoError(t, err)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 60))
+	require.NoError(t, err)
+
+	other := newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 10)
+	other.CustomerEmail = "other@example.com"
+	other, err = s.CreateTransaction(ctx, other)
+	require.NoError(t, err)
+
+	deleted, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 20))
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{deleted.Id}}))
+
+	erasure, erased, err := s.EraseCustomer(ctx, model.CustomerErasure{
+		Email:         " customer@example.com",
+		Pseudonymizer: pseudonymizer,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, erased)
+	assert.NotEqual(t, uuid.Nil, erasure.Id)
+	assert.Equal(t, pseudonymizer.Subject("customer@example.com"), erasure.Subject)
+	assert.Equal(t, 2, erasure.Transactions)
+	assert.False(t, erasure.CreatedAt.IsZero())
+
+	// the customer keeps a single token, amounts and chains are unchanged
+	token := pseudonymizer.Email("customer@example.com")
+
+	actual, err := s.GetTransaction(ctx, authorize.Id)
+	require.NoError(t, err)
+	assert.Equal(t, token, actual.CustomerEmail)
+	assert.Equal(t, pseudonymizer.Phone("+359 888 123456"), actual.CustomerPhone)
+	assert.NotContains(t, actual.CustomerPhone, "123456")
+	assert.Equal(t, int64(100), actual.Amount)
+
+	actual, err = s.GetTransaction(ctx, charge.Id)
+	require.NoError(t, err)
+	assert.Equal(t, token, actual.CustomerEmail)
+	assert.Empty(t, actual.CustomerPhone)
+	assert.Equal(t, authorize.Id, actual.ParentId)
+	assert.Equal(t, int64(60), actual.Amount)
+
+	actual, err = s.GetTransaction(ctx, other.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "other@example.com", actual.CustomerEmail)
+
+	merchant, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), merchant.TransactionsAmount)
+
+	// deleted transactions are gone for good, nothing of them is left
+	_, err = s.GetTransaction(ctx, deleted.Id)
+	assert.ErrorIs(t, err, model.ErrTransactionNotFound)
+}
+
+func testEraseCustomerIdempotent(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	request := model.CustomerErasure{
+		Email:         "customer@example.com",
+		Pseudonymizer: pseudonymizer,
+	}
+
+	first, erased, err := s.EraseCustomer(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, 1, erased)
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+
+	// repeating the erasure changes nothing
+	clock.Advance(time.Minute)
+	second, erased, err := s.EraseCustomer(ctx, request)
+	require.NoError(t, err)
+	assert.Zero(t, erased)
+	assert.Equal(t, first.Id, second.Id)
+	assert.Equal(t, 1, second.Transactions)
+	assert.True(t, first.UpdatedAt.Equal(second.UpdatedAt))
+
+	again, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, transactions, again)
+
+	// transactions created since are erased into the same record
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 50))
+	require.NoError(t, err)
+
+	third, erased, err := s.EraseCustomer(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, 1, erased)
+	assert.Equal(t, first.Id, third.Id)
+	assert.Equal(t, 2, third.Transactions)
+
+	// a customer without transactions is recorded as well
+	_, erased, err = s.EraseCustomer(ctx, model.CustomerErasure{
+		Email:         "unknown@example.com",
+		Pseudonymizer: pseudonymizer,
+	})
+	require.NoError(t, err)
+	assert.Zero(t, erased)
+
+	erasures, err := s.GetErasures(ctx)
+	require.NoError(t, err)
+	require.Len(t, erasures, 2)
+	assert.Equal(t, first.Id, erasures[0].Id)
+	assert.Equal(t, 2, erasures[0].Transactions)
+	assert.Equal(t, pseudonymizer.Subject("unknown@example.com"), erasures[1].Subject)
+	for _, e := range erasures {
+		assert.NotContains(t, e.Subject, "example.com")
+	}
+}
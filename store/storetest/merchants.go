@@ -0,0 +1,305 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+func testCreateMerchant(t *testing.T, s store.Store, clock *Clock) {
+	expected := model.Merchant{
+		Name:        "name",
+		Description: "description",
+		Email:       "merchant@example.com",
+		Status:      model.MerchantStatusActive,
+	}
+
+	created, err := s.CreateMerchant(ctx, expected)
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, created.Id)
+	assert.Equal(t, int64(1), created.Version)
+
+	actual, err := s.GetMerchant(ctx, created.Id)
+	require.NoError(t, err)
+	assert.False(t, actual.StatusChangedAt.IsZero())
+	assert.True(t, created.StatusChangedAt.Equal(actual.StatusChangedAt))
+
+	expected.Id = created.Id
+	expected.Version = 1
+	expected.StatusChangedAt = actual.StatusChangedAt
+	assert.Equal(t, expected, actual)
+
+	merchants, err := s.GetMerchants(ctx, model.MerchantQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []model.Merchant{expected}, merchants)
+}
+
+func testEmailAlreadyExists(t *testing.T, s store.Store, clock *Clock) {
+	_, err := s.CreateAdmin(ctx, model.Admin{Name: "admin", Email: "user@example.com"})
+	require.NoError(t, err)
+
+	_, err = s.CreateMerchant(ctx, newMerchant("user@example.com"))
+	assert.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	email := "user@example.com"
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Email: &email})
+	assert.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+}
+
+func testDeleteMerchant(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteMerchant(ctx, m.Id, m.Version))
+
+	_, err = s.GetMerchant(ctx, m.Id)
+	assert.ErrorIs(t, err, model.ErrMerchantNotFound)
+
+	merchants, err := s.GetMerchants(ctx, model.MerchantQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, merchants)
+
+	assert.ErrorIs(t, s.DeleteMerchant(ctx, m.Id, 0), model.ErrMerchantNotFound)
+
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id})
+	assert.ErrorIs(t, err, model.ErrMerchantNotFound)
+
+	// deleted merchants keep their email
+	_, err = s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	assert.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+}
+
+func testDeleteMerchantWithTransactions(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.DeleteMerchant(ctx, m.Id, 0), model.ErrMerchantHasTransactions)
+
+	_, err = s.GetMerchant(ctx, m.Id)
+	assert.NoError(t, err)
+
+	// deleted transactions don't prevent deleting the merchant
+	future := clock.Now().Add(time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &future}))
+
+	assert.NoError(t, s.DeleteMerchant(ctx, m.Id, 0))
+}
+
+func testCreateAdmin(t *testing.T, s store.Store, clock *Clock) {
+	a, err := s.CreateAdmin(ctx, model.Admin{Name: "admin", Email: "admin@example.com"})
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, a.Id)
+
+	_, err = s.CreateAdmin(ctx, model.Admin{Name: "admin", Email: "admin@example.com"})
+	assert.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+}
+
+func testCreateMerchants(t *testing.T, s store.Store, clock *Clock) {
+	merchants, err := s.CreateMerchants(ctx, []model.Merchant{
+		newMerchant("one@example.com"),
+		newMerchant("two@example.com"),
+	})
+	require.NoError(t, err)
+	require.Len(t, merchants, 2)
+
+	for _, m := range merchants {
+		actual, err := s.GetMerchant(ctx, m.Id)
+		require.NoError(t, err)
+		assert.Equal(t, m.Email, actual.Email)
+		assert.Equal(t, int64(1), actual.Version)
+	}
+}
+
+func testCreateMerchantsAtomic(t *testing.T, s store.Store, clock *Clock) {
+	_, err := s.CreateMerchants(ctx, []model.Merchant{
+		newMerchant("one@example.com"),
+		newMerchant("one@example.com"),
+	})
+	require.ErrorIs(t, err, model.ErrEmailAlreadyExists)
+
+	var e *model.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, 2, e.Row)
+
+	merchants, err := s.GetMerchants(ctx, model.MerchantQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, merchants)
+
+	// nothing was created so the email is still free
+	_, err = s.CreateMerchant(ctx, newMerchant("one@example.com"))
+	assert.NoError(t, err)
+}
+
+func testUpdateMerchant(t *testing.T, s store.Store, clock *Clock) {
+	m := newMerchant("merchant@example.com")
+	m.Description = "description"
+	m, err := s.CreateMerchant(ctx, m)
+	require.NoError(t, err)
+
+	name := "new name"
+	email := "new@example.com"
+	status := model.MerchantStatusInactive
+	updated, err := s.UpdateMerchant(ctx, model.MerchantPatch{
+		Id:      m.Id,
+		Version: m.Version,
+		Name:    &name,
+		Email:   &email,
+		Status:  &status,
+	})
+	require.NoError(t, err)
+
+	assert.False(t, updated.StatusChangedAt.Before(m.StatusChangedAt))
+
+	expected := model.Merchant{
+		Id:              m.Id,
+		Name:            name,
+		Description:     "description",
+		Email:           email,
+		Status:          status,
+		StatusChangedAt: updated.StatusChangedAt,
+		Version:         2,
+	}
+	assert.Equal(t, expected, updated)
+
+	actual, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// the old email is free again
+	_, err = s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	assert.NoError(t, err)
+}
+
+func testUpdateMerchantClearDescription(t *testing.T, s store.Store, clock *Clock) {
+	m := newMerchant("merchant@example.com")
+	m.Description = "description"
+	m, err := s.CreateMerchant(ctx, m)
+	require.NoError(t, err)
+
+	empty := ""
+	updated, err := s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Description: &empty})
+	require.NoError(t, err)
+	assert.Empty(t, updated.Description)
+	assert.Equal(t, m.Name, updated.Name)
+	assert.Equal(t, int64(2), updated.Version)
+}
+
+func testUpdateMerchantVersionMismatch(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	name := "new name"
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Version: m.Version, Name: &name})
+	require.NoError(t, err)
+
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Version: m.Version, Name: &name})
+	assert.ErrorIs(t, err, model.ErrMerchantVersionMismatch)
+
+	assert.ErrorIs(t, s.DeleteMerchant(ctx, m.Id, m.Version), model.ErrMerchantVersionMismatch)
+
+	actual, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), actual.Version)
+}
+
+func testMerchantNotFound(t *testing.T, s store.Store, clock *Clock) {
+	id := uuid.New()
+
+	_, err := s.GetMerchant(ctx, id)
+	assert.ErrorIs(t, err, model.ErrMerchantNotFound)
+	assert.Equal(t, model.ErrorKindNotFound, model.KindOf(err))
+
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: id})
+	assert.ErrorIs(t, err, model.ErrMerchantNotFound)
+
+	assert.ErrorIs(t, s.DeleteMerchant(ctx, id, 0), model.ErrMerchantNotFound)
+}
+
+func testMerchantLifecycle(t *testing.T, s store.Store, clock *Clock) {
+	m := newMerchant("merchant@example.com")
+	m.Status = model.MerchantStatusPending
+	m, err := s.CreateMerchant(ctx, m)
+	require.NoError(t, err)
+
+	status := func(status, reason string) model.MerchantPatch {
+		return model.MerchantPatch{Id: m.Id, Status: &status, StatusReason: &reason}
+	}
+
+	// pending merchants have to be activated first
+	_, err = s.UpdateMerchant(ctx, status(model.MerchantStatusSuspended, "fraud"))
+	assert.ErrorIs(t, err, model.ErrInvalidStatusTransition)
+
+	active, err := s.UpdateMerchant(ctx, status(model.MerchantStatusActive, "verified"))
+	require.NoError(t, err)
+	assert.Equal(t, "verified", active.StatusReason)
+	assert.False(t, active.StatusChangedAt.Before(m.StatusChangedAt))
+
+	suspended, err := s.UpdateMerchant(ctx, status(model.MerchantStatusSuspended, "fraud"))
+	require.NoError(t, err)
+	assert.Equal(t, model.MerchantStatusSuspended, suspended.Status)
+	assert.Equal(t, "fraud", suspended.StatusReason)
+
+	// the same status only changes the reason
+	suspended, err = s.UpdateMerchant(ctx, status(model.MerchantStatusSuspended, "chargebacks"))
+	require.NoError(t, err)
+	assert.Equal(t, "chargebacks", suspended.StatusReason)
+
+	reactivated, err := s.UpdateMerchant(ctx, status(model.MerchantStatusActive, "cleared"))
+	require.NoError(t, err)
+	assert.Equal(t, model.MerchantStatusActive, reactivated.Status)
+
+	closed, err := s.UpdateMerchant(ctx, status(model.MerchantStatusClosed, "requested"))
+	require.NoError(t, err)
+	assert.Equal(t, model.MerchantStatusClosed, closed.Status)
+	assert.Equal(t, "requested", closed.StatusReason)
+
+	actual, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, closed.Version, actual.Version)
+	assert.True(t, closed.StatusChangedAt.Equal(actual.StatusChangedAt))
+
+	// closed merchants cannot change
+	_, err = s.UpdateMerchant(ctx, status(model.MerchantStatusActive, "reopened"))
+	assert.ErrorIs(t, err, model.ErrMerchantClosed)
+
+	name := "name"
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Name: &name})
+	assert.ErrorIs(t, err, model.ErrMerchantClosed)
+
+	// a stale version fails on the version, not on the status it missed
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Version: reactivated.Version, Name: &name})
+	assert.ErrorIs(t, err, model.ErrMerchantVersionMismatch)
+}
+
+func testCloseMerchantFreesEmail(t *testing.T, s store.Store, clock *Clock) {
+	m := newMerchant("merchant@example.com")
+	m.Description = "description"
+	m, err := s.CreateMerchant(ctx, m)
+	require.NoError(t, err)
+
+	closed := model.MerchantStatusClosed
+	reason := "requested"
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Status: &closed, StatusReason: &reason})
+	require.NoError(t, err)
+
+	actual, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.AnonymizedEmail(m.Id), actual.Email)
+	assert.NotEqual(t, m.Name, actual.Name)
+	assert.Empty(t, actual.Description)
+
+	_, err = s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	assert.NoError(t, err)
+}
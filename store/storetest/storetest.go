@@ -0,0 +1,126 @@
+// Package storetest checks that a store.Store implementation follows the
+// semantics every backend has to share.
+package storetest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+// ctx is the context of the store calls of the tests
+var ctx = context.Background()
+
+// Factory returns a new empty store taking its time from clock, it is
+// called once for every test
+type Factory func(t *testing.T, clock model.Clock) store.Store
+
+// Clock is the clock of the store under test, it only moves when the test
+// advances it
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Now returns the time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Run runs the conformance tests against the stores returned by newStore.
+// Every backend of store.NewStore is expected to pass them.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, store.Store, *Clock)
+	}{
+		{"CreateAdmin", testCreateAdmin},
+		{"CreateMerchant", testCreateMerchant},
+		{"CreateMerchants", testCreateMerchants},
+		{"CreateMerchantsAtomic", testCreateMerchantsAtomic},
+		{"EmailAlreadyExists", testEmailAlreadyExists},
+		{"UpdateMerchant", testUpdateMerchant},
+		{"UpdateMerchantClearDescription", testUpdateMerchantClearDescription},
+		{"UpdateMerchantVersionMismatch", testUpdateMerchantVersionMismatch},
+		{"DeleteMerchant", testDeleteMerchant},
+		{"DeleteMerchantWithTransactions", testDeleteMerchantWithTransactions},
+		{"MerchantNotFound", testMerchantNotFound},
+		{"MerchantLifecycle", testMerchantLifecycle},
+		{"CloseMerchantFreesEmail", testCloseMerchantFreesEmail},
+
+		{"TransactionChain", testTransactionChain},
+		{"TransactionsAmount", testTransactionsAmount},
+		{"TransactionsAmountSum", testTransactionsAmountSum},
+		{"RefundUpdatesParent", testRefundUpdatesParent},
+		{"ReversalUpdatesParent", testReversalUpdatesParent},
+		{"FailedRefundKeepsParent", testFailedRefundKeepsParent},
+		{"CreateTransactionErrors", testCreateTransactionErrors},
+		{"SuspendedMerchantTransactions", testSuspendedMerchantTransactions},
+		{"TransactionNotFound", testTransactionNotFound},
+		{"GetTransactionsQuery", testGetTransactionsQuery},
+		{"DeleteTransactions", testDeleteTransactions},
+		{"DeleteTransactionsKeepsChains", testDeleteTransactionsKeepsChains},
+		{"DeleteTransactionsByIds", testDeleteTransactionsByIds},
+		{"ParentNotFound", testParentNotFound},
+		{"TransactionsBefore1970", testTransactionsBefore1970},
+		{"RestoreTransactions", testRestoreTransactions},
+		{"RestoreTransactionsAtomic", testRestoreTransactionsAtomic},
+
+		{"EraseCustomer", testEraseCustomer},
+		{"EraseCustomerIdempotent", testEraseCustomerIdempotent},
+
+		{"AppendAudit", testAppendAudit},
+		{"GetAuditEntriesFilters", testGetAuditEntriesFilters},
+		{"AuditedChanges", testAuditedChanges},
+		{"AuditedErasure", testAuditedErasure},
+
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &Clock{now: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+			s := newStore(t, clock.Now)
+			t.Cleanup(func() {
+				assert.NoError(t, s.Close())
+			})
+			tc.test(t, s, clock)
+		})
+	}
+}
+
+func newMerchant(email string) model.Merchant {
+	return model.Merchant{
+		Name:   "name",
+		Email:  email,
+		Status: model.MerchantStatusActive,
+	}
+}
+
+func newTransaction(merchantId, parentId uuid.UUID, transactionType string, amount int64) model.Transaction {
+	return model.Transaction{
+		MerchantId:    merchantId,
+		ParentId:      parentId,
+		Type:          transactionType,
+		Amount:        amount,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@example.com",
+	}
+}
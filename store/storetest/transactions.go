@@ -0,0 +1,550 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+func testTransactionsAmount(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 60))
+	require.NoError(t, err)
+
+	failed := newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 30)
+	failed.Status = model.TransactionStatusError
+	_, err = s.CreateTransaction(ctx, failed)
+	require.NoError(t, err)
+
+	// only approved charges are counted
+	actual, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), actual.TransactionsAmount)
+
+	merchants, err := s.GetMerchants(ctx, model.MerchantQuery{})
+	require.NoError(t, err)
+	require.Len(t, merchants, 1)
+	assert.Equal(t, int64(60), merchants[0].TransactionsAmount)
+}
+
+func testRefundUpdatesParent(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+
+	refund, err := s.CreateTransaction(ctx, newTransaction(m.Id, charge.Id, model.TransactionTypeRefund, 100))
+	require.NoError(t, err)
+
+	actual, err := s.GetTransaction(ctx, charge.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.TransactionStatusRefunded, actual.Status)
+
+	actual, err = s.GetTransaction(ctx, refund.Id)
+	require.NoError(t, err)
+	assert.Equal(t, charge.Id, actual.ParentId)
+	assert.Equal(t, m.Id, actual.MerchantId)
+	assert.Equal(t, model.TransactionStatusApproved, actual.Status)
+
+	// refunded charges are not counted
+	merchant, err := s.GetMerchant(ctx, m.Id)
+	require.NoError(t, err)
+	assert.Zero(t, merchant.TransactionsAmount)
+}
+
+func testReversalUpdatesParent(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeReversal, 0))
+	require.NoError(t, err)
+
+	actual, err := s.GetTransaction(ctx, authorize.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.TransactionStatusReversed, actual.Status)
+}
+
+func testFailedRefundKeepsParent(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+
+	refund := newTransaction(m.Id, charge.Id, model.TransactionTypeRefund, 100)
+	refund.Status = model.TransactionStatusError
+	_, err = s.CreateTransaction(ctx, refund)
+	require.NoError(t, err)
+
+	actual, err := s.GetTransaction(ctx, charge.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.TransactionStatusApproved, actual.Status)
+}
+
+func testTransactionChain(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, authorize.Id)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+
+	refund, err := s.CreateTransaction(ctx, newTransaction(m.Id, charge.Id, model.TransactionTypeRefund, 100))
+	require.NoError(t, err)
+
+	expected := map[uuid.UUID]model.Transaction{}
+	for _, tr := range []model.Transaction{authorize, charge, refund} {
+		actual, err := s.GetTransaction(ctx, tr.Id)
+		require.NoError(t, err)
+		assert.Equal(t, tr.ParentId, actual.ParentId)
+		assert.Equal(t, m.Id, actual.MerchantId)
+		assert.Equal(t, tr.Type, actual.Type)
+		assert.Equal(t, tr.Amount, actual.Amount)
+		assert.Equal(t, tr.CustomerEmail, actual.CustomerEmail)
+		expected[tr.Id] = actual
+	}
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	require.Len(t, transactions, 3)
+	for _, tr := range transactions {
+		assert.Equal(t, expected[tr.Id], tr)
+	}
+}
+
+func testTransactionsAmountSum(t *testing.T, s store.Store, clock *Clock) {
+	one, err := s.CreateMerchant(ctx, newMerchant("one@example.com"))
+	require.NoError(t, err)
+
+	two, err := s.CreateMerchant(ctx, newMerchant("two@example.com"))
+	require.NoError(t, err)
+
+	for _, amount := range []int64{10, 20, 30} {
+		authorize, err := s.CreateTransaction(ctx, newTransaction(one.Id, uuid.Nil, model.TransactionTypeAuthorize, amount))
+		require.NoError(t, err)
+
+		_, err = s.CreateTransaction(ctx, newTransaction(one.Id, authorize.Id, model.TransactionTypeCharge, amount))
+		require.NoError(t, err)
+	}
+
+	merchants, err := s.GetMerchants(ctx, model.MerchantQuery{})
+	require.NoError(t, err)
+
+	amounts := map[uuid.UUID]int64{}
+	for _, m := range merchants {
+		amounts[m.Id] = m.TransactionsAmount
+	}
+	assert.Equal(t, map[uuid.UUID]int64{one.Id: 60, two.Id: 0}, amounts)
+}
+
+func testCreateTransactionErrors(t *testing.T, s store.Store, clock *Clock) {
+	_, err := s.CreateTransaction(ctx, newTransaction(uuid.New(), uuid.Nil, model.TransactionTypeAuthorize, 100))
+	assert.ErrorIs(t, err, model.ErrMerchantNotFound)
+
+	inactive := newMerchant("inactive@example.com")
+	inactive.Status = model.MerchantStatusInactive
+	inactive, err = s.CreateMerchant(ctx, inactive)
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(inactive.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	assert.ErrorIs(t, err, model.ErrMerchantNotActive)
+	assert.Equal(t, model.ErrorKindPreconditionFailed, model.KindOf(err))
+
+	active, err := s.CreateMerchant(ctx, newMerchant("active@example.com"))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(active.Id, uuid.Nil, "unknown", 100))
+	assert.Equal(t, model.ErrorKindValidation, model.KindOf(err))
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, transactions)
+}
+
+func testSuspendedMerchantTransactions(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	charged, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, charged.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+
+	suspended := model.MerchantStatusSuspended
+	reason := "fraud"
+	_, err = s.UpdateMerchant(ctx, model.MerchantPatch{Id: m.Id, Status: &suspended, StatusReason: &reason})
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	assert.ErrorIs(t, err, model.ErrMerchantSuspended)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 100))
+	assert.ErrorIs(t, err, model.ErrMerchantSuspended)
+
+	// funds can still be returned to the customers
+	refund, err := s.CreateTransaction(ctx, newTransaction(m.Id, charge.Id, model.TransactionTypeRefund, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, model.MerchantStatusSuspended, refund.MerchantStatus)
+	assert.Equal(t, model.MerchantStatusActive, charge.MerchantStatus)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeReversal, 0))
+	assert.NoError(t, err)
+}
+
+func testTransactionNotFound(t *testing.T, s store.Store, clock *Clock) {
+	_, err := s.GetTransaction(ctx, uuid.New())
+	assert.ErrorIs(t, err, model.ErrTransactionNotFound)
+	assert.Equal(t, model.ErrorKindNotFound, model.KindOf(err))
+}
+
+func testGetTransactionsQuery(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	older, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+	old, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	clock.Advance(time.Minute)
+	olderThan := clock.Now()
+
+	recent, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	ids := func(transactions []model.Transaction) []uuid.UUID {
+		result := []uuid.UUID{}
+		for _, t := range transactions {
+			result = append(result, t.Id)
+		}
+		return result
+	}
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{older.Id, old.Id, recent.Id}, ids(transactions))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &olderThan})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{older.Id, old.Id}, ids(transactions))
+
+	// listed ids come in creation order, unknown ones are skipped
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{recent.Id, uuid.New(), older.Id}})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{older.Id, recent.Id}, ids(transactions))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &olderThan, Ids: []uuid.UUID{recent.Id, old.Id}})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{old.Id}, ids(transactions))
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, old.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+	reversal, err := s.CreateTransaction(ctx, newTransaction(m.Id, recent.Id, model.TransactionTypeReversal, 0))
+	require.NoError(t, err)
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{ParentIds: []uuid.UUID{recent.Id, older.Id, old.Id}})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{charge.Id, reversal.Id}, ids(transactions))
+
+	// pages follow the creation order
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{older.Id, old.Id}, ids(transactions))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{After: old.Id, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{recent.Id, charge.Id}, ids(transactions))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{After: charge.Id, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{reversal.Id}, ids(transactions))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &olderThan, After: older.Id, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{old.Id}, ids(transactions))
+
+	_, err = s.GetTransactions(ctx, model.TransactionQuery{After: uuid.New()})
+	assert.ErrorIs(t, err, model.ErrTransactionNotFound)
+
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{charge.Id, reversal.Id}}))
+
+	// deleted transactions are not returned
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{older.Id}}))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &olderThan})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{old.Id}, ids(transactions))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{older.Id}})
+	require.NoError(t, err)
+	assert.Empty(t, transactions)
+}
+
+func testDeleteTransactions(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	// no filter deletes nothing
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{}))
+
+	past := clock.Now().Add(-time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &past}))
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	assert.Len(t, transactions, 1)
+
+	future := clock.Now().Add(time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &future}))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, transactions)
+
+	_, err = s.GetTransaction(ctx, authorize.Id)
+	assert.ErrorIs(t, err, model.ErrTransactionNotFound)
+}
+
+func testParentNotFound(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, uuid.New(), model.TransactionTypeCharge, 100))
+	assert.ErrorIs(t, err, model.ErrParentNotFound)
+	assert.Equal(t, model.ErrorKindPreconditionFailed, model.KindOf(err))
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	future := clock.Now().Add(time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &future}))
+
+	// deleted transactions cannot be referenced
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeReversal, 0))
+	assert.ErrorIs(t, err, model.ErrParentNotFound)
+}
+
+func testDeleteTransactionsKeepsChains(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+
+	expired, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	clock.Advance(time.Minute)
+	olderThan := clock.Now()
+	clock.Advance(time.Minute)
+
+	refund, err := s.CreateTransaction(ctx, newTransaction(m.Id, charge.Id, model.TransactionTypeRefund, 100))
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &olderThan}))
+
+	// the refund is newer, so its whole chain is kept
+	for _, id := range []uuid.UUID{authorize.Id, charge.Id, refund.Id} {
+		_, err := s.GetTransaction(ctx, id)
+		assert.NoError(t, err)
+	}
+
+	_, err = s.GetTransaction(ctx, expired.Id)
+	assert.ErrorIs(t, err, model.ErrTransactionNotFound)
+}
+
+func testDeleteTransactionsByIds(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+	assert.False(t, authorize.CreatedAt.IsZero())
+
+	reversal, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeReversal, 0))
+	require.NoError(t, err)
+
+	other, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	// a parent is kept while its children are
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{authorize.Id}}))
+
+	_, err = s.GetTransaction(ctx, authorize.Id)
+	assert.NoError(t, err)
+
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{Ids: []uuid.UUID{authorize.Id, reversal.Id, uuid.New()}}))
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, other.Id, transactions[0].Id)
+	assert.False(t, transactions[0].CreatedAt.IsZero())
+
+	// both filters have to match
+	past := clock.Now().Add(-time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &past, Ids: []uuid.UUID{other.Id}}))
+
+	_, err = s.GetTransaction(ctx, other.Id)
+	assert.NoError(t, err)
+}
+
+func testTransactionsBefore1970(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	recent, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	old := newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100)
+	old.Id = uuid.New()
+	old.CreatedAt = time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = s.RestoreTransactions(ctx, []model.Transaction{old})
+	require.NoError(t, err)
+
+	epoch := time.Unix(0, 0)
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &epoch})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, old.Id, transactions[0].Id)
+
+	later := clock.Now().Add(time.Minute)
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{OlderThan: &later})
+	require.NoError(t, err)
+	assert.Len(t, transactions, 2)
+
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &epoch}))
+
+	transactions, err = s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, recent.Id, transactions[0].Id)
+}
+
+func testRestoreTransactions(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize, err := s.CreateTransaction(ctx, newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100))
+	require.NoError(t, err)
+
+	charge, err := s.CreateTransaction(ctx, newTransaction(m.Id, authorize.Id, model.TransactionTypeCharge, 100))
+	require.NoError(t, err)
+
+	_, err = s.CreateTransaction(ctx, newTransaction(m.Id, charge.Id, model.TransactionTypeRefund, 100))
+	require.NoError(t, err)
+
+	archived, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	require.Len(t, archived, 3)
+
+	future := clock.Now().Add(time.Hour)
+	require.NoError(t, s.DeleteTransactions(ctx, model.TransactionQuery{OlderThan: &future}))
+
+	restored, err := s.RestoreTransactions(ctx, archived)
+	require.NoError(t, err)
+	assert.Equal(t, 3, restored)
+
+	// restoring twice changes nothing
+	restored, err = s.RestoreTransactions(ctx, archived)
+	require.NoError(t, err)
+	assert.Zero(t, restored)
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	require.Len(t, transactions, 3)
+	for i, actual := range transactions {
+		assert.Equal(t, archived[i].Id, actual.Id)
+		assert.Equal(t, archived[i].ParentId, actual.ParentId)
+		assert.Equal(t, archived[i].Status, actual.Status)
+		assert.True(t, archived[i].CreatedAt.Equal(actual.CreatedAt))
+	}
+
+	// transactions missing in the store are inserted as they were
+	createdAt := clock.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	imported := []model.Transaction{
+		newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 50),
+		newTransaction(m.Id, uuid.Nil, model.TransactionTypeReversal, 0),
+	}
+	imported[0].Id = uuid.New()
+	imported[0].Status = model.TransactionStatusReversed
+	imported[0].CreatedAt = createdAt
+	imported[1].Id = uuid.New()
+	imported[1].ParentId = imported[0].Id
+	imported[1].CreatedAt = createdAt
+
+	restored, err = s.RestoreTransactions(ctx, imported)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restored)
+
+	actual, err := s.GetTransaction(ctx, imported[1].Id)
+	require.NoError(t, err)
+	assert.Equal(t, imported[0].Id, actual.ParentId)
+	assert.Equal(t, m.Id, actual.MerchantId)
+	assert.True(t, createdAt.Equal(actual.CreatedAt))
+
+	actual, err = s.GetTransaction(ctx, imported[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.TransactionStatusReversed, actual.Status)
+}
+
+func testRestoreTransactionsAtomic(t *testing.T, s store.Store, clock *Clock) {
+	m, err := s.CreateMerchant(ctx, newMerchant("merchant@example.com"))
+	require.NoError(t, err)
+
+	authorize := newTransaction(m.Id, uuid.Nil, model.TransactionTypeAuthorize, 100)
+	authorize.Id = uuid.New()
+	authorize.CreatedAt = clock.Now()
+
+	orphan := newTransaction(m.Id, uuid.New(), model.TransactionTypeCharge, 100)
+	orphan.Id = uuid.New()
+	orphan.CreatedAt = clock.Now()
+
+	_, err = s.RestoreTransactions(ctx, []model.Transaction{authorize, orphan})
+	assert.ErrorIs(t, err, model.ErrParentNotFound)
+
+	unknown := authorize
+	unknown.Id = uuid.New()
+	unknown.MerchantId = uuid.New()
+
+	_, err = s.RestoreTransactions(ctx, []model.Transaction{authorize, unknown})
+	assert.ErrorIs(t, err, model.ErrMerchantNotFound)
+
+	transactions, err := s.GetTransactions(ctx, model.TransactionQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, transactions)
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+// TimeoutStore runs every call of the store it wraps under the deadline of
+// its operation, so a slow query is canceled instead of holding a request
+type TimeoutStore struct {
+	Store
+
+	timeout           time.Duration
+	operationTimeouts map[string]time.Duration
+}
+
+func WithTimeouts(s Store, settings model.StoreSettings) *TimeoutStore {
+	return &TimeoutStore{
+		Store:             s,
+		timeout:           settings.Timeout,
+		operationTimeouts: settings.OperationTimeouts,
+	}
+}
+
+// context derives the context of an operation from ctx, a zero timeout
+// keeps the deadline of ctx
+func (s *TimeoutStore) context(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	timeout, ok := s.operationTimeouts[operation]
+	if !ok {
+		timeout = s.timeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (s *TimeoutStore) CreateAdmin(ctx context.Context, a model.Admin) (model.Admin, error) {
+	ctx, cancel := s.context(ctx, "create_admin")
+	defer cancel()
+	return s.Store.CreateAdmin(ctx, a)
+}
+
+func (s *TimeoutStore) CreateMerchant(ctx context.Context, m model.Merchant) (model.Merchant, error) {
+	ctx, cancel := s.context(ctx, "create_merchant")
+	defer cancel()
+	return s.Store.CreateMerchant(ctx, m)
+}
+
+func (s *TimeoutStore) CreateMerchants(ctx context.Context, input []model.Merchant) ([]model.Merchant, error) {
+	ctx, cancel := s.context(ctx, "create_merchants")
+	defer cancel()
+	return s.Store.CreateMerchants(ctx, input)
+}
+
+func (s *TimeoutStore) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (model.Merchant, error) {
+	ctx, cancel := s.context(ctx, "update_merchant")
+	defer cancel()
+	return s.Store.UpdateMerchant(ctx, p)
+}
+
+func (s *TimeoutStore) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) error {
+	ctx, cancel := s.context(ctx, "delete_merchant")
+	defer cancel()
+	return s.Store.DeleteMerchant(ctx, id, version)
+}
+
+func (s *TimeoutStore) GetMerchant(ctx context.Context, id uuid.UUID) (model.Merchant, error) {
+	ctx, cancel := s.context(ctx, "get_merchant")
+	defer cancel()
+	return s.Store.GetMerchant(ctx, id)
+}
+
+func (s *TimeoutStore) GetMerchants(ctx context.Context, query model.MerchantQuery) ([]model.Merchant, error) {
+	ctx, cancel := s.context(ctx, "get_merchants")
+	defer cancel()
+	return s.Store.GetMerchants(ctx, query)
+}
+
+func (s *TimeoutStore) CreateTransaction(ctx context.Context, t model.Transaction) (model.Transaction, error) {
+	ctx, cancel := s.context(ctx, "create_transaction")
+	defer cancel()
+	return s.Store.CreateTransaction(ctx, t)
+}
+
+func (s *TimeoutStore) GetTransaction(ctx context.Context, id uuid.UUID) (model.Transaction, error) {
+	ctx, cancel := s.context(ctx, "get_transaction")
+	defer cancel()
+	return s.Store.GetTransaction(ctx, id)
+}
+
+func (s *TimeoutStore) GetTransactions(ctx context.Context, query model.TransactionQuery) ([]model.Transaction, error) {
+	ctx, cancel := s.context(ctx, "get_transactions")
+	defer cancel()
+	return s.Store.GetTransactions(ctx, query)
+}
+
+func (s *TimeoutStore) DeleteTransactions(ctx context.Context, query model.TransactionQuery) error {
+	ctx, cancel := s.context(ctx, "delete_transactions")
+	defer cancel()
+	return s.Store.DeleteTransactions(ctx, query)
+}
+
+func (s *TimeoutStore) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (int, error) {
+	ctx, cancel := s.context(ctx, "restore_transactions")
+	defer cancel()
+	return s.Store.RestoreTransactions(ctx, transactions)
+}
+
+func (s *TimeoutStore) EraseCustomer(ctx context.Context, e model.CustomerErasure) (model.Erasure, int, error) {
+	ctx, cancel := s.context(ctx, "erase_customer")
+	defer cancel()
+	return s.Store.EraseCustomer(ctx, e)
+}
+
+func (s *TimeoutStore) GetErasures(ctx context.Context) ([]model.Erasure, error) {
+	ctx, cancel := s.context(ctx, "get_erasures")
+	defer cancel()
+	return s.Store.GetErasures(ctx)
+}
+
+func (s *TimeoutStore) AppendAudit(ctx context.Context, e model.AuditEntry) (model.AuditEntry, error) {
+	ctx, cancel := s.context(ctx, "append_audit")
+	defer cancel()
+	return s.Store.AppendAudit(ctx, e)
+}
+
+func (s *TimeoutStore) GetAuditEntries(ctx context.Context, query model.AuditQuery) ([]model.AuditEntry, error) {
+	ctx, cancel := s.context(ctx, "get_audit_entries")
+	defer cancel()
+	return s.Store.GetAuditEntries(ctx, query)
+}
@@ -0,0 +1,55 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+	"github.com/ivaylo-todorov/payment-system/store/storetest"
+)
+
+func TestTimeoutStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock model.Clock) store.Store {
+		settings := model.StoreSettings{
+			DbPath:  filepath.Join(t.TempDir(), "payment_system.db"),
+			Timeout: time.Minute,
+		}
+		s, err := store.NewStore(settings, clock)
+		require.NoError(t, err)
+		return store.WithTimeouts(s, settings)
+	})
+}
+
+func TestTimeoutStoreDeadlines(t *testing.T) {
+	ctx := context.Background()
+
+	settings := model.StoreSettings{
+		DbPath:  filepath.Join(t.TempDir(), "payment_system.db"),
+		Timeout: time.Nanosecond,
+		OperationTimeouts: map[string]time.Duration{
+			"create_merchant": time.Minute,
+			// no deadline
+			"get_merchant": 0,
+		},
+	}
+	db, err := store.NewStore(settings, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := store.WithTimeouts(db, settings)
+
+	m, err := s.CreateMerchant(ctx, model.Merchant{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive})
+	require.NoError(t, err)
+
+	_, err = s.GetMerchant(ctx, m.Id)
+	assert.NoError(t, err)
+
+	_, err = s.GetMerchants(ctx, model.MerchantQuery{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
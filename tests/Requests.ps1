@@ -113,13 +113,15 @@ function PostMerchant {
         $Name,
         $Description,
         $Email,
-        $Status
+        $Status,
+        $Version
     )
 
     $Api = "http://$($Hostname):$Port/" + "merchants/" + $Id
 
     $Headers = @{
         'Content-Type'='application/json'
+        'If-Match'="`"$Version`""
     }
 
     $Merchant = @{
@@ -142,13 +144,15 @@ function DeleteMerchant {
         $Hostname = "localhost",
         $Port = 8080,
 
-        $Id
+        $Id,
+        $Version
     )
 
     $Api = "http://$($Hostname):$Port/" + "merchants"
 
     $Headers = @{
         'Content-Type'='application/json'
+        'If-Match'="`"$Version`""
     }
 
     $Merchant = @{
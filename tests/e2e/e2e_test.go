@@ -0,0 +1,147 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/server"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+// adminToken is the admin token of the servers started by newClient
+const adminToken = "admin-secret"
+
+// client talks to an in-process server backed by its own store,
+// so that tests can run in parallel
+type client struct {
+	t     *testing.T
+	url   string
+	token string
+}
+
+func newClient(t *testing.T) *client {
+	settings := model.ApplicationSettings{}
+	settings.ServerSettings.AdminToken = adminToken
+	return newClientWithSettings(t, settings)
+}
+
+func newClientWithSettings(t *testing.T, settings model.ApplicationSettings) *client {
+	t.Parallel()
+	return startClient(t, settings)
+}
+
+// newSerialClient is newClient for the tests changing process wide state,
+// such as the log level or the tracer provider. Go runs them before the
+// parallel tests start, so no other test sees the change.
+func newSerialClient(t *testing.T) *client {
+	settings := model.ApplicationSettings{}
+	settings.ServerSettings.AdminToken = adminToken
+	return startClient(t, settings)
+}
+
+func startClient(t *testing.T, settings model.ApplicationSettings) *client {
+	settings.StoreSettings.DbPath = filepath.Join(t.TempDir(), "e2e.db")
+
+	s, err := store.NewStore(settings.StoreSettings, nil)
+	require.NoError(t, err)
+
+	handler, err := server.NewHandler(settings, s, nil)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(handler)
+
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+
+	return &client{
+		t:     t,
+		url:   ts.URL,
+		token: settings.ServerSettings.AdminToken,
+	}
+}
+
+type response struct {
+	*http.Response
+	body []byte
+}
+
+func (c *client) do(method, path, contentType string, body []byte, headers ...string) response {
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(body))
+	require.NoError(c.t, err)
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(c.t, err)
+
+	return response{Response: resp, body: data}
+}
+
+func (c *client) doJSON(method, path string, v any, headers ...string) response {
+	body, err := json.Marshal(v)
+	require.NoError(c.t, err)
+	return c.do(method, path, server.ContentTypeJSON, body, headers...)
+}
+
+// admin sends the request with the admin token of the server
+func (c *client) admin(method, path, contentType string, body []byte, headers ...string) response {
+	return c.do(method, path, contentType, body, append(headers, "Authorization", "Bearer "+c.token)...)
+}
+
+func (c *client) adminJSON(method, path string, v any, headers ...string) response {
+	return c.doJSON(method, path, v, append(headers, "Authorization", "Bearer "+c.token)...)
+}
+
+func (c *client) createMerchant(name, status string) server.Merchant {
+	csv := fmt.Sprintf("%s, , %s@email.com, %s\n", name, name, status)
+
+	resp := c.admin(http.MethodPost, "/v1/merchants", "text/csv", []byte(csv))
+	require.Equal(c.t, http.StatusCreated, resp.StatusCode, string(resp.body))
+
+	var r server.MerchantResponse
+	require.NoError(c.t, json.Unmarshal(resp.body, &r))
+	require.Len(c.t, r.Merchants, 1)
+
+	return r.Merchants[0]
+}
+
+func (c *client) postTransaction(t server.Transaction) (server.Transaction, response) {
+	resp := c.doJSON(http.MethodPost, "/v1/transactions", server.TransactionRequest{Transaction: t})
+	if resp.StatusCode != http.StatusOK {
+		return server.Transaction{}, resp
+	}
+
+	var r server.TransactionResponse
+	require.NoError(c.t, json.Unmarshal(resp.body, &r))
+	require.Len(c.t, r.Transactions, 1)
+
+	return r.Transactions[0], resp
+}
+
+func (c *client) problem(resp response) server.Problem {
+	require.Equal(c.t, server.ContentTypeProblem, resp.Header.Get("Content-Type"))
+
+	var p server.Problem
+	require.NoError(c.t, json.Unmarshal(resp.body, &p))
+	return p
+}
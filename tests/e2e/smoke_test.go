@@ -0,0 +1,855 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ivaylo-todorov/payment-system/archive"
+	"github.com/ivaylo-todorov/payment-system/keyring"
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/server"
+)
+
+func TestAuthorizeChargeRefund(t *testing.T) {
+	c := newClient(t)
+
+	merchant := c.createMerchant("merchant_one", model.MerchantStatusActive)
+
+	authorize, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.Equal(t, model.TransactionStatusApproved, authorize.Status)
+
+	charge, resp := c.postTransaction(server.Transaction{
+		ParentId:      authorize.Id,
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeCharge,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.Equal(t, model.TransactionStatusApproved, charge.Status)
+
+	resp = c.do(http.MethodGet, "/v1/merchants/"+merchant.Id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var m server.Merchant
+	require.NoError(t, json.Unmarshal(resp.body, &m))
+	assert.Equal(t, int64(100), m.TransactionsAmount)
+
+	refund, resp := c.postTransaction(server.Transaction{
+		ParentId:      charge.Id,
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeRefund,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.Equal(t, model.TransactionStatusRefunded, refund.Status)
+
+	resp = c.do(http.MethodGet, "/v1/transactions/"+charge.Id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tr server.Transaction
+	require.NoError(t, json.Unmarshal(resp.body, &tr))
+	assert.Equal(t, model.TransactionStatusRefunded, tr.Status)
+	assert.Equal(t, merchant.Id, tr.MerchantId)
+}
+
+func TestAuthorizeReversal(t *testing.T) {
+	c := newClient(t)
+
+	merchant := c.createMerchant("merchant_two", model.MerchantStatusInactive)
+
+	_, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "merchant_not_active", c.problem(resp).Code)
+
+	resp = c.admin(http.MethodPatch, "/v1/merchants/"+merchant.Id, server.ContentTypeMergePatch,
+		[]byte(`{"status": "active"}`), "If-Match", fmt.Sprintf(`"%d"`, merchant.Version))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	authorize, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	reversal, resp := c.postTransaction(server.Transaction{
+		ParentId:      authorize.Id,
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeReversal,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.Equal(t, model.TransactionStatusReversed, reversal.Status)
+
+	// a reversed authorization cannot be charged
+	charge, resp := c.postTransaction(server.Transaction{
+		ParentId:      authorize.Id,
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeCharge,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.Equal(t, model.TransactionStatusError, charge.Status)
+}
+
+func TestMerchantUpdateConflict(t *testing.T) {
+	c := newClient(t)
+
+	merchant := c.createMerchant("merchant", model.MerchantStatusActive)
+	path := "/v1/merchants/" + merchant.Id
+	etag := fmt.Sprintf(`"%d"`, merchant.Version)
+
+	resp := c.admin(http.MethodPatch, path, server.ContentTypeMergePatch, []byte(`{"description": "first"}`))
+	assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch, []byte(`{"description": "first"}`), "If-Match", etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	newETag := resp.Header.Get("ETag")
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch, []byte(`{"description": "second"}`), "If-Match", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = c.do(http.MethodGet, path, "", nil, "If-None-Match", newETag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch, []byte(`{"description": null}`), "If-Match", newETag)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var m server.Merchant
+	require.NoError(t, json.Unmarshal(resp.body, &m))
+	assert.Empty(t, m.Description)
+
+	resp = c.admin(http.MethodDelete, path, "", nil, "If-Match", resp.Header.Get("ETag"))
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = c.do(http.MethodGet, path, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestMerchantSuspendAndClose(t *testing.T) {
+	c := newClient(t)
+
+	merchant := c.createMerchant("merchant_lifecycle", model.MerchantStatusActive)
+	path := "/v1/merchants/" + merchant.Id
+
+	authorize, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch,
+		[]byte(`{"status": "suspended"}`), "If-Match", fmt.Sprintf(`"%d"`, merchant.Version))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch,
+		[]byte(`{"status": "suspended", "status_reason": "fraud review"}`), "If-Match", fmt.Sprintf(`"%d"`, merchant.Version))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var m server.Merchant
+	require.NoError(t, json.Unmarshal(resp.body, &m))
+	assert.Equal(t, model.MerchantStatusSuspended, m.Status)
+	assert.Equal(t, "fraud review", m.StatusReason)
+	assert.NotNil(t, m.StatusChangedAt)
+
+	_, resp = c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "merchant_suspended", c.problem(resp).Code)
+
+	// held funds can still be released
+	_, resp = c.postTransaction(server.Transaction{
+		ParentId:      authorize.Id,
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeReversal,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch,
+		[]byte(`{"status": "closed", "status_reason": "requested"}`), "If-Match", fmt.Sprintf(`"%d"`, m.Version))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	require.NoError(t, json.Unmarshal(resp.body, &m))
+	assert.Equal(t, model.MerchantStatusClosed, m.Status)
+	assert.NotEqual(t, merchant.Email, m.Email)
+
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch,
+		[]byte(`{"status": "active"}`), "If-Match", fmt.Sprintf(`"%d"`, m.Version))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "merchant_closed", c.problem(resp).Code)
+
+	// the email of a closed merchant is free again
+	c.createMerchant("merchant_lifecycle", model.MerchantStatusPending)
+}
+
+func TestMerchantImport(t *testing.T) {
+	c := newClient(t)
+
+	csv := "name,email,status\none,one@email.com,active\n,two@email.com,active\nthree,one@email.com,active\n"
+
+	resp := c.admin(http.MethodPost, "/v1/merchants?atomic=true", "text/csv", []byte(csv))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	p := c.problem(resp)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, 2, p.Errors[0].Row)
+
+	resp = c.admin(http.MethodPost, "/v1/merchants", "text/csv", []byte(csv))
+	require.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+
+	var r server.MerchantResponse
+	require.NoError(t, json.Unmarshal(resp.body, &r))
+	require.Len(t, r.Results, 3)
+	assert.NotEmpty(t, r.Results[0].Id)
+	assert.Equal(t, "required", r.Results[1].Errors[0].Code)
+	assert.Equal(t, "email_already_exists", r.Results[2].Errors[0].Code)
+	assert.Len(t, r.Merchants, 1)
+}
+
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.do(http.MethodGet, "/merchants", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+
+	assert.Equal(t, `</v1/merchants>; rel="successor-version"; title="GET"`, resp.Header.Get("Link"))
+
+	resp = c.do(http.MethodGet, "/v1/merchants", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Deprecation"))
+
+	// the successors of the legacy merchant updates take the id to the path
+	merchant := c.createMerchant("merchant_legacy", model.MerchantStatusActive)
+
+	resp = c.adminJSON(http.MethodPost, "/merchants/"+merchant.Id, server.MerchantRequest{Merchant: merchant})
+	assert.Equal(t, `</v1/merchants/`+merchant.Id+`>; rel="successor-version"; title="PATCH"`, resp.Header.Get("Link"))
+
+	resp = c.adminJSON(http.MethodDelete, "/merchants", server.MerchantRequest{Merchant: merchant})
+	assert.Equal(t, `</v1/merchants/`+merchant.Id+`>; rel="successor-version"; title="DELETE"`, resp.Header.Get("Link"))
+}
+
+func TestLegacyMerchantWritesRequireIfMatch(t *testing.T) {
+	c := newClient(t)
+
+	merchant := c.createMerchant("merchant_legacy_if_match", model.MerchantStatusActive)
+	merchant.Description = "updated"
+
+	resp := c.adminJSON(http.MethodPost, "/merchants/"+merchant.Id, server.MerchantRequest{Merchant: merchant})
+	require.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	assert.Equal(t, "if_match_required", c.problem(resp).Code)
+
+	resp = c.adminJSON(http.MethodDelete, "/merchants", server.MerchantRequest{Merchant: merchant})
+	require.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	assert.Equal(t, "if_match_required", c.problem(resp).Code)
+
+	resp = c.adminJSON(http.MethodPost, "/merchants/"+merchant.Id, server.MerchantRequest{Merchant: merchant},
+		"If-Match", fmt.Sprintf(`"%d"`, merchant.Version+1))
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode, string(resp.body))
+
+	resp = c.adminJSON(http.MethodPost, "/merchants/"+merchant.Id, server.MerchantRequest{Merchant: merchant},
+		"If-Match", fmt.Sprintf(`"%d"`, merchant.Version))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var updated server.MerchantResponse
+	require.NoError(t, json.Unmarshal(resp.body, &updated))
+	require.Len(t, updated.Merchants, 1)
+	assert.Equal(t, "updated", updated.Merchants[0].Description)
+
+	resp = c.adminJSON(http.MethodDelete, "/merchants", server.MerchantRequest{Merchant: updated.Merchants[0]},
+		"If-Match", resp.Header.Get("ETag"))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.admin(http.MethodGet, "/v1/admin/retention", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "no_retention_run", c.problem(resp).Code)
+
+	merchant := c.createMerchant("merchant_retention", model.MerchantStatusActive)
+
+	_, resp = c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.admin(http.MethodPost, "/v1/admin/retention/dry-run", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var report server.RetentionReport
+	require.NoError(t, json.Unmarshal(resp.body, &report))
+	assert.True(t, report.DryRun)
+	// without a retention period no transaction is old enough to be read
+	assert.Zero(t, report.Chains)
+	assert.Zero(t, report.PurgedChains)
+}
+
+func TestAdminRoutesUnauthorized(t *testing.T) {
+	c := newClient(t)
+
+	routes := []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/v1/admin/retention"},
+		{http.MethodPost, "/v1/admin/retention/dry-run"},
+		{http.MethodPost, "/v1/admin/restore"},
+		{http.MethodGet, "/v1/admin/erasures"},
+		{http.MethodPost, "/v1/admin/erasures"},
+		{http.MethodGet, "/v1/admin/reencryption"},
+		{http.MethodPost, "/v1/admin/reencryption"},
+		{http.MethodGet, "/v1/audit"},
+		{http.MethodGet, "/v1/admin/log-level"},
+		{http.MethodPut, "/v1/admin/log-level"},
+		{http.MethodGet, "/debug/info"},
+		{http.MethodPost, "/v1/admins"},
+		{http.MethodPost, "/v1/merchants"},
+		{http.MethodPatch, "/v1/merchants/" + uuid.NewString()},
+		{http.MethodDelete, "/v1/merchants/" + uuid.NewString()},
+		{http.MethodPost, "/admins"},
+		{http.MethodPost, "/merchants"},
+		{http.MethodPost, "/merchants/" + uuid.NewString()},
+		{http.MethodDelete, "/merchants"},
+	}
+
+	for _, route := range routes {
+		resp := c.do(route.method, route.path, server.ContentTypeJSON, []byte(`{}`))
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "%s %s", route.method, route.path)
+		assert.Equal(t, "unauthorized", c.problem(resp).Code, "%s %s", route.method, route.path)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestCustomerErasureDisabled(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.adminJSON(http.MethodPost, "/v1/admin/erasures", server.ErasureRequest{CustomerEmail: "customer@email.com"})
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "erasure_disabled", c.problem(resp).Code)
+}
+
+func TestCustomerErasure(t *testing.T) {
+	c := newClientWithSettings(t, model.ApplicationSettings{
+		ServerSettings:  model.ServerSettings{AdminToken: adminToken},
+		PrivacySettings: model.PrivacySettings{ErasureKey: "0123456789abcdef0123456789abcdef"},
+	})
+
+	merchant := c.createMerchant("merchant_erasure", model.MerchantStatusActive)
+
+	authorize, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+		CustomerPhone: "+359888123456",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	charge, resp := c.postTransaction(server.Transaction{
+		ParentId:      authorize.Id,
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeCharge,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.adminJSON(http.MethodPost, "/v1/admin/erasures", server.ErasureRequest{CustomerEmail: "Customer@Email.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var erasure server.ErasureResponse
+	require.NoError(t, json.Unmarshal(resp.body, &erasure))
+	assert.Equal(t, 2, erasure.Erased)
+	assert.Equal(t, 2, erasure.Erasure.Transactions)
+	assert.NotContains(t, string(resp.body), "customer")
+
+	resp = c.do(http.MethodGet, "/v1/transactions/"+charge.Id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var tr server.Transaction
+	require.NoError(t, json.Unmarshal(resp.body, &tr))
+	assert.True(t, model.IsErasedEmail(tr.CustomerEmail), tr.CustomerEmail)
+	assert.Equal(t, authorize.Id, tr.ParentId)
+	assert.Equal(t, int64(100), tr.Amount)
+
+	resp = c.do(http.MethodGet, "/v1/transactions/"+authorize.Id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, string(resp.body), "customer@email.com")
+	assert.NotContains(t, string(resp.body), "888123456")
+
+	// the erasure is idempotent
+	resp = c.adminJSON(http.MethodPost, "/v1/admin/erasures", server.ErasureRequest{CustomerEmail: "customer@email.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var again server.ErasureResponse
+	require.NoError(t, json.Unmarshal(resp.body, &again))
+	assert.Zero(t, again.Erased)
+	assert.Equal(t, erasure.Erasure, again.Erasure)
+
+	resp = c.admin(http.MethodGet, "/v1/admin/erasures", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var erasures server.ErasuresResponse
+	require.NoError(t, json.Unmarshal(resp.body, &erasures))
+	require.Len(t, erasures.Erasures, 1)
+	assert.Equal(t, erasure.Erasure.Id, erasures.Erasures[0].Id)
+
+	// every erasure is in the audit log, with the token and not the email
+	resp = c.admin(http.MethodGet, "/v1/audit?action="+model.AuditActionCustomerErase, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, string(resp.body), "customer@email.com")
+
+	var audit server.AuditResponse
+	require.NoError(t, json.Unmarshal(resp.body, &audit))
+	require.Len(t, audit.Entries, 2)
+	assert.Equal(t, erasure.Erasure.Id, audit.Entries[0].TargetId)
+
+	resp = c.adminJSON(http.MethodPost, "/v1/admin/erasures", server.ErasureRequest{CustomerEmail: "customer"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCustomerErasureArchives(t *testing.T) {
+	k, err := keyring.New()
+	require.NoError(t, err)
+	keyringFile := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, k.Save(keyringFile))
+	dir := t.TempDir()
+
+	c := newClientWithSettings(t, model.ApplicationSettings{
+		ServerSettings:  model.ServerSettings{AdminToken: adminToken},
+		StoreSettings:   model.StoreSettings{KeyringFile: keyringFile},
+		CleanupSettings: model.CleanupSettings{ArchiveDir: dir},
+		PrivacySettings: model.PrivacySettings{ErasureKey: "0123456789abcdef0123456789abcdef"},
+	})
+
+	merchant := c.createMerchant("merchant_erasure_archives", model.MerchantStatusActive)
+
+	// an earlier cleanup run archived a chain of the customer
+	authorize := model.Transaction{
+		Id:            uuid.New(),
+		MerchantId:    uuid.MustParse(merchant.Id),
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		Status:        model.TransactionStatusApproved,
+		CustomerEmail: "customer@email.com",
+		CustomerPhone: "+359888123456",
+		CreatedAt:     time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC),
+	}
+	charge := authorize
+	charge.Id = uuid.New()
+	charge.ParentId = authorize.Id
+	charge.Type = model.TransactionTypeCharge
+	charge.CreatedAt = authorize.CreatedAt.Add(time.Hour)
+
+	a := archive.New(dir, k, nil)
+	manifest, err := a.Write([][]model.Transaction{{authorize, charge}})
+	require.NoError(t, err)
+
+	resp := c.adminJSON(http.MethodPost, "/v1/admin/erasures", server.ErasureRequest{CustomerEmail: "customer@email.com"})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var erasure server.ErasureResponse
+	require.NoError(t, json.Unmarshal(resp.body, &erasure))
+	assert.Zero(t, erasure.Erased)
+	assert.Equal(t, 2, erasure.Archived)
+
+	// the archive still verifies and holds only the tokens
+	_, transactions, err := a.Read(manifest)
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	for _, tr := range transactions {
+		assert.True(t, model.IsErasedEmail(tr.CustomerEmail), tr.CustomerEmail)
+	}
+	assert.NotContains(t, transactions[0].CustomerPhone, "888123456")
+	assert.Equal(t, charge.Id, transactions[1].Id)
+	assert.Equal(t, authorize.Id, transactions[1].ParentId)
+
+	// a restore brings back the erased chain
+	resp = c.adminJSON(http.MethodPost, "/v1/admin/restore", server.RestoreRequest{Manifest: manifest})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.do(http.MethodGet, "/v1/transactions/"+authorize.Id.String(), "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, string(resp.body), "customer@email.com")
+	assert.NotContains(t, string(resp.body), "888123456")
+}
+
+func TestReencryptionDisabled(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.admin(http.MethodPost, "/v1/admin/reencryption", "", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "encryption_disabled", c.problem(resp).Code)
+}
+
+func TestReencryption(t *testing.T) {
+	k, err := keyring.New()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	require.NoError(t, k.Save(path))
+
+	c := newClientWithSettings(t, model.ApplicationSettings{
+		ServerSettings: model.ServerSettings{AdminToken: adminToken},
+		StoreSettings:  model.StoreSettings{KeyringFile: path},
+	})
+
+	resp := c.admin(http.MethodGet, "/v1/admin/reencryption", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "no_reencryption_run", c.problem(resp).Code)
+
+	merchant := c.createMerchant("merchant_encrypted", model.MerchantStatusActive)
+	assert.Equal(t, "merchant_encrypted@email.com", merchant.Email)
+
+	authorize, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.Equal(t, "customer@email.com", authorize.CustomerEmail)
+
+	resp = c.admin(http.MethodPost, "/v1/admin/reencryption", "", nil)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, string(resp.body))
+
+	var progress server.ReencryptionProgress
+	require.Eventually(t, func() bool {
+		resp := c.admin(http.MethodGet, "/v1/admin/reencryption", "", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.Unmarshal(resp.body, &progress))
+		return !progress.Running
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// everything was encrypted when written
+	assert.Empty(t, progress.Error)
+	assert.Equal(t, 1, progress.KeyVersion)
+	assert.Zero(t, progress.Total)
+	assert.Zero(t, progress.Remaining)
+
+	resp = c.do(http.MethodGet, "/v1/transactions/"+authorize.Id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(resp.body), "customer@email.com")
+}
+
+func TestAuditLog(t *testing.T) {
+	c := newClient(t)
+
+	csv := "audited, , audited@email.com, active\n"
+	resp := c.admin(http.MethodPost, "/v1/merchants", "text/csv", []byte(csv), "X-Actor", "ops", "X-Request-ID", "request-1")
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(resp.body))
+
+	var created server.MerchantResponse
+	require.NoError(t, json.Unmarshal(resp.body, &created))
+	merchant := created.Merchants[0]
+
+	path := "/v1/merchants/" + merchant.Id
+	resp = c.admin(http.MethodPatch, path, server.ContentTypeMergePatch, []byte(`{"status": "suspended", "status_reason": "review"}`),
+		"If-Match", fmt.Sprintf(`"%d"`, merchant.Version))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.admin(http.MethodDelete, path, "", nil, "If-Match", resp.Header.Get("ETag"), "X-Actor", "ops")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, string(resp.body))
+
+	audit := func(query string) []server.AuditEntry {
+		resp := c.admin(http.MethodGet, "/v1/audit"+query, "", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+		var r server.AuditResponse
+		require.NoError(t, json.Unmarshal(resp.body, &r))
+		return r.Entries
+	}
+
+	entries := audit("")
+	require.Len(t, entries, 3)
+	assert.Equal(t, model.AuditActionMerchantCreate, entries[0].Action)
+	assert.Equal(t, "ops", entries[0].Actor)
+	assert.True(t, entries[0].Authenticated)
+	assert.Equal(t, "request-1", entries[0].RequestId)
+	assert.Equal(t, merchant.Id, entries[0].TargetId)
+	assert.Empty(t, entries[1].Actor)
+	assert.True(t, entries[1].Authenticated)
+	assert.Contains(t, entries[1].Changes, server.AuditChange{Field: "status", Before: "active", After: "suspended"})
+	assert.Contains(t, entries[0].Changes, server.AuditChange{Field: "email", After: "redacted"})
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+
+	assert.Len(t, audit("?actor=ops"), 2)
+	assert.Len(t, audit("?action="+model.AuditActionMerchantDelete+"&target_uuid="+merchant.Id), 1)
+	assert.Empty(t, audit("?since=2999-01-01T00:00:00Z"))
+
+	resp = c.admin(http.MethodGet, "/v1/audit?since=yesterday", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_time", c.problem(resp).Code)
+}
+
+func TestMetrics(t *testing.T) {
+	c := newClient(t)
+
+	merchant := c.createMerchant("merchant_metrics", model.MerchantStatusActive)
+
+	_, resp := c.postTransaction(server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	resp = c.do(http.MethodGet, "/v1/merchants/"+merchant.Id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = c.do(http.MethodGet, "/v1/merchants/"+uuid.NewString(), "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = c.do(http.MethodGet, "/metrics", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+
+	body := string(resp.body)
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/v1/merchants/{id}",status="200"} 1`,
+		`http_requests_total{method="GET",route="/v1/merchants/{id}",status="404"} 1`,
+		`http_request_duration_seconds_count{method="POST",route="/v1/transactions",status="200"} 1`,
+		`payment_transactions_created_total{merchant_status="active",status="approved",type="authorize"} 1`,
+		`payment_start_transaction_duration_seconds_count 1`,
+		`payment_store_query_duration_seconds_count{operation="create_transaction"} 1`,
+		`payment_cleanup_runs_total 0`,
+		`go_sql_max_open_connections{db_name="payment_system"}`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.do(http.MethodGet, "/v1/merchants", "", nil, "X-Request-ID", "request-1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "request-1", resp.Header.Get("X-Request-ID"))
+
+	resp = c.do(http.MethodGet, "/v1/merchants/"+uuid.NewString(), "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_, err := uuid.Parse(resp.Header.Get("X-Request-ID"))
+	assert.NoError(t, err)
+}
+
+func TestLogLevel(t *testing.T) {
+	c := newSerialClient(t)
+
+	resp := c.admin(http.MethodPut, "/v1/admin/log-level", server.ContentTypeJSON, []byte(`{"level": "debug"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	t.Cleanup(func() {
+		c.admin(http.MethodPut, "/v1/admin/log-level", server.ContentTypeJSON, []byte(`{"level": "info"}`))
+	})
+
+	resp = c.admin(http.MethodGet, "/v1/admin/log-level", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"level": "debug"}`, string(resp.body))
+
+	resp = c.admin(http.MethodPut, "/v1/admin/log-level", server.ContentTypeJSON, []byte(`{"level": "verbose"}`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_log_level", c.problem(resp).Code)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+	})
+
+	c := newSerialClient(t)
+	merchant := c.createMerchant("merchant_tracing", model.MerchantStatusActive)
+
+	body, err := json.Marshal(server.TransactionRequest{Transaction: server.Transaction{
+		MerchantId:    merchant.Id,
+		Type:          model.TransactionTypeAuthorize,
+		Amount:        100,
+		CustomerEmail: "customer@email.com",
+	}})
+	require.NoError(t, err)
+
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	resp := c.do(http.MethodPost, "/v1/transactions", server.ContentTypeJSON, body,
+		"traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	names := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceId {
+			names[span.Name()] = true
+		}
+	}
+	for _, name := range []string{
+		"POST /v1/transactions",
+		"controller.StartTransaction",
+		"store.CreateTransaction",
+		"sql.create",
+	} {
+		assert.True(t, names[name], "no %s span in %v", name, names)
+	}
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.do(http.MethodGet, "/healthz", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = c.do(http.MethodGet, "/readyz", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+
+	var readiness server.Readiness
+	require.NoError(t, json.Unmarshal(resp.body, &readiness))
+	assert.True(t, readiness.Ready)
+
+	statuses := map[string]string{}
+	for _, check := range readiness.Checks {
+		statuses[check.Name] = check.Status
+	}
+	assert.Equal(t, map[string]string{
+		"database":   server.CheckStatusOk,
+		"migrations": server.CheckStatusOk,
+		// the cleanup is not started by the handler
+		"cleanup": server.CheckStatusSkipped,
+	}, statuses)
+}
+
+func TestDebugInfoDisabled(t *testing.T) {
+	c := newClientWithSettings(t, model.ApplicationSettings{})
+
+	resp := c.do(http.MethodGet, "/debug/info", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "admin_token_unset", c.problem(resp).Code)
+}
+
+func TestDebugInfo(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.do(http.MethodGet, "/debug/info", "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = c.do(http.MethodGet, "/debug/info", "", nil, "Authorization", "Bearer wrong")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "unauthorized", c.problem(resp).Code)
+
+	c.createMerchant("merchant_debug", model.MerchantStatusActive)
+
+	resp = c.admin(http.MethodGet, "/debug/info", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(resp.body))
+	assert.NotContains(t, string(resp.body), adminToken)
+
+	var info server.DebugInfo
+	require.NoError(t, json.Unmarshal(resp.body, &info))
+	assert.NotEmpty(t, info.Build.GoVersion)
+	assert.NotEmpty(t, info.Uptime)
+	assert.Positive(t, info.DbFileSize)
+	assert.Equal(t, int64(1), info.TableRows["merchants"])
+	assert.Contains(t, info.Config, "server")
+	assert.Nil(t, info.LastCleanup)
+}
+
+func TestBodyLimits(t *testing.T) {
+	settings := model.ApplicationSettings{}
+	settings.ServerSettings.AdminToken = adminToken
+	settings.ServerSettings.MaxBodySize = 256
+	settings.ServerSettings.MaxImportSize = 4096
+	c := newClientWithSettings(t, settings)
+
+	large := []byte(`{"transaction": {"type": "authorize", "customer_email": "` + strings.Repeat("a", 512) + `@example.com"}}`)
+	resp := c.do(http.MethodPost, "/v1/transactions", server.ContentTypeJSON, large)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, string(resp.body))
+	assert.Equal(t, "body_too_large", c.problem(resp).Code)
+
+	// imports have their own limit
+	csv := "name,description,email,status\n"
+	for n := 0; n < 10; n++ {
+		csv += fmt.Sprintf("merchant_%d, , merchant_%d@email.com, active\n", n, n)
+	}
+	require.Greater(t, len(csv), 256)
+	resp = c.admin(http.MethodPost, "/v1/merchants", "text/csv", []byte(csv))
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(resp.body))
+
+	resp = c.admin(http.MethodPost, "/v1/merchants", "text/csv", []byte(strings.Repeat(csv, 20)))
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	c := newClient(t)
+
+	body := []byte(`{"transaction": {"type": "authorize"}}`)
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		resp := c.do(http.MethodPost, "/v1/transactions", contentType, body)
+		require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode, contentType)
+		assert.Equal(t, "unsupported_media_type", c.problem(resp).Code)
+	}
+
+	resp := c.admin(http.MethodPost, "/v1/merchants", "", []byte("name, , name@email.com, active\n"))
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp = c.admin(http.MethodPost, "/v1/admins", server.ContentTypeJSON, []byte(`{}`))
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	resp = c.do(http.MethodPost, "/v1/transactions", "application/json; charset=utf-8", body)
+	assert.NotEqual(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+}
+
+func TestRenderedPages(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.admin(http.MethodPost, "/v1/merchants", server.ContentTypeJSON,
+		[]byte(`{"merchants": [{"name": "<script>alert(1)</script>", "email": "page@email.com", "status": "active"}]}`))
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(resp.body))
+
+	for _, path := range []string{"/v1/merchants?render", "/v1/transactions?render"} {
+		resp := c.do(http.MethodGet, path, "", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "default-src 'none'")
+		assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+		assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+		assert.Equal(t, "no-referrer", resp.Header.Get("Referrer-Policy"))
+		assert.NotContains(t, string(resp.body), "<script>")
+	}
+
+	resp = c.do(http.MethodGet, "/v1/merchants?render", "", nil)
+	assert.Contains(t, string(resp.body), "&lt;script&gt;")
+}
@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/model/controller"
+)
+
+// Controller records a span for every call of the controller it wraps
+type Controller struct {
+	controller.Controller
+}
+
+func InstrumentController(c controller.Controller) *Controller {
+	return &Controller{Controller: c}
+}
+
+func (c *Controller) CreateAdmins(ctx context.Context, actor model.Actor, input []model.Admin) (_ []model.Admin, err error) {
+	ctx, span := Start(ctx, "controller.CreateAdmins")
+	defer func() { End(span, err) }()
+	return c.Controller.CreateAdmins(ctx, actor, input)
+}
+
+func (c *Controller) CreateMerchants(ctx context.Context, actor model.Actor, input []model.Merchant) (_ []model.Merchant, err error) {
+	ctx, span := Start(ctx, "controller.CreateMerchants")
+	defer func() { End(span, err) }()
+	return c.Controller.CreateMerchants(ctx, actor, input)
+}
+
+func (c *Controller) ImportMerchants(ctx context.Context, actor model.Actor, input []model.Merchant) []model.MerchantImportResult {
+	ctx, span := Start(ctx, "controller.ImportMerchants", trace.WithAttributes(attribute.Int("rows", len(input))))
+	defer span.End()
+	return c.Controller.ImportMerchants(ctx, actor, input)
+}
+
+func (c *Controller) UpdateMerchant(ctx context.Context, actor model.Actor, merchant model.Merchant) (_ model.Merchant, err error) {
+	ctx, span := Start(ctx, "controller.UpdateMerchant")
+	defer func() { End(span, err) }()
+	return c.Controller.UpdateMerchant(ctx, actor, merchant)
+}
+
+func (c *Controller) PatchMerchant(ctx context.Context, actor model.Actor, patch model.MerchantPatch) (_ model.Merchant, err error) {
+	ctx, span := Start(ctx, "controller.PatchMerchant")
+	defer func() { End(span, err) }()
+	return c.Controller.PatchMerchant(ctx, actor, patch)
+}
+
+func (c *Controller) DeleteMerchant(ctx context.Context, actor model.Actor, merchant model.Merchant) (err error) {
+	ctx, span := Start(ctx, "controller.DeleteMerchant")
+	defer func() { End(span, err) }()
+	return c.Controller.DeleteMerchant(ctx, actor, merchant)
+}
+
+func (c *Controller) GetMerchant(ctx context.Context, id uuid.UUID) (_ model.Merchant, err error) {
+	ctx, span := Start(ctx, "controller.GetMerchant")
+	defer func() { End(span, err) }()
+	return c.Controller.GetMerchant(ctx, id)
+}
+
+func (c *Controller) GetMerchants(ctx context.Context, query model.MerchantQuery) (_ []model.Merchant, err error) {
+	ctx, span := Start(ctx, "controller.GetMerchants")
+	defer func() { End(span, err) }()
+	return c.Controller.GetMerchants(ctx, query)
+}
+
+func (c *Controller) StartTransaction(ctx context.Context, transaction model.Transaction) (_ model.Transaction, err error) {
+	ctx, span := Start(ctx, "controller.StartTransaction")
+	defer func() { End(span, err) }()
+	return c.Controller.StartTransaction(ctx, transaction)
+}
+
+func (c *Controller) GetTransaction(ctx context.Context, id uuid.UUID) (_ model.Transaction, err error) {
+	ctx, span := Start(ctx, "controller.GetTransaction")
+	defer func() { End(span, err) }()
+	return c.Controller.GetTransaction(ctx, id)
+}
+
+func (c *Controller) GetTransactions(ctx context.Context, query model.TransactionQuery) (_ []model.Transaction, err error) {
+	ctx, span := Start(ctx, "controller.GetTransactions")
+	defer func() { End(span, err) }()
+	return c.Controller.GetTransactions(ctx, query)
+}
+
+func (c *Controller) DeleteTransactions(ctx context.Context, query model.TransactionQuery) (err error) {
+	ctx, span := Start(ctx, "controller.DeleteTransactions")
+	defer func() { End(span, err) }()
+	return c.Controller.DeleteTransactions(ctx, query)
+}
+
+func (c *Controller) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (_ int, err error) {
+	ctx, span := Start(ctx, "controller.RestoreTransactions")
+	defer func() { End(span, err) }()
+	return c.Controller.RestoreTransactions(ctx, transactions)
+}
+
+func (c *Controller) EraseCustomer(ctx context.Context, actor model.Actor, email string) (_ model.Erasure, _ int, err error) {
+	ctx, span := Start(ctx, "controller.EraseCustomer")
+	defer func() { End(span, err) }()
+	return c.Controller.EraseCustomer(ctx, actor, email)
+}
+
+func (c *Controller) GetErasures(ctx context.Context) (_ []model.Erasure, err error) {
+	ctx, span := Start(ctx, "controller.GetErasures")
+	defer func() { End(span, err) }()
+	return c.Controller.GetErasures(ctx)
+}
+
+func (c *Controller) GetAuditEntries(ctx context.Context, query model.AuditQuery) (_ []model.AuditEntry, err error) {
+	ctx, span := Start(ctx, "controller.GetAuditEntries")
+	defer func() { End(span, err) }()
+	return c.Controller.GetAuditEntries(ctx, query)
+}
+
+func (c *Controller) VerifyAudit(ctx context.Context) (_ model.AuditVerification, err error) {
+	ctx, span := Start(ctx, "controller.VerifyAudit")
+	defer func() { End(span, err) }()
+	return c.Controller.VerifyAudit(ctx)
+}
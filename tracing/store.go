@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/store"
+)
+
+// Store records a span for every call of the store it wraps
+type Store struct {
+	store.Store
+}
+
+func InstrumentStore(s store.Store) *Store {
+	return &Store{Store: s}
+}
+
+func (s *Store) CreateAdmin(ctx context.Context, a model.Admin) (_ model.Admin, err error) {
+	ctx, span := Start(ctx, "store.CreateAdmin")
+	defer func() { End(span, err) }()
+	return s.Store.CreateAdmin(ctx, a)
+}
+
+func (s *Store) CreateMerchant(ctx context.Context, m model.Merchant) (_ model.Merchant, err error) {
+	ctx, span := Start(ctx, "store.CreateMerchant")
+	defer func() { End(span, err) }()
+	return s.Store.CreateMerchant(ctx, m)
+}
+
+func (s *Store) CreateMerchants(ctx context.Context, input []model.Merchant) (_ []model.Merchant, err error) {
+	ctx, span := Start(ctx, "store.CreateMerchants")
+	defer func() { End(span, err) }()
+	return s.Store.CreateMerchants(ctx, input)
+}
+
+func (s *Store) UpdateMerchant(ctx context.Context, p model.MerchantPatch) (_ model.Merchant, err error) {
+	ctx, span := Start(ctx, "store.UpdateMerchant")
+	defer func() { End(span, err) }()
+	return s.Store.UpdateMerchant(ctx, p)
+}
+
+func (s *Store) DeleteMerchant(ctx context.Context, id uuid.UUID, version int64) (err error) {
+	ctx, span := Start(ctx, "store.DeleteMerchant")
+	defer func() { End(span, err) }()
+	return s.Store.DeleteMerchant(ctx, id, version)
+}
+
+func (s *Store) GetMerchant(ctx context.Context, id uuid.UUID) (_ model.Merchant, err error) {
+	ctx, span := Start(ctx, "store.GetMerchant")
+	defer func() { End(span, err) }()
+	return s.Store.GetMerchant(ctx, id)
+}
+
+func (s *Store) GetMerchants(ctx context.Context, query model.MerchantQuery) (_ []model.Merchant, err error) {
+	ctx, span := Start(ctx, "store.GetMerchants")
+	defer func() { End(span, err) }()
+	return s.Store.GetMerchants(ctx, query)
+}
+
+func (s *Store) CreateTransaction(ctx context.Context, t model.Transaction) (_ model.Transaction, err error) {
+	ctx, span := Start(ctx, "store.CreateTransaction")
+	defer func() { End(span, err) }()
+	return s.Store.CreateTransaction(ctx, t)
+}
+
+func (s *Store) GetTransaction(ctx context.Context, id uuid.UUID) (_ model.Transaction, err error) {
+	ctx, span := Start(ctx, "store.GetTransaction")
+	defer func() { End(span, err) }()
+	return s.Store.GetTransaction(ctx, id)
+}
+
+func (s *Store) GetTransactions(ctx context.Context, query model.TransactionQuery) (_ []model.Transaction, err error) {
+	ctx, span := Start(ctx, "store.GetTransactions")
+	defer func() { End(span, err) }()
+	return s.Store.GetTransactions(ctx, query)
+}
+
+func (s *Store) DeleteTransactions(ctx context.Context, query model.TransactionQuery) (err error) {
+	ctx, span := Start(ctx, "store.DeleteTransactions")
+	defer func() { End(span, err) }()
+	return s.Store.DeleteTransactions(ctx, query)
+}
+
+func (s *Store) RestoreTransactions(ctx context.Context, transactions []model.Transaction) (_ int, err error) {
+	ctx, span := Start(ctx, "store.RestoreTransactions")
+	defer func() { End(span, err) }()
+	return s.Store.RestoreTransactions(ctx, transactions)
+}
+
+func (s *Store) EraseCustomer(ctx context.Context, e model.CustomerErasure) (_ model.Erasure, _ int, err error) {
+	ctx, span := Start(ctx, "store.EraseCustomer")
+	defer func() { End(span, err) }()
+	return s.Store.EraseCustomer(ctx, e)
+}
+
+func (s *Store) GetErasures(ctx context.Context) (_ []model.Erasure, err error) {
+	ctx, span := Start(ctx, "store.GetErasures")
+	defer func() { End(span, err) }()
+	return s.Store.GetErasures(ctx)
+}
+
+func (s *Store) AppendAudit(ctx context.Context, e model.AuditEntry) (_ model.AuditEntry, err error) {
+	ctx, span := Start(ctx, "store.AppendAudit")
+	defer func() { End(span, err) }()
+	return s.Store.AppendAudit(ctx, e)
+}
+
+func (s *Store) GetAuditEntries(ctx context.Context, query model.AuditQuery) (_ []model.AuditEntry, err error) {
+	ctx, span := Start(ctx, "store.GetAuditEntries")
+	defer func() { End(span, err) }()
+	return s.Store.GetAuditEntries(ctx, query)
+}
@@ -0,0 +1,99 @@
+// Package tracing records OpenTelemetry spans of the requests, the
+// controller and the store, and exports them as JSON to stdout or a file.
+// Trace context is propagated in W3C traceparent headers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ivaylo-todorov/payment-system/logging"
+	"github.com/ivaylo-todorov/payment-system/model"
+)
+
+const (
+	instrumentationName = "github.com/ivaylo-todorov/payment-system"
+	serviceName         = "payment-system"
+)
+
+// Propagator reads and writes the W3C traceparent and tracestate headers
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Setup installs the global tracer provider of the configured exporter.
+// The returned function flushes the remaining spans and closes the file.
+// Without an exporter spans are not recorded.
+func Setup(settings model.TracingSettings) (func(context.Context) error, error) {
+	var out io.Writer
+	var file *os.File
+
+	switch settings.Exporter {
+	case "", model.TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case model.TracingExporterStdout:
+		out = os.Stdout
+	case model.TracingExporterFile:
+		var err error
+		file, err = os.OpenFile(settings.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", settings.Exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Tracer is the tracer of the spans of the service, it follows the global
+// tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a span named name as a child of the span of ctx
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, options...)
+}
+
+// End ends span and marks it failed with err. The error message is
+// redacted, errors may quote customer data.
+func End(span trace.Span, err error) {
+	if err != nil {
+		message := logging.RedactString(err.Error())
+		span.AddEvent("exception", trace.WithAttributes(attribute.String("exception.message", message)))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
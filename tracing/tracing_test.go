@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ivaylo-todorov/payment-system/model"
+	"github.com/ivaylo-todorov/payment-system/model/controller"
+	"github.com/ivaylo-todorov/payment-system/store/memory"
+)
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	return recorder
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := []string{}
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func TestInstrumentControllerAndStore(t *testing.T) {
+	recorder := record(t)
+
+	mem, err := memory.NewMemory(nil)
+	require.NoError(t, err)
+	c, err := controller.NewController(model.ApplicationSettings{}, InstrumentStore(mem))
+	require.NoError(t, err)
+	traced := InstrumentController(c)
+
+	ctx, parent := Start(context.Background(), "request")
+	_, err = traced.CreateMerchants(ctx, model.Actor{}, []model.Merchant{
+		{Name: "name", Email: "merchant@example.com", Status: model.MerchantStatusActive},
+	})
+	require.NoError(t, err)
+	_, err = traced.GetMerchant(ctx, uuid.New())
+	assert.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Equal(t, []string{
+		"store.CreateMerchants",
+		"controller.CreateMerchants",
+		"store.GetMerchant",
+		"controller.GetMerchant",
+		"request",
+	}, spanNames(spans))
+
+	// the store spans are children of the controller spans, all in one trace
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, spans[4].SpanContext().SpanID(), spans[1].Parent().SpanID())
+	for _, s := range spans {
+		assert.Equal(t, spans[4].SpanContext().TraceID(), s.SpanContext().TraceID())
+	}
+
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+	assert.Equal(t, codes.Error, spans[3].Status().Code)
+}
+
+func TestEndRedactsErrors(t *testing.T) {
+	recorder := record(t)
+
+	_, span := Start(context.Background(), "erasure")
+	End(span, errors.New("no transactions of customer@example.com"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "no transactions of [REDACTED]", spans[0].Status().Description)
+}
+
+func TestSetupFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	file := filepath.Join(t.TempDir(), "traces.json")
+	stop, err := Setup(model.TracingSettings{Exporter: model.TracingExporterFile, File: file})
+	require.NoError(t, err)
+
+	_, span := Start(context.Background(), "exported")
+	span.End()
+	require.NoError(t, stop(context.Background()))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"exported"`)
+
+	stop, err = Setup(model.TracingSettings{Exporter: model.TracingExporterNone})
+	require.NoError(t, err)
+	assert.NoError(t, stop(context.Background()))
+}